@@ -134,6 +134,13 @@ func (s *store) AppsDestroy(app *App) error {
 	return appsDestroy(s.db, app)
 }
 
+// AppsLock obtains an exclusive lock on the app, which is held until unlock is
+// called. This can be used to serialize changes to an app across multiple
+// Empire instances.
+func (s *store) AppsLock(app *App) (unlock func() error, err error) {
+	return appsLock(s.db, app)
+}
+
 // AppID returns a scope to find an app by id.
 func AppID(id string) func(*gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
@@ -197,6 +204,26 @@ func (s *appsService) AppsFindOrCreateByRepo(repo string) (*App, error) {
 	return s.store.AppsCreate(a)
 }
 
+// appsLock locks the app row within a new transaction. The lock is released
+// when the transaction is committed. A "for no key update" lock is used so that
+// inserts that reference the app through a foreign key aren't blocked while the
+// lock is held.
+func appsLock(db *gorm.DB, app *App) (func() error, error) {
+	t := db.Begin()
+	if err := t.Error; err != nil {
+		return nil, err
+	}
+
+	if err := t.Exec(`select id from apps where id = ? for no key update`, app.ID).Error; err != nil {
+		t.Rollback()
+		return nil, err
+	}
+
+	return func() error {
+		return t.Commit().Error
+	}, nil
+}
+
 // AppsCreate inserts the app into the database.
 func appsCreate(db *gorm.DB, app *App) (*App, error) {
 	return app, db.Create(app).Error
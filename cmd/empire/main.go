@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/codegangsta/cli"
@@ -23,6 +24,12 @@ const (
 	FlagGithubOrg    = "github.organization"
 	FlagGithubApiURL = "github.api.url"
 
+	FlagMetrics     = "metrics"
+	FlagMetricsApps = "metrics.apps"
+
+	FlagConfigsSchedulerInterval = "configs.scheduler.interval"
+	FlagConfigsConvertLegacyJSON = "configs.convert-legacy-json"
+
 	FlagDBPath = "path"
 	FlagDB     = "db"
 
@@ -88,6 +95,28 @@ var Commands = []cli.Command{
 				Usage:  "The URL to use when talking to GitHub.",
 				EnvVar: "EMPIRE_GITHUB_API_URL",
 			},
+			cli.BoolFlag{
+				Name:   FlagMetrics,
+				Usage:  "Whether to serve config metrics at /metrics, which requires an access token",
+				EnvVar: "EMPIRE_METRICS",
+			},
+			cli.StringSliceFlag{
+				Name:   FlagMetricsApps,
+				Value:  &cli.StringSlice{},
+				Usage:  "The comma separated apps to include in metrics. Defaults to all apps",
+				EnvVar: "EMPIRE_METRICS_APPS",
+			},
+			cli.DurationFlag{
+				Name:   FlagConfigsSchedulerInterval,
+				Value:  time.Minute,
+				Usage:  "How often to apply scheduled config changes that have come due. 0 disables the scheduler",
+				EnvVar: "EMPIRE_CONFIGS_SCHEDULER_INTERVAL",
+			},
+			cli.BoolFlag{
+				Name:   FlagConfigsConvertLegacyJSON,
+				Usage:  "Whether to convert configs stored as JSON by old installs to hstore on startup",
+				EnvVar: "EMPIRE_CONFIGS_CONVERT_LEGACY_JSON",
+			},
 		}, append(EmpireFlags, DBFlags...)...),
 		Action: runServer,
 	},
@@ -7,6 +7,7 @@ import (
 	"github.com/codegangsta/cli"
 	"github.com/remind101/empire"
 	"github.com/remind101/empire/server"
+	"golang.org/x/net/context"
 )
 
 func runServer(c *cli.Context) {
@@ -21,6 +22,18 @@ func runServer(c *cli.Context) {
 		log.Fatal(err)
 	}
 
+	if c.Bool(FlagConfigsConvertLegacyJSON) {
+		n, err := e.ConfigsConvertLegacyJSON()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Converted %d legacy JSON configs", n)
+	}
+
+	if interval := c.Duration(FlagConfigsSchedulerInterval); interval > 0 {
+		go e.RunConfigsScheduler(context.Background(), interval)
+	}
+
 	s := newServer(c, e)
 	log.Printf("Starting on port %s", port)
 	log.Fatal(http.ListenAndServe(":"+port, s))
@@ -32,6 +45,8 @@ func newServer(c *cli.Context, e *empire.Empire) http.Handler {
 	opts.GitHub.ClientSecret = c.String(FlagGithubSecret)
 	opts.GitHub.Organization = c.String(FlagGithubOrg)
 	opts.GitHub.ApiURL = c.String(FlagGithubApiURL)
+	opts.Metrics.Enabled = c.Bool(FlagMetrics)
+	opts.Metrics.Apps = c.StringSlice(FlagMetricsApps)
 
 	return server.New(e, opts)
 }
@@ -1,16 +1,50 @@
 package empire
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/lib/pq/hstore"
+	"github.com/remind101/pkg/timex"
 	"golang.org/x/net/context"
 )
 
+var (
+	// ErrConfigConflict is returned when a compare-and-set fails because the
+	// current value of the variable doesn't match the expected value.
+	ErrConfigConflict = errors.New("Config var was modified, expected value does not match.")
+
+	// ErrConfigNotFound is returned when a config with the given id doesn't
+	// exist.
+	ErrConfigNotFound = errors.New("Config could not be found.")
+
+	// ErrStaleConfig is returned when a copy of an apps config no longer
+	// matches the current config in the database.
+	ErrStaleConfig = errors.New("Config does not match the current config.")
+
+	// ErrConfigCorrupted is returned when integrity checking is enabled and
+	// the vars, expirations or secret flags of a config don't match the
+	// fingerprint that was stored when it was created.
+	ErrConfigCorrupted = errors.New("Config vars do not match the stored fingerprint.")
+
+	// ErrEmptyPrefix is returned when unsetting variables by prefix without a
+	// prefix, which would remove every variable.
+	ErrEmptyPrefix = errors.New("A prefix is required to unset config vars by prefix.")
+)
+
 // Config represents a collection of environment variables.
 type Config struct {
 	ID   string
@@ -18,172 +52,1994 @@ type Config struct {
 
 	AppID string
 	App   *App
+
+	CreatedAt *time.Time
+
+	// The time that variables set with ConfigsSetWithTTL expire.
+	Expires Expirations
+
+	// Variables that have been explicitly marked as secret, or not, and
+	// variables that were classified as secrets when they were set.
+	SecretFlags SecretFlags
+
+	// The Fingerprint of the config when it was created. Configs
+	// created before fingerprints were stored don't have one.
+	VarsFingerprint *string
+
+	// The config that this config was created from. It's nil for the
+	// first config of an app, configs created before parents were
+	// recorded, and configs whose parent has since been removed, like by
+	// ConfigsCoalesceHistory.
+	ParentID *string
+
+	// If the config restored the vars of an earlier config, like a
+	// rollback, the id of that config.
+	RestoresID *string
+
+	// The name of the user that made the change, if it was made by a
+	// user.
+	Actor string
+}
+
+// Set created_at and the fingerprint of the vars before inserting. The store
+// sets the fingerprint itself, before the vars are encrypted, so it's only
+// set here if it hasn't been already.
+func (c *Config) BeforeCreate() error {
+	t := timex.Now()
+	c.CreatedAt = &t
+
+	if c.VarsFingerprint == nil {
+		fingerprint := c.Fingerprint()
+		c.VarsFingerprint = &fingerprint
+	}
+
+	return nil
+}
+
+// checkIntegrity returns ErrConfigCorrupted if the vars, expirations or secret
+// flags no longer match the fingerprint that was stored when the config was
+// created. Configs without a stored fingerprint are assumed to be intact.
+func (c *Config) checkIntegrity() error {
+	if c.VarsFingerprint != nil && *c.VarsFingerprint != c.Fingerprint() {
+		return ErrConfigCorrupted
+	}
+
+	return nil
+}
+
+// configJSON is the JSON representation of a Config.
+type configJSON struct {
+	ID          string      `json:"id"`
+	AppID       string      `json:"app_id"`
+	CreatedAt   *time.Time  `json:"created_at,omitempty"`
+	Expires     Expirations `json:"expires,omitempty"`
+	SecretFlags SecretFlags `json:"secret_flags,omitempty"`
+	Vars        Vars        `json:"vars"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. The output is byte
+// stable, with vars ordered by name.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&configJSON{
+		ID:          c.ID,
+		AppID:       c.AppID,
+		CreatedAt:   c.CreatedAt,
+		Expires:     c.Expires,
+		SecretFlags: c.SecretFlags,
+		Vars:        c.Vars,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	var v configJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	c.ID = v.ID
+	c.AppID = v.AppID
+	c.CreatedAt = v.CreatedAt
+	c.Expires = v.Expires
+	c.SecretFlags = v.SecretFlags
+	c.Vars = v.Vars
+
+	return nil
 }
 
 // NewConfig initializes a new config based on the old config, with the new
-// variables provided.
+// variables provided. Variables in the old config that have expired are
+// dropped, and secret flags are kept for the variables that are still set.
+// The old config is the parent of the new config, if it's been stored.
 func NewConfig(old *Config, vars Vars) *Config {
 	v := mergeVars(old.Vars, vars)
 
+	var parentID *string
+	if old.ID != "" {
+		id := old.ID
+		parentID = &id
+	}
+
 	return &Config{
-		AppID: old.AppID,
-		Vars:  v,
+		AppID:       old.AppID,
+		Vars:        v,
+		Expires:     mergeExpirations(old.Expires, vars, v, timex.Now()),
+		SecretFlags: mergeSecretFlags(old.SecretFlags, nil, v),
+		ParentID:    parentID,
 	}
 }
 
-// Variable represents the name of an environment variable.
-type Variable string
+// String implements the fmt.Stringer interface. Values are masked, so that
+// secrets aren't leaked if a Config is accidentally logged. Use Unmasked if the
+// real values are needed.
+func (c *Config) String() string {
+	return fmt.Sprintf("Config{ID:%s AppID:%s Vars:%s}", c.ID, c.AppID, c.Vars)
+}
 
-// Vars represents a variable -> value mapping.
-type Vars map[Variable]*string
+// Unmasked returns a fmt.Stringer that, unlike String, includes the values of
+// the variables.
+func (c *Config) Unmasked() fmt.Stringer {
+	return unmaskedVars(c.Vars)
+}
 
-// Scan implements the sql.Scanner interface.
-func (v *Vars) Scan(src interface{}) error {
-	h := hstore.Hstore{}
-	if err := h.Scan(src); err != nil {
-		return err
+// WithDefaults returns the effective variables for the config, with defaults
+// added for any variables that the config doesn't set. Variables set in the
+// config, even to an empty string, always take precedence over defaults.
+func (c *Config) WithDefaults(defaults Vars) Vars {
+	vars := make(Vars)
+
+	for n, v := range defaults {
+		if v != nil {
+			vars[n] = v
+		}
 	}
 
-	vars := make(Vars)
+	for n, v := range c.Vars {
+		vars[n] = v
+	}
 
-	for k, v := range h.Map {
-		// Go reuses the same address space for v, so &v.String would always
-		// return the same address
-		tmp := v.String
-		vars[Variable(k)] = &tmp
+	return vars
+}
+
+// Env returns the config as a sorted list of KEY=value strings, suitable for
+// the environment of a process. Variables in extra take precedence over those
+// in the config, and a nil value in extra removes the variable. Variables that
+// have expired are never included.
+func (c *Config) Env(extra Vars) []string {
+	vars := mergeVars(c.Unexpired(timex.Now()).Vars, extra)
+
+	env := make([]string, 0, len(vars))
+	for _, k := range vars.Keys() {
+		env = append(env, fmt.Sprintf("%s=%s", k, *vars[k]))
 	}
 
-	*v = vars
+	return env
+}
 
-	return nil
+// ValueSizes returns the size, in bytes, of the value of each variable in the
+// config.
+func (c *Config) ValueSizes() map[Variable]int {
+	sizes := make(map[Variable]int, len(c.Vars))
+
+	for k, v := range c.Vars {
+		if v == nil {
+			sizes[k] = 0
+			continue
+		}
+		sizes[k] = len(*v)
+	}
+
+	return sizes
 }
 
-// Value implements the driver.Value interface.
-func (v Vars) Value() (driver.Value, error) {
-	m := make(map[string]sql.NullString)
+// Size returns the total size, in bytes, of the names and values of every
+// variable in the config.
+func (c *Config) Size() int {
+	var size int
+	for k, v := range c.ValueSizes() {
+		size += len(k) + v
+	}
+	return size
+}
+
+// StoredSize returns the size, in bytes, of the names and values of every
+// variable in the config as they're stored when values are encrypted with enc,
+// which includes the overhead of encryption and encoding. If enc is nil, this
+// is the same as Size.
+func (c *Config) StoredSize(enc Encryptor) (int, error) {
+	if enc == nil {
+		return c.Size(), nil
+	}
+
+	var size int
+	for k, v := range c.Vars {
+		size += len(k)
 
-	for k, v := range v {
-		m[string(k)] = sql.NullString{
-			Valid:  true,
-			String: *v,
+		// Empty values are stored as is, see encryptVars.
+		if v == nil || *v == "" {
+			continue
 		}
+
+		ciphertext, err := enc.Encrypt([]byte(*v))
+		if err != nil {
+			return 0, err
+		}
+		size += len(ciphertext)
+	}
+
+	return size, nil
+}
+
+// configRowOverhead is the size, in bytes, of a configs row without its hstore
+// columns: the tuple header, id, app_id, created_at, seq, vars_fingerprint,
+// parent_id and restores_id, and the header of an empty actor.
+const configRowOverhead = 24 + 16 + 16 + 8 + 8 + 65 + 16 + 16 + 1
+
+// EstimatedRowSize returns an estimate of the size, in bytes, of the row that
+// the config is stored in, before compression. Each hstore column has a header,
+// plus an entry for each pair, on top of the size of the keys and values.
+func (c *Config) EstimatedRowSize() int {
+	return c.estimatedRowSize(c.Size())
+}
+
+// estimatedRowSize is like EstimatedRowSize, but for a config whose vars take
+// up size bytes when they're stored, like the StoredSize of an encrypted
+// config.
+func (c *Config) estimatedRowSize(size int) int {
+	size = configRowOverhead + hstoreSize(len(c.Vars), size)
+
+	var expires int
+	for n := range c.Expires {
+		expires += len(n) + len(time.RFC3339Nano)
 	}
+	size += hstoreSize(len(c.Expires), expires)
 
-	h := hstore.Hstore{
-		Map: m,
+	var flags int
+	for n, f := range c.SecretFlags {
+		flags += len(n) + len(f)
 	}
+	size += hstoreSize(len(c.SecretFlags), flags)
 
-	return h.Value()
+	return size
 }
 
-// ConfigsQuery is a Scope implementation for common things to filter releases
-// by.
-type ConfigsQuery struct {
-	// If provided, returns finds the config with the given id.
-	ID *string
+// hstoreSize returns the size, in bytes, of an hstore with the given number of
+// pairs, whose keys and values have a combined size of data bytes.
+func hstoreSize(pairs, data int) int {
+	if pairs == 0 {
+		return 0
+	}
 
-	// If provided, filters configs for the given app.
-	App *App
+	// The varlena header and pair count, then two offsets per pair.
+	return 4 + 4 + 8*pairs + data
 }
 
-// Scope implements the Scope interface.
-func (q ConfigsQuery) Scope(db *gorm.DB) *gorm.DB {
-	var scope ComposedScope
+// Fingerprint returns a hash of the vars within the config, along with their
+// expirations and secret flags. Configs with the same vars, expirations and
+// flags always have the same fingerprint, which makes it cheap to check
+// whether a copy of a config is stale. Configs without any expirations or
+// flags are hashed by their vars alone, so they have the same fingerprint as
+// one stored before expirations and flags were included.
+func (c *Config) Fingerprint() string {
+	v, _ := c.Vars.Value()
+	sum := sha256.Sum256(v.([]byte))
 
-	if q.ID != nil {
-		scope = append(scope, ID(*q.ID))
+	if len(c.Expires) == 0 && len(c.SecretFlags) == 0 {
+		return hex.EncodeToString(sum[:])
 	}
 
-	if q.App != nil {
-		scope = append(scope, ForApp(q.App))
+	// Each part is hashed on its own, so that the boundaries between them
+	// are unambiguous.
+	h := sha256.New()
+	h.Write(sum[:])
+	for _, part := range []driver.Valuer{c.Expires, c.SecretFlags} {
+		v, _ := part.Value()
+		b, _ := v.([]byte)
+		sum := sha256.Sum256(b)
+		h.Write(sum[:])
 	}
 
-	return scope.Scope(db)
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-// ConfigsFirst returns the first matching config.
-func (s *store) ConfigsFirst(scope Scope) (*Config, error) {
-	var config Config
-	scope = ComposedScope{Order("created_at desc"), scope}
-	return &config, s.First(scope, &config)
+// RedactedForStorage returns a copy of the config, for storing somewhere that
+// secrets should never appear in clear, like an audit replica, with the value
+// of each secret variable replaced by its HMAC-SHA256 using key. The hashes
+// can't be reversed, but equal values have equal hashes, so it's still
+// possible to tell when a value changed. A key is used, rather than a plain
+// hash, so that short secrets can't be found by hashing guesses.
+func (c *Config) RedactedForStorage(key []byte) *Config {
+	vars := make(Vars, len(c.Vars))
+
+	for n, v := range c.Vars {
+		if c.IsSecret(n) {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(*v))
+			h := "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+			v = &h
+		}
+		vars[n] = v
+	}
+
+	r := *c
+	r.Vars = vars
+	r.VarsFingerprint = nil
+	return &r
 }
 
-// ConfigsCreate persists the Config.
-func (s *store) ConfigsCreate(config *Config) (*Config, error) {
-	return configsCreate(s.db, config)
+// Match returns the variables whose names match the glob pattern, where *
+// matches any sequence of characters and ? matches any single character. See
+// path.Match for the full syntax. A malformed pattern matches nothing.
+func (c *Config) Match(pattern string) Vars {
+	vars := make(Vars)
+
+	for k, v := range c.Vars {
+		if ok, _ := path.Match(pattern, string(k)); ok {
+			vars[k] = v
+		}
+	}
+
+	return vars
 }
 
-// ConfigsCreate inserts a Config in the database.
-func configsCreate(db *gorm.DB, config *Config) (*Config, error) {
-	return config, db.Create(config).Error
+// Group buckets the variables in the config by their prefix before the first
+// separator, like SMTP for SMTP_HOST and SMTP_TLS_CERT, so that related
+// variables can be shown together. Variables without a prefix are grouped
+// under the empty string.
+func (c *Config) Group(separator string) map[string]Vars {
+	groups := make(map[string]Vars)
+
+	for n, v := range c.Vars {
+		var prefix string
+		if separator != "" {
+			if i := strings.Index(string(n), separator); i > 0 {
+				prefix = string(n)[:i]
+			}
+		}
+
+		if groups[prefix] == nil {
+			groups[prefix] = make(Vars)
+		}
+		groups[prefix][n] = v
+	}
+
+	return groups
 }
 
-type configsService struct {
-	store    *store
-	releases *releasesService
+// globEscape escapes the characters in s that have special meaning in a glob
+// pattern, so that it only matches itself.
+func globEscape(s string) string {
+	return globEscaper.Replace(s)
 }
 
-func (s *configsService) ConfigsApply(ctx context.Context, app *App, vars Vars) (*Config, error) {
-	old, err := s.ConfigsCurrent(app)
-	if err != nil {
-		return nil, err
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// Partition splits the variables in the config into those that are secret and
+// those that aren't, as classified by IsSecret. The config isn't modified.
+func (c *Config) Partition() (secret Vars, plain Vars) {
+	return c.partition(DefaultSecretDetector)
+}
+
+// partition is like Partition, but variables without a secret flag are
+// classified with detect.
+func (c *Config) partition(detect SecretDetector) (secret Vars, plain Vars) {
+	secret, plain = make(Vars), make(Vars)
+
+	for k, v := range c.Vars {
+		if c.isSecretWith(k, detect) {
+			secret[k] = v
+		} else {
+			plain[k] = v
+		}
 	}
 
-	c, err := s.store.ConfigsCreate(NewConfig(old, vars))
-	if err != nil {
-		return c, err
+	return secret, plain
+}
+
+// IsSecret returns true if the value of the named variable should be treated
+// as a secret. A secret flag for the variable takes precedence over
+// DefaultSecretDetector.
+func (c *Config) IsSecret(name Variable) bool {
+	return c.isSecretWith(name, DefaultSecretDetector)
+}
+
+// isSecretWith is like IsSecret, but uses detect in place of
+// DefaultSecretDetector.
+func (c *Config) isSecretWith(name Variable, detect SecretDetector) bool {
+	return isSecret(name, c.Vars[name], c.SecretFlags[name], detect)
+}
+
+// isSecret returns true if a variable with the value v and flag is a secret,
+// using detect if it doesn't have a flag.
+func isSecret(name Variable, v *string, flag SecretFlag, detect SecretDetector) bool {
+	if v == nil {
+		return false
 	}
 
-	release, err := s.store.ReleasesFirst(ReleasesQuery{App: app})
-	if err != nil {
-		if err == gorm.RecordNotFound {
-			err = nil
+	switch flag {
+	case SecretFlagSecret, SecretFlagAuto:
+		return true
+	case SecretFlagPlain:
+		return false
+	}
+
+	return detect(name, *v)
+}
+
+// Variable represents the name of an environment variable.
+type Variable string
+
+// SecretPattern matches the names of variables that are likely to hold
+// secret values, like api keys, tokens and passwords.
+var SecretPattern = regexp.MustCompile(`(?i)(SECRET|PASSWORD|PASSWD|TOKEN|KEY|CREDENTIAL|PRIVATE)`)
+
+// SecretDetector determines whether the value of a variable is a secret.
+type SecretDetector func(name Variable, value string) bool
+
+// DefaultSecretDetector is the SecretDetector used to classify the variables
+// within a Config. The default considers any variable whose name matches
+// SecretPattern to be a secret.
+var DefaultSecretDetector SecretDetector = func(name Variable, value string) bool {
+	return SecretPattern.MatchString(string(name))
+}
+
+// Vars represents a variable -> value mapping.
+type Vars map[Variable]*string
+
+// NewVars returns Vars for the given names and values, after ensuring that
+// every name is valid. If any names are invalid, a VarErrors with an error for
+// each of them is returned.
+func NewVars(pairs map[string]string) (Vars, error) {
+	vars := make(Vars, len(pairs))
+	for k, v := range pairs {
+		v := v
+		vars[Variable(k)] = &v
+	}
+
+	if errs := validateVars(vars, []Validator{ValidateVarName}); len(errs) > 0 {
+		return nil, VarErrors(errs)
+	}
+
+	return vars, nil
+}
+
+// MarshalJSON implements the json.Marshaler interface, writing the variables as
+// an object with keys in sorted order. Unset variables are written as null.
+func (v Vars) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+
+	for i, k := range v.Keys() {
+		if i > 0 {
+			buf.WriteByte(',')
 		}
 
-		return c, err
+		key, err := json.Marshal(string(k))
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(v[k])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
 	}
 
-	keys := make([]string, 0, len(vars))
-	for k := range vars {
-		keys = append(keys, string(k))
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// String implements the fmt.Stringer interface. Values are masked, so that
+// secrets aren't leaked if Vars are accidentally logged.
+func (v Vars) String() string {
+	return v.format(true)
+}
+
+// format formats the variables as a list of KEY=value pairs, sorted by name,
+// optionally masking the values.
+func (v Vars) format(mask bool) string {
+	pairs := make([]string, 0, len(v))
+
+	for _, k := range v.Keys() {
+		var value string
+		switch {
+		case v[k] == nil:
+			value = "<unset>"
+		case mask:
+			value = "***"
+		default:
+			value = *v[k]
+		}
+
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, value))
 	}
 
-	desc := fmt.Sprintf("Set %s config vars", strings.Join(keys, ","))
+	return "{" + strings.Join(pairs, " ") + "}"
+}
 
-	// Create new release based on new config and old slug
-	_, err = s.releases.ReleasesCreate(ctx, &Release{
-		App:         release.App,
-		Config:      c,
-		Slug:        release.Slug,
-		Description: desc,
-	})
-	return c, err
+// unmaskedVars is a fmt.Stringer that includes the values of the variables.
+type unmaskedVars Vars
+
+func (v unmaskedVars) String() string {
+	return Vars(v).format(false)
 }
 
-// Returns configs for latest release or the latest configs if there are no releases.
-func (s *configsService) ConfigsCurrent(app *App) (*Config, error) {
-	r, err := s.store.ReleasesFirst(ReleasesQuery{App: app})
+// Keys returns the names of the variables, sorted.
+func (v Vars) Keys() []Variable {
+	keys := make([]Variable, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+
+	sort.Sort(variablesByName(keys))
+
+	return keys
+}
+
+// variablesByName implements sort.Interface to sort variables by name.
+type variablesByName []Variable
+
+func (s variablesByName) Len() int           { return len(s) }
+func (s variablesByName) Less(i, j int) bool { return s[i] < s[j] }
+func (s variablesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Scan implements the sql.Scanner interface. NULL values within the hstore are
+// scanned as empty strings, so that a NULL in an old row never reaches a
+// process as a nil value. Changes that need to keep unset variables, like
+// proposals, are stored as VarsUpdate instead. Vars that were stored as a JSON
+// object by old installs are decoded as JSON.
+func (v *Vars) Scan(src interface{}) error {
+	vars, err := scanVars(src, false)
 	if err != nil {
-		if err == gorm.RecordNotFound {
-			// It's possible to have config without releases, this handles that.
-			c, err := s.store.ConfigsFirst(ConfigsQuery{App: app})
-			if err != nil {
-				if err == gorm.RecordNotFound {
-					return s.store.ConfigsCreate(&Config{
-						App:  app,
-						Vars: make(Vars),
-					})
-				}
-				return nil, err
-			}
+		return err
+	}
+
+	*v = vars
+
+	return nil
+}
+
+// VarsUpdate is a set of changes to vars, like the ones passed to
+// ConfigsApply, where a nil value unsets the variable. Unlike Vars, NULL values
+// within the hstore are scanned as nil, so unset variables survive being
+// stored.
+type VarsUpdate Vars
+
+// Scan implements the sql.Scanner interface.
+func (v *VarsUpdate) Scan(src interface{}) error {
+	vars, err := scanVars(src, true)
+	if err != nil {
+		return err
+	}
 
-			return c, nil
+	*v = VarsUpdate(vars)
+
+	return nil
+}
+
+// Value implements the driver.Value interface.
+func (v VarsUpdate) Value() (driver.Value, error) {
+	return Vars(v).Value()
+}
+
+// MarshalJSON implements the json.Marshaler interface, like Vars.
+func (v VarsUpdate) MarshalJSON() ([]byte, error) {
+	return Vars(v).MarshalJSON()
+}
+
+// String implements the fmt.Stringer interface, masking values like Vars.
+func (v VarsUpdate) String() string {
+	return Vars(v).String()
+}
+
+// scanVars scans an hstore into Vars. If keepNull is false, NULL values are
+// scanned as empty strings. Legacy JSON objects are decoded as JSON, rather
+// than being parsed as a corrupt hstore.
+func scanVars(src interface{}, keepNull bool) (Vars, error) {
+	if s, ok := src.(string); ok {
+		src = []byte(s)
+	}
+
+	if b, ok := src.([]byte); ok && isJSONObject(b) {
+		return decodeLegacyJSONVars(b, keepNull)
+	}
+
+	vars := make(Vars)
+
+	h := hstore.Hstore{}
+	if err := h.Scan(src); err != nil {
+		return nil, err
+	}
+
+	for k, v := range h.Map {
+		if !v.Valid && keepNull {
+			vars[Variable(k)] = nil
+			continue
 		}
 
+		// Go reuses the same address space for v, so &v.String would always
+		// return the same address
+		tmp := v.String
+		vars[Variable(k)] = &tmp
+	}
+
+	return vars, nil
+}
+
+// decodeLegacyJSONVars decodes vars from a JSON object, like {"KEY":"value"},
+// which is how some old installs stored configs. If keepNull is false, null
+// values are decoded as empty strings, like they are when they're scanned from
+// an hstore.
+func decodeLegacyJSONVars(b []byte, keepNull bool) (Vars, error) {
+	var m map[Variable]*string
+	if err := json.Unmarshal(b, &m); err != nil {
 		return nil, err
 	}
 
-	return r.Config, nil
+	vars := make(Vars, len(m))
+	for k, v := range m {
+		if v == nil && !keepNull {
+			v = new(string)
+		}
+		vars[k] = v
+	}
+
+	return vars, nil
+}
+
+// legacyJSONConfigsSQL selects the configs whose vars are stored as a JSON
+// object. Postgres never outputs an hstore that starts with {, so this only
+// matches configs written as JSON text.
+const legacyJSONConfigsSQL = `select id, vars::text from configs where left(ltrim(vars::text), 1) = '{'`
+
+// ConfigsConvertLegacyJSON rewrites every config whose vars are stored as a
+// JSON object in the hstore format, returning the number of configs that were
+// converted. Legacy configs can be read without being converted, so this is
+// only a cleanup step.
+func (s *store) ConfigsConvertLegacyJSON() (int, error) {
+	t := s.db.Begin()
+
+	rows, err := t.Raw(legacyJSONConfigsSQL).Rows()
+	if err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	converted := make(map[string]Vars)
+	for rows.Next() {
+		var (
+			id  string
+			raw []byte
+		)
+		if err := rows.Scan(&id, &raw); err != nil {
+			rows.Close()
+			t.Rollback()
+			return 0, err
+		}
+
+		vars, err := decodeLegacyJSONVars(raw, false)
+		if err != nil {
+			rows.Close()
+			t.Rollback()
+			return 0, fmt.Errorf("config %s: %v", id, err)
+		}
+		converted[id] = vars
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	for id, vars := range converted {
+		if err := t.Exec(`update configs set vars = ? where id = ?`, vars, id).Error; err != nil {
+			t.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	return len(converted), nil
+}
+
+// isJSONObject returns true if b looks like a JSON object. Postgres always
+// quotes hstore keys, so an hstore value can never start with {.
+func isJSONObject(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && b[0] == '{'
+}
+
+// Value implements the driver.Value interface. Unset variables are stored as
+// NULL values within the hstore. Variables are written in sorted order, so
+// equal Vars always produce the same bytes.
+func (v Vars) Value() (driver.Value, error) {
+	parts := make([]string, 0, len(v))
+
+	for _, k := range v.Keys() {
+		value := "NULL"
+		if v[k] != nil {
+			value = hstoreQuote(*v[k])
+		}
+
+		parts = append(parts, hstoreQuote(string(k))+"=>"+value)
+	}
+
+	return []byte(strings.Join(parts, ",")), nil
+}
+
+// hstoreQuote quotes s as an hstore key or value.
+func hstoreQuote(s string) string {
+	return `"` + hstoreEscaper.Replace(s) + `"`
+}
+
+var hstoreEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ConfigsQuery is a Scope implementation for common things to filter releases
+// by.
+type ConfigsQuery struct {
+	// If provided, returns finds the config with the given id.
+	ID *string
+
+	// If provided, filters configs for the given app.
+	App *App
+
+	// If greater than 0, at most this many configs are returned.
+	Limit int
+}
+
+// Scope implements the Scope interface.
+func (q ConfigsQuery) Scope(db *gorm.DB) *gorm.DB {
+	var scope ComposedScope
+
+	if q.ID != nil {
+		scope = append(scope, ID(*q.ID))
+	}
+
+	if q.App != nil {
+		scope = append(scope, ForApp(q.App))
+	}
+
+	if q.Limit > 0 {
+		scope = append(scope, ScopeFunc(func(db *gorm.DB) *gorm.DB {
+			return db.Limit(q.Limit)
+		}))
+	}
+
+	return scope.Scope(db)
+}
+
+// ConfigsFirst returns the first matching config. Configs created within the
+// same instant are ordered by their insertion sequence, so the most recently
+// inserted config is always returned first.
+func (s *store) ConfigsFirst(scope Scope) (*Config, error) {
+	var config Config
+	defer func(start time.Time) { s.logSlow("ConfigsFirst", config.AppID, start) }(time.Now())
+
+	scope = ComposedScope{Order("created_at desc, seq desc"), scope}
+	if err := s.First(scope, &config); err != nil {
+		return &config, err
+	}
+
+	return &config, s.openConfig(&config)
+}
+
+// currentConfigSQL is a subquery that selects the id of the current config for
+// the app aliased as a, which is the config for the latest release, or the
+// latest config if the app hasn't been released.
+const currentConfigSQL = `coalesce(
+	(select config_id from releases where app_id = a.id order by version desc limit 1),
+	(select id from configs where app_id = a.id order by created_at desc, seq desc limit 1)
+)`
+
+// ConfigsCurrent returns the current config for the app.
+func (s *store) ConfigsCurrent(app *App) (*Config, error) {
+	defer s.logSlow("ConfigsCurrent", app.ID, time.Now())
+
+	var config Config
+	if err := s.db.Where(`id = (select `+currentConfigSQL+` from apps a where a.id = ?)`, app.ID).First(&config).Error; err != nil {
+		return &config, err
+	}
+
+	return &config, s.openConfig(&config)
+}
+
+// ConfigsCurrentVars returns only the named variables from the current config
+// for the app. Only the requested keys are selected from the hstore, so the
+// rest of the config never leaves the database. Variables that aren't set, or
+// have expired, are absent from the result.
+func (s *store) ConfigsCurrentVars(app *App, names []Variable) (Vars, error) {
+	defer s.logSlow("ConfigsCurrentVars", app.ID, time.Now())
+
+	vars, err := configsCurrentVars(s.db, app, names, timex.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decryptVars(app.ID, vars)
+}
+
+// ConfigsCurrentKeys returns the sorted names of the variables in the current
+// config for the app, from ConfigsCurrentMeta, so values never leave the
+// database. Expired variables are not included.
+func (s *store) ConfigsCurrentKeys(app *App) ([]Variable, error) {
+	meta, err := s.ConfigsCurrentMeta(app)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Variable, 0, len(meta.Vars))
+	for _, v := range meta.Vars {
+		keys = append(keys, v.Name)
+	}
+
+	return keys, nil
+}
+
+// ConfigsCurrentForApps returns the current config for each of the apps, keyed
+// by app name, using a single query. Apps without a config are not included.
+func (s *store) ConfigsCurrentForApps(apps []*App) (map[string]*Config, error) {
+	return configsCurrentForApps(s.db, apps, s.openConfig)
+}
+
+// ConfigsStats returns the size of the current config for every app, using a
+// single query.
+func (s *store) ConfigsStats() ([]*ConfigStats, error) {
+	return configsStats(s.db)
+}
+
+// ConfigsScanAll calls check with the current config for every app, one at a
+// time, and returns the errors that check returned, keyed by app name. Configs
+// are read from a single read only query as they're checked, rather than all
+// being loaded up front.
+func (s *store) ConfigsScanAll(check func(app string, c *Config) []error) (map[string][]error, error) {
+	return configsScanAll(s.db, s.decryptConfig, check)
+}
+
+// ConfigsSizeStats returns the distribution of the number of variables and
+// size of the current config across all apps, along with the number of apps
+// with more than threshold variables. The aggregation is done in a single
+// query, but it reads every variable in every apps current config, so expect
+// it to take a while on installs with many large configs.
+func (s *store) ConfigsSizeStats(threshold int) (*SizeStats, error) {
+	return configsSizeStats(s.db, threshold)
+}
+
+// ConfigsAppsMissingVar returns the sorted names of the apps whose current
+// config doesn't set the variable, sets it to an empty string, or has let it
+// expire. Apps without a config are included. This is done in a single query.
+func (s *store) ConfigsAppsMissingVar(name Variable) ([]string, error) {
+	return configsAppsMissingVar(s.db, name, timex.Now())
+}
+
+// ConfigsVarPrevalence returns the number of apps whose current config
+// contains each variable name. If limit is greater than 0, only the limit most
+// common variables are returned. The counting is done in a single query, and
+// only the keys of each config are read.
+func (s *store) ConfigsVarPrevalence(limit int) (map[Variable]int, error) {
+	return configsVarPrevalence(s.db, limit)
+}
+
+// Configs returns all configs matching the scope, newest first.
+func (s *store) Configs(scope Scope) ([]*Config, error) {
+	var configs []*Config
+	scope = ComposedScope{Order("created_at desc, seq desc"), scope}
+	if err := s.Find(scope, &configs); err != nil {
+		return configs, err
+	}
+
+	for _, c := range configs {
+		if err := s.openConfig(c); err != nil {
+			return configs, err
+		}
+	}
+
+	return configs, nil
+}
+
+// checkIntegrity verifies the config against its stored fingerprint, if
+// integrity checking is enabled.
+func (s *store) checkIntegrity(c *Config) error {
+	if !s.verifyConfigs {
+		return nil
+	}
+
+	return c.checkIntegrity()
+}
+
+// ConfigsCreate persists the Config.
+func (s *store) ConfigsCreate(config *Config) (*Config, error) {
+	return s.ConfigsCreateWith(config, nil)
+}
+
+// ConfigsCreateWith inserts the config, then calls fn, if provided, within the
+// same transaction, so that changes that depend on the config, like recording
+// that a proposal was approved, are only committed along with it.
+func (s *store) ConfigsCreateWith(config *Config, fn func(*gorm.DB) error) (*Config, error) {
+	defer func(start time.Time) { s.logSlow("ConfigsCreate", config.AppID, start) }(time.Now())
+
+	restore, err := s.sealConfig(config)
+	defer restore()
+	if err != nil {
+		return config, err
+	}
+
+	if s.notifyConfigChanges || fn != nil {
+		return configsCreateWith(s.db, config, s.notifyConfigChanges, fn)
+	}
+
+	return configsCreate(s.db, config)
+}
+
+// configsCurrentVarsSQL selects the requested keys, and their expiries, from
+// the current config for an app.
+const configsCurrentVarsSQL = `select slice(c.vars, array[?]::text[]), slice(c.expires, array[?]::text[])
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+where a.id = ?`
+
+// configsCurrentVars selects the named variables from the current config for
+// the app, dropping any that have expired as of now.
+func configsCurrentVars(db *gorm.DB, app *App, names []Variable, now time.Time) (Vars, error) {
+	if len(names) == 0 {
+		return Vars{}, nil
+	}
+
+	keys := make([]string, len(names))
+	for i, n := range names {
+		keys[i] = string(n)
+	}
+
+	var c Config
+	row := db.Raw(configsCurrentVarsSQL, keys, keys, app.ID).Row()
+	if err := row.Scan(&c.Vars, &c.Expires); err != nil {
+		if err == sql.ErrNoRows {
+			return Vars{}, nil
+		}
+		return nil, err
+	}
+
+	return c.Unexpired(now).Vars, nil
+}
+
+// currentConfigsForAppsSQL selects the current config for each app in a set
+// of app ids.
+const currentConfigsForAppsSQL = `select c.* from apps a join configs c on c.id = ` + currentConfigSQL + ` where a.id in (?)`
+
+// configsCurrentForApps selects the current config for each app, calling check
+// with each config before its expired variables are dropped.
+func configsCurrentForApps(db *gorm.DB, apps []*App, check func(*Config) error) (map[string]*Config, error) {
+	m := make(map[string]*Config)
+
+	if len(apps) == 0 {
+		return m, nil
+	}
+
+	byID := make(map[string]*App, len(apps))
+	ids := make([]string, 0, len(apps))
+	for _, app := range apps {
+		byID[app.ID] = app
+		ids = append(ids, app.ID)
+	}
+
+	var configs []*Config
+	if err := db.Raw(currentConfigsForAppsSQL, ids).Scan(&configs).Error; err != nil {
+		return m, err
+	}
+
+	for _, c := range configs {
+		if err := check(c); err != nil {
+			return m, err
+		}
+
+		app := byID[c.AppID]
+		c.App = app
+		m[app.Name] = c.Unexpired(timex.Now())
+	}
+
+	return m, nil
+}
+
+// ConfigStats describes the size of an apps current config.
+type ConfigStats struct {
+	// The name of the app.
+	App string
+
+	// The number of variables in the config.
+	Vars int
+
+	// The total size, in bytes, of every name and value in the config.
+	Size int
+}
+
+// configSizesSQL selects the number of variables and total size of the current
+// config for each app.
+const configSizesSQL = `select a.name, count(e.key) as vars, coalesce(sum(octet_length(e.key) + ` + valueSizeSQL + `), 0) as size
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+left join lateral each(c.vars) e on true
+group by a.name`
+
+// configsStatsSQL selects the ConfigStats for every app, ordered by name.
+const configsStatsSQL = configSizesSQL + ` order by a.name`
+
+// configsStats selects the ConfigStats for every app.
+func configsStats(db *gorm.DB) ([]*ConfigStats, error) {
+	rows, err := db.Raw(configsStatsSQL).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*ConfigStats
+	for rows.Next() {
+		var s ConfigStats
+		if err := rows.Scan(&s.App, &s.Vars, &s.Size); err != nil {
+			return nil, err
+		}
+		stats = append(stats, &s)
+	}
+
+	return stats, rows.Err()
+}
+
+// configsScanAllSQL selects the current config for every app, along with the
+// name of the app.
+const configsScanAllSQL = `select a.name, c.id, c.app_id, c.vars, c.created_at, c.expires, c.secret_flags
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+order by a.name`
+
+// configsScanAll streams the current config for every app through check,
+// after it's been passed to decrypt.
+func configsScanAll(db *gorm.DB, decrypt func(*Config) error, check func(string, *Config) []error) (map[string][]error, error) {
+	t := db.Begin()
+	defer t.Rollback()
+
+	if err := t.Exec(`set transaction read only`).Error; err != nil {
+		return nil, err
+	}
+
+	rows, err := t.Raw(configsScanAllSQL).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	violations := make(map[string][]error)
+	for rows.Next() {
+		var (
+			app string
+			c   Config
+		)
+
+		if err := rows.Scan(&app, &c.ID, &c.AppID, &c.Vars, &c.CreatedAt, &c.Expires, &c.SecretFlags); err != nil {
+			return nil, err
+		}
+
+		if err := decrypt(&c); err != nil {
+			return nil, err
+		}
+
+		if errs := check(app, &c); len(errs) > 0 {
+			violations[app] = errs
+		}
+	}
+
+	return violations, rows.Err()
+}
+
+// Distribution summarizes a set of measurements.
+type Distribution struct {
+	Min    float64
+	Max    float64
+	Median float64
+	P95    float64
+}
+
+// SizeStats summarizes the sizes of the current configs for every app.
+type SizeStats struct {
+	// The number of apps with a config.
+	Apps int
+
+	// The distribution of the number of variables in each config.
+	Vars Distribution
+
+	// The distribution of the size, in bytes, of each config.
+	Size Distribution
+
+	// The number of apps with more variables than the threshold that was
+	// given.
+	Over int
+}
+
+// configsSizeStatsSQL aggregates the sizes from configSizesSQL. It takes the
+// variable threshold as a parameter.
+const configsSizeStatsSQL = `select count(*),
+	coalesce(min(vars), 0), coalesce(max(vars), 0),
+	coalesce(percentile_cont(0.5) within group (order by vars), 0),
+	coalesce(percentile_cont(0.95) within group (order by vars), 0),
+	coalesce(min(size), 0), coalesce(max(size), 0),
+	coalesce(percentile_cont(0.5) within group (order by size), 0),
+	coalesce(percentile_cont(0.95) within group (order by size), 0),
+	coalesce(sum(case when vars > ? then 1 else 0 end), 0)
+from (` + configSizesSQL + `) s`
+
+// configsSizeStats selects the SizeStats for every app.
+func configsSizeStats(db *gorm.DB, threshold int) (*SizeStats, error) {
+	var s SizeStats
+
+	row := db.Raw(configsSizeStatsSQL, threshold).Row()
+	if err := row.Scan(
+		&s.Apps,
+		&s.Vars.Min, &s.Vars.Max, &s.Vars.Median, &s.Vars.P95,
+		&s.Size.Min, &s.Size.Max, &s.Size.Median, &s.Size.P95,
+		&s.Over,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// configsAppsMissingVarSQL selects the names of the apps whose current config
+// doesn't have a value for a variable, or has one that expired as of a time.
+const configsAppsMissingVarSQL = `select a.name
+from apps a
+left join configs c on c.id = ` + currentConfigSQL + `
+where c.id is null
+	or coalesce(c.vars -> ?, '') = ''
+	or coalesce((c.expires -> ?)::timestamptz <= ?, false)
+order by a.name`
+
+// configsAppsMissingVar selects the apps that are missing the variable as of
+// now.
+func configsAppsMissingVar(db *gorm.DB, name Variable, now time.Time) ([]string, error) {
+	rows, err := db.Raw(configsAppsMissingVarSQL, string(name), string(name), now).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := []string{}
+	for rows.Next() {
+		var app string
+		if err := rows.Scan(&app); err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+
+	return apps, rows.Err()
+}
+
+// configsVarPrevalenceSQL counts the apps whose current config contains each
+// variable name, most common first.
+const configsVarPrevalenceSQL = `select k.name, count(*) as apps
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+join lateral skeys(c.vars) k(name) on true
+group by k.name
+order by apps desc, k.name`
+
+// configsVarPrevalence selects the number of apps using each variable.
+func configsVarPrevalence(db *gorm.DB, limit int) (map[Variable]int, error) {
+	q := db.Raw(configsVarPrevalenceSQL)
+	if limit > 0 {
+		q = db.Raw(configsVarPrevalenceSQL+` limit ?`, limit)
+	}
+
+	rows, err := q.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prevalence := make(map[Variable]int)
+	for rows.Next() {
+		var (
+			name string
+			apps int
+		)
+
+		if err := rows.Scan(&name, &apps); err != nil {
+			return nil, err
+		}
+		prevalence[Variable(name)] = apps
+	}
+
+	return prevalence, rows.Err()
+}
+
+// ConfigsCreate inserts a Config in the database.
+func configsCreate(db *gorm.DB, config *Config) (*Config, error) {
+	return config, db.Create(config).Error
+}
+
+type configsService struct {
+	store    configsStore
+	releases *releasesService
+
+	// Validators are run against the vars before they're applied.
+	validators []Validator
+
+	// If provided, returns the maximum number of vars the app can have.
+	maxVars func(*App) int
+
+	// If provided, returns the Encryptor for the values of the apps
+	// configs, which is nil if they aren't encrypted.
+	encryptor func(*App) (Encryptor, error)
+
+	// Deprecated variables, mapped to a message for app owners.
+	deprecatedVars map[Variable]string
+
+	// If provided, called with warnings about an apps config.
+	handleWarnings func(*App, []Warning)
+
+	// If true, changes that cause a secret variable to no longer be
+	// treated as a secret are allowed.
+	allowSecurityDowngrade bool
+
+	// Used to resolve references to external secrets when showing the
+	// environment of a process, or checking that vars can be resolved.
+	resolvers SecretResolvers
+
+	// If true, vars are checked to ensure that they can be fully resolved,
+	// using resolvers, before they're applied.
+	resolveCheck bool
+
+	// If true, references to unknown app attributes can't be resolved.
+	strictInterpolation bool
+
+	// If true, changes have to be made with a reason.
+	requireReason bool
+
+	// Used to rewrite the values of specific variables into a canonical
+	// form before they're stored.
+	normalizers map[Variable]func(string) string
+
+	// If provided, returns the team that owns each variable in an apps
+	// config.
+	varOwners func(*App) map[Variable]string
+
+	// If provided, used to decide whether the user making a change can
+	// change variables owned by a team.
+	authorizer VarAuthorizer
+
+	// If provided, used to classify variables as secrets when they're set.
+	classifier SecretClassifier
+
+	// Vars are checked to ensure that they can be represented by each of
+	// these before they're applied.
+	renderers []Renderer
+
+	// If greater than 0, a warning is produced when a config that's about
+	// to be stored has an estimated row size over this many bytes.
+	rowSizeWarning int
+
+	// Used to build the environment of a process from a config.
+	env *envBuilder
+}
+
+func (s *configsService) ConfigsApply(ctx context.Context, app *App, vars Vars) (*Config, error) {
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		return s.apply(ctx, app, vars)
+	})
+}
+
+// ConfigsCompareAndSet sets the variable to value, only if its current value is
+// equal to expected. A nil expected value means that the variable is expected
+// to not be set, and a nil value unsets the variable. If the current value does
+// not match, ErrConfigConflict is returned.
+func (s *configsService) ConfigsCompareAndSet(ctx context.Context, app *App, name Variable, expected, value *string) (*Config, error) {
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !varEqual(old.Vars[name], expected) {
+			return old, nil, ErrConfigConflict
+		}
+
+		return s.apply(ctx, app, Vars{name: value})
+	})
+}
+
+// ConfigsUnsetPrefix unsets every variable in the apps current config whose
+// name starts with prefix, returning the new config and the names of the
+// variables that were removed. If no variables match, nothing is changed and
+// the current config is returned.
+func (s *configsService) ConfigsUnsetPrefix(ctx context.Context, app *App, prefix Variable) (*Config, []Variable, error) {
+	if prefix == "" {
+		return nil, nil, ErrEmptyPrefix
+	}
+
+	var removed []Variable
+	c, err := s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		removed = old.Match(globEscape(string(prefix)) + "*").Keys()
+		vars := make(Vars)
+		for _, n := range removed {
+			vars[n] = nil
+		}
+
+		if len(removed) == 0 {
+			return old, nil, nil
+		}
+
+		return s.apply(ctx, app, vars)
+	})
+	return c, removed, err
+}
+
+// ConfigsReconcile makes the apps config match desired exactly, applying only
+// the variables that differ. If the config already matches, the current config
+// is returned with an empty diff, and nothing is written.
+func (s *configsService) ConfigsReconcile(ctx context.Context, app *App, desired Vars) (*Config, ConfigDiff, error) {
+	var diff ConfigDiff
+	c, err := s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		diff = DiffVars(old.Vars, desired)
+		if len(diff) == 0 {
+			return old, nil, nil
+		}
+
+		return s.apply(ctx, app, diff.Vars())
+	})
+	return c, diff, err
+}
+
+// ConfigsCompare returns the changes needed to go from the current config for b
+// to the current config for a. Values are compared directly, so use
+// ConfigDiff.Summary with redact to report differences in secrets without
+// showing their values.
+func (s *configsService) ConfigsCompare(a, b *App) (ConfigDiff, error) {
+	ca, err := s.current(a)
+	if err != nil {
+		return nil, err
+	}
+
+	cb, err := s.current(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return ca.Diff(cb), nil
+}
+
+// ConfigsSetIfAbsent sets the variables that aren't already in the apps
+// current config, leaving existing variables unchanged, and returns the names
+// of the variables that were added. If every variable already exists, the
+// current config is returned and nothing is written.
+func (s *configsService) ConfigsSetIfAbsent(ctx context.Context, app *App, vars Vars) (*Config, []Variable, error) {
+	var added []Variable
+	c, err := s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		added = []Variable{}
+		absent := make(Vars)
+		for _, n := range vars.Keys() {
+			if _, ok := old.Vars[n]; ok || vars[n] == nil {
+				continue
+			}
+			added = append(added, n)
+			absent[n] = vars[n]
+		}
+
+		if len(added) == 0 {
+			return old, nil, nil
+		}
+
+		return s.apply(ctx, app, absent)
+	})
+	return c, added, err
+}
+
+// ConfigsRotateVar sets the variable to value for each of the apps that
+// currently have it, skipping the apps that don't. The new configs are
+// returned, along with an error for each app that couldn't be changed, like
+// apps with a locked config. An error is only returned if value isn't valid,
+// in which case no apps are changed.
+func (s *configsService) ConfigsRotateVar(ctx context.Context, apps []*App, name Variable, value string) ([]*Config, map[*App]error, error) {
+	if errs := validateForStorage(Vars{name: &value}, s.validators); len(errs) > 0 {
+		return nil, nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	configs := []*Config{}
+	failed := make(map[*App]error)
+
+	for _, app := range apps {
+		c, err := s.rotateVar(ctx, app, name, value)
+		if err != nil {
+			failed[app] = err
+			continue
+		}
+
+		if c != nil {
+			configs = append(configs, c)
+		}
+	}
+
+	return configs, failed, nil
+}
+
+// rotateVar sets the variable to value if the app has it, returning nil if it
+// doesn't.
+func (s *configsService) rotateVar(ctx context.Context, app *App, name Variable, value string) (*Config, error) {
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := old.Vars[name]; !ok {
+			return nil, nil, nil
+		}
+
+		return s.apply(ctx, app, Vars{name: &value})
+	})
+}
+
+// ConfigsVerify checks a copy of an apps current config, like one held in a
+// cache, against the database. If the fingerprint doesn't match the current
+// config, ErrStaleConfig is returned along with the current config, so the copy
+// can be replaced.
+func (s *configsService) ConfigsVerify(app *App, fingerprint string) (*Config, error) {
+	c, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Fingerprint() != fingerprint {
+		return c, ErrStaleConfig
+	}
+
+	return c, nil
+}
+
+// locked calls fn while holding the lock for the app. If fn creates a release,
+// it's scheduled onto the cluster once the lock has been released, so the lock
+// isn't held while waiting on the scheduler.
+func (s *configsService) locked(ctx context.Context, app *App, fn func() (*Config, *Release, error)) (*Config, error) {
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return nil, err
+	}
+
+	c, r, err := fn()
+	unlock()
+
+	if err != nil || r == nil {
+		return c, err
+	}
+
+	return c, s.submit(ctx, r)
+}
+
+// apply merges vars into the current config for the app and creates a new
+// release if the app has been released before. The release isn't scheduled
+// onto the cluster, so callers should hold the app lock with locked, which
+// schedules it after the lock is released.
+func (s *configsService) apply(ctx context.Context, app *App, vars Vars) (*Config, *Release, error) {
+	return s.applyChange(ctx, app, configChange{vars: vars})
+}
+
+// configChange is a change to an apps config, made with applyChange.
+type configChange struct {
+	// The vars to merge into the current config.
+	vars Vars
+
+	// Variables to expire at the given times.
+	expires Expirations
+
+	// Variables to explicitly classify.
+	flags SecretFlags
+
+	// If true, flags replace the secret flags of the current config,
+	// instead of being merged into them, like when swapping configs.
+	replaceFlags bool
+
+	// If true, the change was already authorized, like when it was
+	// scheduled, so it isn't authorized again.
+	authorized bool
+
+	// If provided, the earlier config whose vars the change restores, like
+	// when rolling back to a tag.
+	restores *Config
+
+	// If provided, called with a store that makes changes atomically with
+	// the new config being created, like to record what the config was
+	// created for.
+	then func(c *Config, tx configsStore) error
+}
+
+// applyChange is like apply, but the change can also expire variables,
+// classify them, and make other changes along with the new config. Callers
+// should hold the app lock.
+func (s *configsService) applyChange(ctx context.Context, app *App, change configChange) (*Config, *Release, error) {
+	vars := change.vars
+
+	if err := s.checkLock(app); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.checkReason(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	old, err := s.current(app)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !change.authorized {
+		if err := s.authorize(ctx, app, old, vars); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	c, errs := s.validateChange(app, old, change)
+	if len(errs) > 0 {
+		return nil, nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	if change.restores != nil {
+		c.RestoresID = &change.restores.ID
+	}
+	c.Actor = actorFromContext(ctx)
+
+	s.warnRowSize(app, c)
+
+	var then func(configsStore) error
+	if change.then != nil {
+		then = func(tx configsStore) error { return change.then(c, tx) }
+	}
+
+	c, err = s.store.ConfigsCreateWith(c, then)
+	if err != nil {
+		return c, nil, err
+	}
+
+	s.warn(app, c)
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, string(k))
+	}
+
+	desc := fmt.Sprintf("Set %s config vars", strings.Join(keys, ","))
+
+	r, err := s.release(app, c, desc)
+	return c, r, err
+}
+
+// release creates a new release of the app with the config, if the app has
+// been released before, returning nil if it hasn't. The release isn't
+// scheduled onto the cluster until it's passed to submit.
+func (s *configsService) release(app *App, c *Config, desc string) (*Release, error) {
+	release, err := s.store.ReleasesFirst(ReleasesQuery{App: app})
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	// Create new release based on new config and old slug
+	return s.releases.create(&Release{
+		App:         release.App,
+		Config:      c,
+		Slug:        release.Slug,
+		Description: desc,
+	})
+}
+
+// submit schedules a release created by release onto the cluster. If a newer
+// release of the app has been created in the meantime, the release is skipped,
+// since the newer one replaces it.
+func (s *configsService) submit(ctx context.Context, r *Release) error {
+	latest, err := s.store.ReleasesFirst(ReleasesQuery{App: r.App})
+	if err != nil {
+		return err
+	}
+
+	if latest.Version != r.Version {
+		return nil
+	}
+
+	return s.releases.releaser.Release(ctx, r)
+}
+
+// ConfigsFind returns the config with the given id, or ErrConfigNotFound.
+func (s *configsService) ConfigsFind(id string) (*Config, error) {
+	c, err := s.store.ConfigsFirst(ConfigsQuery{ID: &id})
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, ErrConfigNotFound
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ConfigsEffectiveEnv returns the sorted KEY=value environment that a process
+// using the config with the given id would run with. The environment is built
+// the same way it is for a release, so values are interpolated and references
+// are resolved, and variables are expired as of the first release of the
+// config, or now if it was never released. Variables in extra take precedence
+// over the config, which takes precedence over defaults. If the config doesn't
+// exist, ErrConfigNotFound is returned.
+func (s *configsService) ConfigsEffectiveEnv(id string, defaults, extra Vars) ([]string, error) {
+	app, c, at, err := s.findReleased(id)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := s.env.varsAt(app, c, at)
+	if err != nil {
+		return nil, err
+	}
+
+	c = &Config{Vars: (&Config{Vars: vars}).WithDefaults(defaults)}
+	return c.Env(extra), nil
+}
+
+// findReleased returns the config with the given id, the app that it belongs
+// to, and the time that it was first released, or now if it never was.
+func (s *configsService) findReleased(id string) (*App, *Config, time.Time, error) {
+	c, err := s.ConfigsFind(id)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	app, err := s.store.AppsFirst(AppsQuery{ID: &c.AppID})
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	at, err := s.store.ConfigsReleasedAt(c)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	if at == nil {
+		return app, c, timex.Now(), nil
+	}
+
+	return app, c, *at, nil
+}
+
+// ConfigsReleasedAt returns the time that the config was first released, or
+// nil if it never was.
+func (s *store) ConfigsReleasedAt(c *Config) (*time.Time, error) {
+	var r Release
+	if err := s.db.Where("config_id = ?", c.ID).Order("version").First(&r).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r.CreatedAt, nil
+}
+
+// ConfigsProcessEnv returns the sorted KEY=value environment that a process of
+// the given type would run with using the config with the given id, like a
+// scheduled process that needs a slightly different environment from the rest
+// of the app. The environment is built the same way it is for processes that
+// are released, EMPIRE_PROCESS is set to the process type, and overrides take
+// precedence over the config. If the config doesn't exist, ErrConfigNotFound
+// is returned.
+func (s *configsService) ConfigsProcessEnv(id, processType string, overrides Vars) ([]string, error) {
+	c, err := s.ConfigsFind(id)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := s.store.AppsFirst(AppsQuery{ID: &c.AppID})
+	if err != nil {
+		return nil, err
+	}
+
+	return processEnv(s.env, app, c, processType, overrides)
+}
+
+// processEnv returns the environment for a process of the given type for the
+// app using the config, built by b, with overrides merged on top.
+func processEnv(b *envBuilder, app *App, c *Config, processType string, overrides Vars) ([]string, error) {
+	vars, err := b.Vars(app, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// A nil override removes the variable, so overrides are copied as is,
+	// rather than merged.
+	extra := Vars{"EMPIRE_PROCESS": &processType}
+	for n, v := range overrides {
+		extra[n] = v
+	}
+
+	return (&Config{Vars: vars}).Env(extra), nil
+}
+
+// ConfigsVarLastModified returns the time that each variable in the apps
+// current config was last changed, by walking back through the apps history
+// to find when the current value first appeared.
+//
+// This loads the entire config history for the app, so it's O(history) and
+// should not be used in hot paths.
+func (s *configsService) ConfigsVarLastModified(app *App) (map[Variable]time.Time, error) {
+	head, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.store.Configs(ConfigsQuery{App: app})
+	if err != nil {
+		return nil, err
+	}
+
+	// If the app was rolled back, the current config won't be the newest,
+	// so ignore anything that came after it.
+	for i, c := range history {
+		if c.ID == head.ID {
+			history = history[i:]
+			break
+		}
+	}
+
+	return varLastModified(head, history), nil
+}
+
+// ConfigsStaleVars returns the variables in the apps current config that
+// aren't in the list of variables known to be used, sorted by name.
+func (s *configsService) ConfigsStaleVars(app *App, used []Variable) ([]Variable, error) {
+	c, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	return staleVars(c.Vars, used), nil
+}
+
+// ConfigsValidate runs the same validations that ConfigsApply would against
+// the config that would result from applying vars, returning every violation.
+// Nothing is written.
+func (s *configsService) ConfigsValidate(app *App, vars Vars) ([]error, error) {
+	old, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	_, errs := s.validate(app, old, vars)
+	return errs, nil
+}
+
+// validate runs every validation against the vars to be applied on top of old,
+// returning the resulting config and all of the errors that were found.
+func (s *configsService) validate(app *App, old *Config, vars Vars) (*Config, []error) {
+	return s.validateChange(app, old, configChange{vars: vars})
+}
+
+// validateChange is like validate, but the config also gets the expirations
+// and secret flags of the change. If a SecretClassifier is configured, the
+// vars are classified before the config is checked.
+func (s *configsService) validateChange(app *App, old *Config, change configChange) (*Config, []error) {
+	vars := normalizeVars(change.vars, s.normalizers)
+	errs := validateForStorage(vars, s.validators)
+
+	if s.resolveCheck {
+		errs = append(errs, checkResolvable(app, vars, s.resolvers, s.strictInterpolation)...)
+	}
+
+	errs = append(errs, checkRender(vars, s.renderers)...)
+
+	c := NewConfig(old, vars)
+
+	for n, t := range change.expires {
+		if c.Expires == nil {
+			c.Expires = make(Expirations)
+		}
+		c.Expires[n] = t
+	}
+
+	flags := c.SecretFlags
+	if change.replaceFlags {
+		flags = nil
+	}
+	c.SecretFlags = mergeSecretFlags(flags, change.flags, c.Vars)
+
+	if s.classifier != nil {
+		classifySecrets(c, vars, s.classifier)
+	}
+
+	errs = append(errs, s.check(app, old, c)...)
+
+	return c, errs
+}
+
+// warnRowSize passes any row size warnings for the config to the
+// WarningHandler, if one is configured.
+func (s *configsService) warnRowSize(app *App, c *Config) {
+	if s.handleWarnings != nil && s.rowSizeWarning > 0 {
+		if warnings := rowSizeWarnings(c, s.rowSizeWarning); len(warnings) > 0 {
+			s.handleWarnings(app, warnings)
+		}
+	}
+}
+
+// check ensures that the new config, which will replace the old config, is
+// allowed for the app.
+func (s *configsService) check(app *App, old, new *Config) []error {
+	var errs []error
+
+	if s.maxVars != nil {
+		if err := checkMaxVars(old.Vars, new.Vars, s.maxVars(app)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if !s.allowSecurityDowngrade {
+		errs = append(errs, checkSecurityDowngrade(old, new, DefaultSecretDetector)...)
+	}
+
+	// Limits apply to the config as it's stored, so they're checked
+	// against the encrypted size if the apps values are encrypted.
+	var enc Encryptor
+	if s.encryptor != nil {
+		var err error
+		if enc, err = s.encryptor(app); err != nil {
+			return append(errs, err)
+		}
+	}
+
+	errs = append(errs, checkHstoreLimits(new, enc, MaxHstoreSize, MaxHstoreEntries)...)
+
+	return errs
+}
+
+// Returns configs for latest release or the latest configs if there are no
+// releases. Variables that have expired are dropped, and any deprecated
+// variables are passed to the WarningHandler.
+func (s *configsService) ConfigsCurrent(app *App) (*Config, error) {
+	c, err := s.current(app)
+	if err != nil {
+		return c, err
+	}
+
+	c = c.Unexpired(timex.Now())
+
+	s.warn(app, c)
+
+	return c, nil
+}
+
+// current returns the current config for the app, creating an empty config if
+// the app doesn't have one.
+func (s *configsService) current(app *App) (*Config, error) {
+	c, err := s.store.ConfigsCurrent(app)
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			// It's possible to have an app without config, this handles that.
+			return s.store.ConfigsCreate(&Config{
+				App:  app,
+				Vars: make(Vars),
+			})
+		}
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// warn sends any warnings about the config to the warning handler.
+func (s *configsService) warn(app *App, c *Config) {
+	if s.handleWarnings == nil {
+		return
+	}
+
+	if warnings := deprecationWarnings(c.Vars, s.deprecatedVars); len(warnings) > 0 {
+		s.handleWarnings(app, warnings)
+	}
+}
+
+// varLastModified returns, for each variable in head, the creation time of the
+// oldest config in history where the variable had its current value without
+// interruption. History should be ordered newest first.
+func varLastModified(head *Config, history []*Config) map[Variable]time.Time {
+	m := make(map[Variable]time.Time, len(head.Vars))
+
+	for n, v := range head.Vars {
+		if head.CreatedAt != nil {
+			m[n] = *head.CreatedAt
+		}
+
+		for _, c := range history {
+			if c.ID == head.ID {
+				continue
+			}
+
+			if !varEqual(c.Vars[n], v) {
+				break
+			}
+
+			if c.CreatedAt != nil {
+				m[n] = *c.CreatedAt
+			}
+		}
+	}
+
+	return m
+}
+
+// staleVars returns the sorted names of the variables that aren't in used.
+func staleVars(vars Vars, used []Variable) []Variable {
+	u := make(map[Variable]bool, len(used))
+	for _, n := range used {
+		u[n] = true
+	}
+
+	stale := []Variable{}
+	for _, n := range vars.Keys() {
+		if !u[n] {
+			stale = append(stale, n)
+		}
+	}
+
+	return stale
+}
+
+// varEqual returns true if both values are unset, or both are set to the same
+// value.
+func varEqual(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// normalizeVars returns a copy of vars with the value of each variable that
+// has a normalizer passed through it. Unset variables, and variables without a
+// normalizer, are left as is.
+func normalizeVars(vars Vars, normalizers map[Variable]func(string) string) Vars {
+	if len(normalizers) == 0 {
+		return vars
+	}
+
+	normalized := make(Vars, len(vars))
+	for n, v := range vars {
+		if f, ok := normalizers[n]; ok && v != nil {
+			nv := f(*v)
+			v = &nv
+		}
+		normalized[n] = v
+	}
+
+	return normalized
 }
 
 // mergeVars copies all of the vars from a, and merges b into them, returning a
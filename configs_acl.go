@@ -0,0 +1,182 @@
+package empire
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+// ErrUnauthorizedVar is used to indicate that the user isn't allowed to change a
+// variable that's owned by another team.
+var ErrUnauthorizedVar = errors.New("Variable is owned by a team that you're not a member of.")
+
+// VarAuthorizer decides whether a user is allowed to change variables that are
+// owned by a team.
+type VarAuthorizer interface {
+	// Authorize returns true if the user can change the variable, which is
+	// owned by team, in the apps config. The user is nil if the change
+	// wasn't made on behalf of a user.
+	Authorize(user *User, app *App, name Variable, team string) bool
+}
+
+// VarAuthorizerFunc is a function that implements the VarAuthorizer interface.
+type VarAuthorizerFunc func(*User, *App, Variable, string) bool
+
+// Authorize implements the VarAuthorizer interface.
+func (f VarAuthorizerFunc) Authorize(user *User, app *App, name Variable, team string) bool {
+	return f(user, app, name, team)
+}
+
+// authorizeVars returns an error for each variable in vars that the user in ctx
+// isn't allowed to change, ordered by variable name. Variables without an
+// owner can always be changed.
+func authorizeVars(ctx context.Context, app *App, vars Vars, owners map[Variable]string, authorizer VarAuthorizer) []error {
+	if authorizer == nil || len(owners) == 0 {
+		return nil
+	}
+
+	user, _ := UserFromContext(ctx)
+
+	var errs []error
+	for _, n := range vars.Keys() {
+		team, ok := owners[n]
+		if !ok {
+			continue
+		}
+
+		if !authorizer.Authorize(user, app, n, team) {
+			errs = append(errs, &VarError{Name: n, Err: ErrUnauthorizedVar})
+		}
+	}
+
+	return errs
+}
+
+// changedVars returns the vars that would change the values in old. Setting a
+// variable to the value that it already has, or unsetting a variable that
+// isn't set, doesn't change it.
+func changedVars(old, vars Vars) Vars {
+	changed := make(Vars, len(vars))
+
+	for n, v := range vars {
+		o, ok := old[n]
+		if v == nil && !ok {
+			continue
+		}
+
+		if v != nil && ok && o != nil && *o == *v {
+			continue
+		}
+
+		changed[n] = v
+	}
+
+	return changed
+}
+
+// authorize returns a VarErrors listing the variables in vars that the user in
+// ctx isn't allowed to change on top of old, or nil if every change is
+// allowed. Only variables whose values would change are authorized.
+func (s *configsService) authorize(ctx context.Context, app *App, old *Config, vars Vars) error {
+	if s.authorizer == nil {
+		return nil
+	}
+
+	owners, err := s.ConfigsOwners(app)
+	if err != nil {
+		return err
+	}
+
+	if errs := authorizeVars(ctx, app, changedVars(old.Vars, vars), owners, s.authorizer); len(errs) > 0 {
+		return VarErrors(errs)
+	}
+
+	return nil
+}
+
+// ConfigsVarOwners returns the owners that were set for variables in the apps
+// config with ConfigsSetOwner.
+func (s *store) ConfigsVarOwners(app *App) (map[Variable]string, error) {
+	rows, err := s.db.Raw(`select name, team from config_var_owners where app_id = ?`, app.ID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[Variable]string)
+	for rows.Next() {
+		var name, team string
+		if err := rows.Scan(&name, &team); err != nil {
+			return nil, err
+		}
+		owners[Variable(name)] = team
+	}
+
+	return owners, rows.Err()
+}
+
+// ConfigsSetVarOwner sets the team that owns the variable in the apps config,
+// or removes the owner if team is empty.
+func (s *store) ConfigsSetVarOwner(app *App, name Variable, team string) error {
+	if team == "" {
+		return s.db.Exec(`delete from config_var_owners where app_id = ? and name = ?`, app.ID, string(name)).Error
+	}
+
+	r := s.db.Exec(`update config_var_owners set team = ? where app_id = ? and name = ?`, team, app.ID, string(name))
+	if err := r.Error; err != nil {
+		return err
+	}
+
+	if r.RowsAffected > 0 {
+		return nil
+	}
+
+	return s.db.Exec(`insert into config_var_owners (app_id, name, team) values (?, ?, ?)`, app.ID, string(name), team).Error
+}
+
+// ConfigsOwners returns the team that owns each variable in the apps config.
+// Owners set with ConfigsSetOwner take precedence over those from the
+// VarOwners option.
+func (s *configsService) ConfigsOwners(app *App) (map[Variable]string, error) {
+	owners := make(map[Variable]string)
+	if s.varOwners != nil {
+		for n, team := range s.varOwners(app) {
+			owners[n] = team
+		}
+	}
+
+	stored, err := s.store.ConfigsVarOwners(app)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, team := range stored {
+		owners[n] = team
+	}
+
+	return owners, nil
+}
+
+// ConfigsSetOwner records team as the owner of the variable in the apps config,
+// so that changes to it are only allowed for users that the VarAuthorizer
+// allows to make changes for the team. An empty team removes the owner, so
+// that anyone can change the variable. If the variable is already owned, the
+// user in ctx has to be allowed to change it to change its owner.
+func (s *configsService) ConfigsSetOwner(ctx context.Context, app *App, name Variable, team string) error {
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	owners, err := s.ConfigsOwners(app)
+	if err != nil {
+		return err
+	}
+
+	if errs := authorizeVars(ctx, app, Vars{name: nil}, owners, s.authorizer); len(errs) > 0 {
+		return VarErrors(errs)
+	}
+
+	return s.store.ConfigsSetVarOwner(app, name, team)
+}
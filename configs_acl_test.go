@@ -0,0 +1,66 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestAuthorizeVars(t *testing.T) {
+	owners := map[Variable]string{
+		"PAYMENTS_KEY": "payments",
+		"SEARCH_URL":   "search",
+	}
+
+	authorizer := VarAuthorizerFunc(func(user *User, app *App, name Variable, team string) bool {
+		return user != nil && user.Name == team
+	})
+
+	ctx := WithUser(context.Background(), &User{Name: "search"})
+	vars := Vars{
+		"PAYMENTS_KEY": strptr("sk_live"),
+		"SEARCH_URL":   strptr("http://search"),
+		"RAILS_ENV":    strptr("production"),
+	}
+
+	errs := authorizeVars(ctx, &App{}, vars, owners, authorizer)
+
+	expected := []error{&VarError{Name: "PAYMENTS_KEY", Err: ErrUnauthorizedVar}}
+	if got, want := errs, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("authorizeVars => %v; want %v", got, want)
+	}
+
+	// Changes to only owned and unowned vars are allowed.
+	delete(vars, "PAYMENTS_KEY")
+	if errs := authorizeVars(ctx, &App{}, vars, owners, authorizer); len(errs) != 0 {
+		t.Fatalf("authorizeVars => %v; want no errors", errs)
+	}
+}
+
+func TestChangedVars(t *testing.T) {
+	old := Vars{
+		"RAILS_ENV":    strptr("production"),
+		"PAYMENTS_KEY": strptr("sk_live"),
+	}
+
+	vars := Vars{
+		"RAILS_ENV":    strptr("production"),
+		"PAYMENTS_KEY": strptr("sk_test"),
+		"SEARCH_URL":   nil,
+		"DEBUG":        strptr("1"),
+	}
+
+	expected := Vars{
+		"PAYMENTS_KEY": strptr("sk_test"),
+		"DEBUG":        strptr("1"),
+	}
+
+	if got, want := changedVars(old, vars), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("changedVars => %v; want %v", got.format(false), want.format(false))
+	}
+
+	if got, want := changedVars(old, Vars{"RAILS_ENV": nil}), (Vars{"RAILS_ENV": nil}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changedVars => %v; want %v", got.format(false), want.format(false))
+	}
+}
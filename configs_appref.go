@@ -0,0 +1,155 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+)
+
+var (
+	// ErrRefAppNotFound is returned when a value references the config of an
+	// app that doesn't exist.
+	ErrRefAppNotFound = errors.New("Referenced app could not be found.")
+
+	// ErrRefVarNotSet is returned when a value references a variable that
+	// isn't set in the other apps config.
+	ErrRefVarNotSet = errors.New("Referenced variable is not set.")
+
+	// ErrRefUnauthorized is returned when an app isn't allowed to reference
+	// a variable in another apps config.
+	ErrRefUnauthorized = errors.New("App is not allowed to reference the variable.")
+
+	// ErrRefChained is returned when a referenced value is itself a reference
+	// to another apps config. References aren't followed, so that they can't
+	// form a cycle.
+	ErrRefChained = errors.New("Referenced variable is also a reference.")
+)
+
+// appRefPattern matches values that reference a variable in another apps
+// config, like @app:api/PUBLIC_URL.
+var appRefPattern = regexp.MustCompile(`^@app:([^/]+)/([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// AppRefAuthorizer decides whether an app can use the value of a variable in
+// another apps config, through a reference like @app:api/PUBLIC_URL.
+type AppRefAuthorizer interface {
+	AuthorizeRef(from, to *App, name Variable) bool
+}
+
+// AppRefAuthorizerFunc is a function that implements the AppRefAuthorizer
+// interface.
+type AppRefAuthorizerFunc func(from, to *App, name Variable) bool
+
+// AuthorizeRef implements the AppRefAuthorizer interface.
+func (f AppRefAuthorizerFunc) AuthorizeRef(from, to *App, name Variable) bool {
+	return f(from, to, name)
+}
+
+// appRefResolver resolves references to variables in other apps configs.
+type appRefResolver struct {
+	// Returns the named app and its current config.
+	lookup func(name string) (*App, *Config, error)
+
+	// Used to decide whether the app can reference the variable.
+	authorizer AppRefAuthorizer
+
+	// If true, references to unknown app attributes in a referenced value
+	// are an error, like they are for the apps own values.
+	strictInterpolation bool
+}
+
+// storeAppConfigs returns a function that finds an app, and its current config,
+// in the store. Expired variables are dropped.
+func storeAppConfigs(s *store) func(string) (*App, *Config, error) {
+	return func(name string) (*App, *Config, error) {
+		app, err := s.AppsFirst(AppsQuery{Name: &name})
+		if err != nil {
+			if err == gorm.RecordNotFound {
+				return nil, nil, ErrRefAppNotFound
+			}
+			return nil, nil, err
+		}
+
+		c, err := s.ConfigsCurrent(app)
+		if err != nil {
+			if err == gorm.RecordNotFound {
+				return app, &Config{Vars: make(Vars)}, nil
+			}
+			return nil, nil, err
+		}
+
+		return app, c.Unexpired(timex.Now()), nil
+	}
+}
+
+// Resolve returns vars with each reference to another apps config, like
+// @app:api/PUBLIC_URL, replaced by the current value of the variable in that
+// apps config. Only the reference is stored, so a change to the other app is
+// picked up the next time the environment is built. References to app
+// attributes in the referenced value, like ${app.name}, are expanded against
+// the app that owns the value, so that every app sees the value that the
+// owning apps own processes do. If the app doesn't exist,
+// the variable isn't set, or app isn't allowed to reference it, an error is
+// returned, so that a process never receives the reference in place of the
+// value.
+func (r *appRefResolver) Resolve(app *App, vars Vars) (Vars, error) {
+	resolved := make(Vars, len(vars))
+
+	for _, n := range vars.Keys() {
+		v := vars[n]
+
+		m := appRefPattern.FindStringSubmatch(derefString(v))
+		if m == nil {
+			resolved[n] = v
+			continue
+		}
+
+		value, err := r.resolve(app, m[1], Variable(m[2]))
+		if err != nil {
+			return nil, &VarError{Name: n, Err: fmt.Errorf("unable to resolve %s: %v", *v, err)}
+		}
+		resolved[n] = &value
+	}
+
+	return resolved, nil
+}
+
+// resolve returns the value of the variable in the named apps config,
+// interpolated against that app.
+func (r *appRefResolver) resolve(from *App, to string, name Variable) (string, error) {
+	app, c, err := r.lookup(to)
+	if err != nil {
+		return "", err
+	}
+
+	if r.authorizer == nil || !r.authorizer.AuthorizeRef(from, app, name) {
+		return "", ErrRefUnauthorized
+	}
+
+	v := c.Vars[name]
+	if v == nil {
+		return "", ErrRefVarNotSet
+	}
+
+	if appRefPattern.MatchString(*v) {
+		return "", ErrRefChained
+	}
+
+	interpolated, err := (&Config{Vars: Vars{name: v}}).Interpolate(app, r.strictInterpolation)
+	if err != nil {
+		return "", err
+	}
+
+	return *interpolated[name], nil
+}
+
+// derefString returns the string that s points to, or an empty string if s is
+// nil.
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
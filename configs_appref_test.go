@@ -0,0 +1,107 @@
+package empire
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAppRefResolver_Resolve(t *testing.T) {
+	apps := map[string]*Config{
+		"api": {Vars: Vars{
+			"PUBLIC_URL": strptr("https://api.example.com"),
+			"SECRET":     strptr("s3cr3t"),
+			"PEER":       strptr("@app:web/PUBLIC_URL"),
+			"HOST":       strptr("${app.name}.example.com"),
+		}},
+	}
+
+	r := &appRefResolver{
+		lookup: func(name string) (*App, *Config, error) {
+			c, ok := apps[name]
+			if !ok {
+				return nil, nil, ErrRefAppNotFound
+			}
+			return &App{Name: name}, c, nil
+		},
+		authorizer: AppRefAuthorizerFunc(func(from, to *App, name Variable) bool {
+			return name != "SECRET"
+		}),
+	}
+
+	app := &App{Name: "web"}
+
+	vars, err := r.Resolve(app, Vars{
+		"API_URL":   strptr("@app:api/PUBLIC_URL"),
+		"API_HOST":  strptr("@app:api/HOST"),
+		"RAILS_ENV": strptr("production"),
+		"EMAIL":     strptr("@app:me"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"API_URL":   strptr("https://api.example.com"),
+		"API_HOST":  strptr("api.example.com"),
+		"RAILS_ENV": strptr("production"),
+		"EMAIL":     strptr("@app:me"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Resolve => %v; want %v", got.format(false), want.format(false))
+	}
+
+	tests := []struct {
+		ref string
+		err error
+	}{
+		{"@app:nope/PUBLIC_URL", ErrRefAppNotFound},
+		{"@app:api/MISSING", ErrRefVarNotSet},
+		{"@app:api/SECRET", ErrRefUnauthorized},
+		{"@app:api/PEER", ErrRefChained},
+	}
+
+	for _, tt := range tests {
+		_, err := r.Resolve(app, Vars{"REF": strptr(tt.ref)})
+
+		expected := &VarError{Name: "REF", Err: fmt.Errorf("unable to resolve %s: %v", tt.ref, tt.err)}
+		if got, want := err, error(expected); !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%s) => %v; want %v", tt.ref, got, want)
+		}
+	}
+}
+
+func TestEnvBuilder_AppRefInterpolation(t *testing.T) {
+	b := &envBuilder{
+		appRefs: &appRefResolver{
+			lookup: func(name string) (*App, *Config, error) {
+				return &App{Name: name}, &Config{Vars: Vars{
+					"HOST": strptr("${app.name}.example.com"),
+				}}, nil
+			},
+			authorizer: AppRefAuthorizerFunc(func(from, to *App, name Variable) bool {
+				return true
+			}),
+		},
+	}
+
+	vars, err := b.Vars(&App{Name: "web"}, &Config{Vars: Vars{
+		"API_HOST": strptr("@app:api/HOST"),
+		"HOST":     strptr("${app.name}.example.com"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The referenced value is expanded against the app that owns it, not
+	// the app referencing it.
+	expected := Vars{
+		"API_HOST": strptr("api.example.com"),
+		"HOST":     strptr("web.example.com"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got.format(false), want.format(false))
+	}
+}
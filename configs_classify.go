@@ -0,0 +1,299 @@
+package empire
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq/hstore"
+	"golang.org/x/net/context"
+)
+
+// ErrVarNotSet is returned when changing how a variable is treated, like
+// marking it as a secret, when it isn't set in the apps config.
+var ErrVarNotSet = errors.New("Variable is not set.")
+
+// SecretFlag records whether a variable is a secret, overriding
+// DefaultSecretDetector.
+type SecretFlag string
+
+const (
+	// SecretFlagSecret marks a variable as a secret, as decided by an
+	// operator.
+	SecretFlagSecret SecretFlag = "secret"
+
+	// SecretFlagPlain marks a variable as not being a secret, as decided
+	// by an operator, even if its name or value looks like one.
+	SecretFlagPlain SecretFlag = "plain"
+
+	// SecretFlagAuto marks a variable as a secret, as decided by a
+	// SecretClassifier when it was set.
+	SecretFlagAuto SecretFlag = "auto"
+)
+
+// SecretFlags maps the names of variables to how they're classified.
+type SecretFlags map[Variable]SecretFlag
+
+// Scan implements the sql.Scanner interface.
+func (f *SecretFlags) Scan(src interface{}) error {
+	h := hstore.Hstore{}
+	if err := h.Scan(src); err != nil {
+		return err
+	}
+
+	flags := make(SecretFlags, len(h.Map))
+
+	for k, v := range h.Map {
+		if v.Valid {
+			flags[Variable(k)] = SecretFlag(v.String)
+		}
+	}
+
+	*f = flags
+
+	return nil
+}
+
+// Value implements the driver.Value interface. No flags are stored as NULL.
+func (f SecretFlags) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return nil, nil
+	}
+
+	names := make([]Variable, 0, len(f))
+	for n := range f {
+		names = append(names, n)
+	}
+	sort.Sort(variablesByName(names))
+
+	parts := make([]string, 0, len(f))
+	for _, n := range names {
+		parts = append(parts, hstoreQuote(string(n))+"=>"+hstoreQuote(string(f[n])))
+	}
+
+	return []byte(strings.Join(parts, ",")), nil
+}
+
+// mergeSecretFlags returns the flags for the variables in merged, carried over
+// from old, with flags taking precedence. Flags for variables that have been
+// unset are dropped.
+func mergeSecretFlags(old, flags SecretFlags, merged Vars) SecretFlags {
+	var f SecretFlags
+
+	set := func(n Variable, flag SecretFlag) {
+		if _, ok := merged[n]; !ok {
+			return
+		}
+
+		if f == nil {
+			f = make(SecretFlags)
+		}
+		f[n] = flag
+	}
+
+	for n, flag := range old {
+		set(n, flag)
+	}
+
+	for n, flag := range flags {
+		set(n, flag)
+	}
+
+	return f
+}
+
+// SecretClassifier decides whether the value of a variable looks like a
+// secret, returning a short description of why if it does.
+type SecretClassifier func(name Variable, value string) (reason string, secret bool)
+
+// knownSecretPatterns match the formats of credentials issued by common
+// providers, mapped to a description of what they match.
+var knownSecretPatterns = []struct {
+	re     *regexp.Regexp
+	reason string
+}{
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`), "PEM private key"},
+	{regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`), "AWS access key id"},
+	{regexp.MustCompile(`^gh[pousr]_[A-Za-z0-9]{36,}$`), "GitHub token"},
+	{regexp.MustCompile(`^xox[abposr]-[A-Za-z0-9-]{10,}$`), "Slack token"},
+	{regexp.MustCompile(`^[rs]k_live_[A-Za-z0-9]{16,}$`), "Stripe live key"},
+	{regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+$`), "JSON web token"},
+}
+
+var (
+	// tokenPattern matches values that consist only of characters used by
+	// hex, base64 and base64url encoded tokens.
+	tokenPattern = regexp.MustCompile(`^[A-Za-z0-9+/=_-]+$`)
+
+	// uuidPattern matches UUIDs, which are often identifiers rather than
+	// secrets, so they aren't considered high entropy.
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+const (
+	// The minimum length of a value for it to be considered high entropy.
+	minSecretLength = 32
+
+	// The minimum Shannon entropy, in bits per character, of a value for it
+	// to be considered high entropy. A random hex string has close to 4.
+	minSecretEntropy = 3.5
+)
+
+// DefaultSecretClassifier is a conservative SecretClassifier that classifies
+// values matching the credential formats of common providers, and long, high
+// entropy tokens, as secrets. Values with whitespace, punctuation other than
+// what's used by encoded tokens, or only letters or only digits are never
+// considered high entropy, so urls, paths, hostnames and sentences aren't
+// classified as secrets.
+func DefaultSecretClassifier(name Variable, value string) (string, bool) {
+	for _, p := range knownSecretPatterns {
+		if p.re.MatchString(value) {
+			return p.reason, true
+		}
+	}
+
+	if looksHighEntropy(value) {
+		return "high entropy value", true
+	}
+
+	return "", false
+}
+
+// looksHighEntropy returns true if the value looks like a randomly generated
+// token.
+func looksHighEntropy(value string) bool {
+	if len(value) < minSecretLength || !tokenPattern.MatchString(value) || uuidPattern.MatchString(value) {
+		return false
+	}
+
+	if !strings.ContainsAny(value, "0123456789") || strings.Trim(value, "0123456789") == "" {
+		return false
+	}
+
+	return shannonEntropy(value) >= minSecretEntropy
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var (
+		n       = float64(len(s))
+		entropy float64
+	)
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// SecretClassification is a variable that was classified as a secret by a
+// SecretClassifier when it was set.
+type SecretClassification struct {
+	Name Variable
+
+	// Why the variable was classified as a secret, like "GitHub token".
+	Reason string
+}
+
+// classifySecrets flags the variables set by vars whose values within c look
+// like secrets according to classifier, returning the classifications that
+// were made, ordered by name. Variables that are already flagged, including
+// ones that an operator has marked as plain, and variables that are already
+// treated as secrets because of their name, are left alone, so a variable is
+// only classified once.
+func classifySecrets(c *Config, vars Vars, classifier SecretClassifier) []SecretClassification {
+	var classified []SecretClassification
+
+	for _, n := range vars.Keys() {
+		v, ok := c.Vars[n]
+		if !ok || v == nil {
+			continue
+		}
+
+		if _, ok := c.SecretFlags[n]; ok || c.IsSecret(n) {
+			continue
+		}
+
+		reason, secret := classifier(n, *v)
+		if !secret {
+			continue
+		}
+
+		if c.SecretFlags == nil {
+			c.SecretFlags = make(SecretFlags)
+		}
+		c.SecretFlags[n] = SecretFlagAuto
+
+		classified = append(classified, SecretClassification{Name: n, Reason: reason})
+	}
+
+	return classified
+}
+
+// ConfigsApplyClassified is like ConfigsApply, but also returns the variables
+// that were classified as secrets by the SecretClassifier while applying the
+// vars, so that the caller can tell the user about them. If secret
+// classification isn't enabled, nothing is classified.
+func (s *configsService) ConfigsApplyClassified(ctx context.Context, app *App, vars Vars) (*Config, []SecretClassification, error) {
+	var old *Config
+	c, err := s.locked(ctx, app, func() (*Config, *Release, error) {
+		var err error
+		old, err = s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s.apply(ctx, app, vars)
+	})
+	if err != nil || s.classifier == nil {
+		return c, nil, err
+	}
+
+	var classified []SecretClassification
+	for _, n := range vars.Keys() {
+		if c.SecretFlags[n] != SecretFlagAuto || old.SecretFlags[n] == SecretFlagAuto {
+			continue
+		}
+
+		reason, _ := s.classifier(n, *c.Vars[n])
+		classified = append(classified, SecretClassification{Name: n, Reason: reason})
+	}
+
+	return c, classified, nil
+}
+
+// ConfigsMarkSecret explicitly marks the variable as a secret, or as not being
+// a secret, overriding both DefaultSecretDetector and any automatic
+// classification. Marking a secret variable as not being a secret is a
+// security downgrade, so it's rejected with ErrSecurityDowngrade unless
+// AllowSecurityDowngrade is set. If the variable isn't set, ErrVarNotSet is
+// returned.
+func (s *configsService) ConfigsMarkSecret(ctx context.Context, app *App, name Variable, secret bool) (*Config, error) {
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		v, ok := old.Vars[name]
+		if !ok || v == nil {
+			return nil, nil, &VarError{Name: name, Err: ErrVarNotSet}
+		}
+
+		flag := SecretFlagPlain
+		if secret {
+			flag = SecretFlagSecret
+		}
+
+		return s.applyChange(ctx, app, configChange{vars: Vars{name: v}, flags: SecretFlags{name: flag}})
+	})
+}
@@ -0,0 +1,74 @@
+package empire
+
+import "github.com/jinzhu/gorm"
+
+// ConfigsCoalesceHistory removes configs from the apps history that have the
+// same vars as the config before them, returning the number of configs that
+// were removed.
+func (s *store) ConfigsCoalesceHistory(app *App) (int, error) {
+	return configsCoalesceHistory(s.db, app)
+}
+
+// configsCoalesceHistorySQL deletes the configs for an app whose vars,
+// expiries and secret flags are equal to those of the config created
+// immediately before them. Encrypting the same value twice gives different
+// ciphertexts, so configs are also considered equal when they have the same
+// fingerprint, which is of the plaintext. The first config of each distinct
+// state is always kept, along with the current config, and any config that's
+// referenced by a release, a proposal, a scheduled change or a frozen config.
+const configsCoalesceHistorySQL = `delete from configs where id in (
+	select h.id from (
+		select id, vars, expires, secret_flags, vars_fingerprint,
+			lag(vars) over w as prev_vars,
+			lag(expires) over w as prev_expires,
+			lag(secret_flags) over w as prev_secret_flags,
+			lag(vars_fingerprint) over w as prev_vars_fingerprint,
+			row_number() over w as n
+		from configs
+		where app_id = ?
+		window w as (order by created_at, seq)
+	) h
+	where h.n > 1
+		and (h.vars = h.prev_vars or h.vars_fingerprint = h.prev_vars_fingerprint)
+		and h.expires is not distinct from h.prev_expires
+		and h.secret_flags is not distinct from h.prev_secret_flags
+		and h.id <> (select ` + currentConfigSQL + ` from apps a where a.id = ?)
+		and not exists (select 1 from releases r where r.config_id = h.id)
+		and not exists (select 1 from config_proposals p where p.config_id = h.id)
+		and not exists (select 1 from scheduled_configs s where s.config_id = h.id)
+		and not exists (select 1 from frozen_configs f where f.config_id = h.id)
+)`
+
+// configsCoalesceHistory removes the redundant configs for the app within a
+// transaction.
+func configsCoalesceHistory(db *gorm.DB, app *App) (int, error) {
+	t := db.Begin()
+
+	r := t.Exec(configsCoalesceHistorySQL, app.ID, app.ID)
+	if err := r.Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	return int(r.RowsAffected), nil
+}
+
+// ConfigsCoalesceHistory removes configs from the apps history that are
+// identical to the config before them, like the ones created by applying the
+// same vars twice, returning the number of configs that were removed. The
+// current config, and any config used by a release, is always kept. This is a
+// maintenance operation, and running it again has no further effect.
+func (s *configsService) ConfigsCoalesceHistory(app *App) (int, error) {
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return s.store.ConfigsCoalesceHistory(app)
+}
@@ -0,0 +1,270 @@
+package empire
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// VarChange describes a change to a single variable. A nil Old value means the
+// variable was added, and a nil New value means it was removed.
+type VarChange struct {
+	Name Variable
+	Old  *string
+	New  *string
+
+	// True if either config treats the variable as a secret, including
+	// variables flagged with SecretFlags. It's only set by Config.Diff and
+	// Config.DiffIgnoring, since DiffVars doesn't have the configs.
+	Secret bool
+}
+
+// ConfigDiff is a list of changes between two sets of variables, sorted by
+// variable name.
+type ConfigDiff []VarChange
+
+// DiffVars returns the changes needed to go from old to new.
+func DiffVars(old, new Vars) ConfigDiff {
+	names := make(Vars)
+	for n := range old {
+		names[n] = nil
+	}
+	for n := range new {
+		names[n] = nil
+	}
+
+	diff := ConfigDiff{}
+	for _, n := range names.Keys() {
+		o, nw := old[n], new[n]
+		if varEqual(o, nw) {
+			continue
+		}
+		diff = append(diff, VarChange{Name: n, Old: o, New: nw})
+	}
+
+	return diff
+}
+
+// Vars returns the vars that, when applied, make the changes in the diff.
+// Removed variables have a nil value.
+func (d ConfigDiff) Vars() Vars {
+	vars := make(Vars, len(d))
+	for _, c := range d {
+		vars[c.Name] = c.New
+	}
+	return vars
+}
+
+// Diff returns the changes needed to go from other to c.
+func (c *Config) Diff(other *Config) ConfigDiff {
+	return markSecrets(DiffVars(other.Vars, c.Vars), other, c)
+}
+
+// DiffIgnoring is like Diff, but the variables matching any of the names in
+// ignore are left out of the comparison, like build shas that are expected to
+// differ between environments. Names can be glob patterns, like BUILD_*, with
+// the same syntax as Match.
+func (c *Config) DiffIgnoring(other *Config, ignore []Variable) ConfigDiff {
+	return markSecrets(DiffVars(withoutIgnored(other.Vars, ignore), withoutIgnored(c.Vars, ignore)), other, c)
+}
+
+// markSecrets sets Secret on each change in d for variables that either the old
+// or the new config treats as a secret.
+func markSecrets(d ConfigDiff, old, new *Config) ConfigDiff {
+	for i, c := range d {
+		d[i].Secret = old.IsSecret(c.Name) || new.IsSecret(c.Name)
+	}
+	return d
+}
+
+// withoutIgnored returns the vars whose names don't match any of the patterns
+// in ignore.
+func withoutIgnored(vars Vars, ignore []Variable) Vars {
+	filtered := make(Vars, len(vars))
+
+	for n, v := range vars {
+		if !matchesAny(n, ignore) {
+			filtered[n] = v
+		}
+	}
+
+	return filtered
+}
+
+// matchesAny returns true if name matches any of the glob patterns.
+func matchesAny(name Variable, patterns []Variable) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(string(p), string(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WhitespaceDiff returns the variables whose values in c and other are equal
+// after trimming leading and trailing whitespace, but not before, which
+// renders identically in a normal diff. Each variable maps to a description
+// with both values quoted, so the whitespace is visible.
+func (c *Config) WhitespaceDiff(other *Config) map[Variable]string {
+	diff := make(map[Variable]string)
+
+	for n, v := range c.Vars {
+		o := other.Vars[n]
+		if v == nil || o == nil || *v == *o {
+			continue
+		}
+
+		if strings.TrimSpace(*v) == strings.TrimSpace(*o) {
+			diff[n] = fmt.Sprintf("%q (was %q)", *v, *o)
+		}
+	}
+
+	return diff
+}
+
+// Summary returns a human readable summary of the changes, with one line per
+// variable, for changed, added and removed variables:
+//
+//	~ QUX=new (was old)
+//	+ FOO=bar
+//	- BAZ
+//
+// If redact is true, the values of secret variables are not included.
+func (d ConfigDiff) Summary(redact bool) string {
+	return d.summary(redact, DefaultSecretDetector)
+}
+
+// summary is like Summary, but uses detect in place of DefaultSecretDetector.
+func (d ConfigDiff) summary(redact bool, detect SecretDetector) string {
+	lines := make([]string, 0, len(d))
+
+	for _, c := range d {
+		secret := redact && c.isSecret(detect)
+
+		var line string
+		switch {
+		case c.Old == nil && secret:
+			line = fmt.Sprintf("+ %s=***", c.Name)
+		case c.Old == nil:
+			line = fmt.Sprintf("+ %s=%s", c.Name, *c.New)
+		case c.New == nil:
+			line = fmt.Sprintf("- %s", c.Name)
+		case secret:
+			line = fmt.Sprintf("~ %s (changed)", c.Name)
+		default:
+			line = fmt.Sprintf("~ %s=%s (was %s)", c.Name, *c.New, *c.Old)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// WritePatch writes the changes to w as a unified diff, so they can be reviewed
+// with the same tools as code:
+//
+//	--- a/config
+//	+++ b/config
+//	@@ -1,2 +1,2 @@
+//	-BAZ=old
+//	+FOO=bar
+//	-QUX=old
+//	+QUX=new
+//
+// Variables are written in the .env format, sorted by name, with changed
+// variables removed and added again, and the values of secret variables are
+// redacted. Nothing is written if there are no changes.
+func (d ConfigDiff) WritePatch(w io.Writer) error {
+	if len(d) == 0 {
+		return nil
+	}
+
+	changes := append(ConfigDiff{}, d.Redacted()...)
+	sort.Stable(varChangesByName(changes))
+
+	var (
+		lines          []string
+		removed, added int
+	)
+	for _, c := range changes {
+		if c.Old != nil {
+			lines = append(lines, fmt.Sprintf("-%s=%s", c.Name, envQuote(*c.Old)))
+			removed++
+		}
+
+		if c.New != nil {
+			lines = append(lines, fmt.Sprintf("+%s=%s", c.Name, envQuote(*c.New)))
+			added++
+		}
+	}
+
+	header := fmt.Sprintf("--- a/config\n+++ b/config\n@@ -%s +%s @@\n", hunkRange(removed), hunkRange(added))
+	_, err := io.WriteString(w, header+strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// hunkRange returns the range of a hunk with n lines that starts at the first
+// line, in the format used by unified diffs.
+func hunkRange(n int) string {
+	if n == 0 {
+		return "0,0"
+	}
+
+	return fmt.Sprintf("1,%d", n)
+}
+
+// varChangesByName implements sort.Interface to sort changes by variable name.
+type varChangesByName ConfigDiff
+
+func (s varChangesByName) Len() int           { return len(s) }
+func (s varChangesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s varChangesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// isSecret returns true if either the old or new value of the variable should
+// be treated as a secret, as marked by Secret or as classified by detect.
+func (c VarChange) isSecret(detect SecretDetector) bool {
+	if c.Secret {
+		return true
+	}
+
+	for _, v := range []*string{c.Old, c.New} {
+		if v != nil && detect(c.Name, *v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the diff with the old and new values of secret
+// variables replaced with ***, so it can be shown without leaking secrets.
+func (d ConfigDiff) Redacted() ConfigDiff {
+	return d.redacted(DefaultSecretDetector)
+}
+
+// redacted is like Redacted, but uses detect in place of DefaultSecretDetector.
+func (d ConfigDiff) redacted(detect SecretDetector) ConfigDiff {
+	redacted := make(ConfigDiff, len(d))
+
+	for i, c := range d {
+		if c.isSecret(detect) {
+			c.Old, c.New = redactedValue(c.Old), redactedValue(c.New)
+		}
+		redacted[i] = c
+	}
+
+	return redacted
+}
+
+// redactedValue returns *** in place of v, or nil if v is nil.
+func redactedValue(v *string) *string {
+	if v == nil {
+		return nil
+	}
+
+	mask := "***"
+	return &mask
+}
@@ -0,0 +1,230 @@
+package empire
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffVars(t *testing.T) {
+	old := Vars{
+		"RAILS_ENV": strptr("staging"),
+		"REMOVED":   strptr("bye"),
+		"SAME":      strptr("same"),
+	}
+
+	new := Vars{
+		"RAILS_ENV": strptr("production"),
+		"ADDED":     strptr("hi"),
+		"SAME":      strptr("same"),
+	}
+
+	expected := ConfigDiff{
+		{Name: "ADDED", New: strptr("hi")},
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		{Name: "REMOVED", Old: strptr("bye")},
+	}
+
+	if got, want := DiffVars(old, new), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffVars => %v; want %v", got, want)
+	}
+}
+
+func TestConfigDiff_Summary(t *testing.T) {
+	d := ConfigDiff{
+		{Name: "API_KEY", Old: strptr("old"), New: strptr("new")},
+		{Name: "BAZ", Old: strptr("baz")},
+		{Name: "FOO", New: strptr("bar")},
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		{Name: "SECRET_TOKEN", New: strptr("s3cr3t")},
+		{Name: "SECRET_TOKEN_OLD", Old: strptr("s3cr3t")},
+	}
+
+	tests := []struct {
+		redact bool
+		out    string
+	}{
+		{false, `~ API_KEY=new (was old)
+- BAZ
++ FOO=bar
+~ RAILS_ENV=production (was staging)
++ SECRET_TOKEN=s3cr3t
+- SECRET_TOKEN_OLD`},
+		{true, `~ API_KEY (changed)
+- BAZ
++ FOO=bar
+~ RAILS_ENV=production (was staging)
++ SECRET_TOKEN=***
+- SECRET_TOKEN_OLD`},
+	}
+
+	for _, tt := range tests {
+		if got, want := d.Summary(tt.redact), tt.out; got != want {
+			t.Errorf("Summary(%v) =>\n%s\nwant\n%s", tt.redact, got, want)
+		}
+	}
+}
+
+func TestConfigDiff_Vars(t *testing.T) {
+	old := Vars{"RAILS_ENV": strptr("staging"), "REMOVED": strptr("bye"), "SAME": strptr("same")}
+	desired := Vars{"RAILS_ENV": strptr("production"), "SAME": strptr("same")}
+
+	vars := DiffVars(old, desired).Vars()
+
+	if got, want := vars, (Vars{"RAILS_ENV": strptr("production"), "REMOVED": nil}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	if got, want := mergeVars(old, vars), desired; !reflect.DeepEqual(got, want) {
+		t.Fatalf("mergeVars => %v; want %v", got, want)
+	}
+}
+
+func TestConfigDiff_Summary_SecretOldValue(t *testing.T) {
+	detector := func(name Variable, value string) bool {
+		return strings.HasPrefix(value, "sk_")
+	}
+
+	d := ConfigDiff{{Name: "STRIPE", Old: strptr("sk_live"), New: strptr("placeholder")}}
+
+	if got, want := d.summary(true, detector), "~ STRIPE (changed)"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+}
+
+func TestConfigDiff_Redacted(t *testing.T) {
+	detector := func(name Variable, value string) bool {
+		return strings.HasPrefix(value, "sk_")
+	}
+
+	d := ConfigDiff{
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		{Name: "STRIPE", Old: nil, New: strptr("sk_live")},
+	}
+
+	expected := ConfigDiff{
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		{Name: "STRIPE", Old: nil, New: strptr("***")},
+	}
+
+	if got, want := d.redacted(detector), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Redacted => %v; want %v", got, want)
+	}
+
+	// The original diff is unchanged.
+	if got, want := *d[1].New, "sk_live"; got != want {
+		t.Fatalf("New => %q; want %q", got, want)
+	}
+}
+
+func TestConfig_WhitespaceDiff(t *testing.T) {
+	old := &Config{Vars: Vars{
+		"RAILS_ENV": strptr("production"),
+		"TABBED":    strptr("value"),
+		"CHANGED":   strptr("a"),
+		"SAME":      strptr("same "),
+	}}
+	c := &Config{Vars: Vars{
+		"RAILS_ENV": strptr("production "),
+		"TABBED":    strptr("\tvalue"),
+		"CHANGED":   strptr("b "),
+		"SAME":      strptr("same "),
+		"ADDED":     strptr(" "),
+	}}
+
+	expected := map[Variable]string{
+		"RAILS_ENV": `"production " (was "production")`,
+		"TABBED":    `"\tvalue" (was "value")`,
+	}
+
+	if got, want := c.WhitespaceDiff(old), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("WhitespaceDiff => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_DiffIgnoring(t *testing.T) {
+	staging := &Config{Vars: Vars{
+		"BUILD_SHA":  strptr("abc"),
+		"BUILD_TIME": strptr("1"),
+		"DEPLOYED":   strptr("monday"),
+		"RAILS_ENV":  strptr("staging"),
+		"SHARED":     strptr("same"),
+	}}
+	prod := &Config{Vars: Vars{
+		"BUILD_SHA": strptr("def"),
+		"DEPLOYED":  strptr("tuesday"),
+		"RAILS_ENV": strptr("production"),
+		"SHARED":    strptr("same"),
+	}}
+
+	expected := ConfigDiff{
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+	}
+
+	if got, want := prod.DiffIgnoring(staging, []Variable{"BUILD_*", "DEPLOYED"}), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffIgnoring => %v; want %v", got, want)
+	}
+
+	if got, want := len(prod.DiffIgnoring(staging, nil)), 4; got != want {
+		t.Fatalf("len(DiffIgnoring) => %d; want %d", got, want)
+	}
+}
+
+func TestConfigDiff_WritePatch(t *testing.T) {
+	d := ConfigDiff{
+		{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		{Name: "API_KEY", New: strptr("secret")},
+		{Name: "GREETING", Old: strptr("hello world")},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := d.WritePatch(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `--- a/config
++++ b/config
+@@ -1,2 +1,2 @@
++API_KEY=***
+-GREETING="hello world"
+-RAILS_ENV=staging
++RAILS_ENV=production
+`
+
+	if got, want := buf.String(), expected; got != want {
+		t.Fatalf("WritePatch => %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := (ConfigDiff{}).WritePatch(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != "" {
+		t.Fatalf("WritePatch => %q; want nothing", got)
+	}
+}
+
+func TestConfig_Diff_FlaggedSecret(t *testing.T) {
+	old := &Config{Vars: Vars{"STRIPE": strptr("sk_test")}}
+	c := &Config{
+		Vars:        Vars{"STRIPE": strptr("sk_live")},
+		SecretFlags: SecretFlags{"STRIPE": SecretFlagSecret},
+	}
+
+	d := c.Diff(old)
+
+	if got, want := d.Summary(true), "~ STRIPE (changed)"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := d.WritePatch(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); strings.Contains(got, "sk_") {
+		t.Fatalf("WritePatch => %q; want the values redacted", got)
+	}
+}
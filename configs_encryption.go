@@ -0,0 +1,538 @@
+package empire
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrConfigEncrypted is returned when reading a config with encrypted
+	// values, but no keys are configured to decrypt them with.
+	ErrConfigEncrypted = errors.New("Config values are encrypted, but no encryption keys are configured.")
+
+	// ErrMasterKeyNotFound is returned when an apps data key is wrapped by a
+	// master key that isn't configured.
+	ErrMasterKeyNotFound = errors.New("Data key is wrapped by a master key that isn't configured.")
+
+	// ErrNoMasterKeys is returned when rewrapping data keys without any
+	// master keys configured.
+	ErrNoMasterKeys = errors.New("No master keys are configured.")
+
+	// ErrInvalidKey is returned when a key isn't 32 bytes.
+	ErrInvalidKey = errors.New("Encryption keys must be 32 bytes.")
+
+	// errMalformedValue is returned when an encrypted value can't be decoded.
+	errMalformedValue = errors.New("Encrypted config value is malformed.")
+)
+
+// encryptedValuePrefix marks a value that's encrypted with an apps data key.
+// Values without it are plaintext, like the ones stored before encryption was
+// enabled, so encryption can be enabled without migrating existing configs.
+const encryptedValuePrefix = "enc:v1:"
+
+// valueSizeSQL is the size, in bytes, of the plaintext of the hstore value
+// e.value, whether or not it's encrypted. An encrypted value is the prefix,
+// then the unpadded base64 of a 12 byte nonce, the ciphertext, which is as
+// long as the plaintext, and a 16 byte tag.
+const valueSizeSQL = `case when left(e.value, 7) = 'enc:v1:'
+	then (octet_length(e.value) - 7) * 3 / 4 - 28
+	else coalesce(octet_length(e.value), 0) end`
+
+// KeyProvider provides the data key that an apps config values are encrypted
+// with. Keys are looked up by app id, rather than by name, so that they
+// survive the app being renamed.
+type KeyProvider interface {
+	// DataKey returns the 32 byte data key for the app, creating one if
+	// the app doesn't have a key yet. The same app must always get the
+	// same key.
+	DataKey(appID string) ([]byte, error)
+}
+
+// MasterKey wraps the data keys of each app, so that the data keys can be
+// stored alongside the configs that they encrypt. Using a different master
+// key only requires the data keys to be rewrapped, without re-encrypting any
+// config values.
+type MasterKey interface {
+	// ID identifies the master key, so that the key a data key was
+	// wrapped with can be found when there's more than one.
+	ID() string
+
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// NewAESMasterKey returns a MasterKey that wraps data keys with AES-256-GCM,
+// using a 32 byte key.
+func NewAESMasterKey(id string, key []byte) (MasterKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesMasterKey{id: id, aead: aead}, nil
+}
+
+// aesMasterKey is a MasterKey that wraps data keys with AES-GCM.
+type aesMasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func (k *aesMasterKey) ID() string {
+	return k.id
+}
+
+func (k *aesMasterKey) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(k.aead, dataKey)
+}
+
+func (k *aesMasterKey) Unwrap(wrapped []byte) ([]byte, error) {
+	return open(k.aead, wrapped)
+}
+
+// newAEAD returns an AES-256-GCM cipher using key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts ciphertext from seal.
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errMalformedValue
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// Encryptor encrypts config values before they're stored.
+type Encryptor interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+}
+
+// valueCipher is an Encryptor that encrypts config values with an apps data
+// key.
+type valueCipher struct {
+	aead cipher.AEAD
+}
+
+func newValueCipher(dataKey []byte) (*valueCipher, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueCipher{aead: aead}, nil
+}
+
+// Encrypt returns the value as it's stored: encryptedValuePrefix, followed
+// by the base64 encoded nonce and ciphertext.
+func (c *valueCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	b, err := seal(c.aead, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(encryptedValuePrefix + base64.RawStdEncoding.EncodeToString(b)), nil
+}
+
+// Decrypt returns the plaintext of a value from Encrypt.
+func (c *valueCipher) Decrypt(stored []byte) ([]byte, error) {
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(string(stored), encryptedValuePrefix))
+	if err != nil {
+		return nil, errMalformedValue
+	}
+
+	return open(c.aead, b)
+}
+
+// isEncrypted returns true if the value was encrypted by a valueCipher.
+func isEncrypted(v *string) bool {
+	return v != nil && strings.HasPrefix(*v, encryptedValuePrefix)
+}
+
+// encryptVars returns a copy of vars with the values encrypted. Nil and empty
+// values are left as is, so that queries that look for unset or empty
+// variables, like ConfigsAppsMissingVar, still work.
+func encryptVars(c *valueCipher, vars Vars) (Vars, error) {
+	encrypted := make(Vars, len(vars))
+
+	for n, v := range vars {
+		if v == nil || *v == "" {
+			encrypted[n] = v
+			continue
+		}
+
+		b, err := c.Encrypt([]byte(*v))
+		if err != nil {
+			return nil, err
+		}
+
+		s := string(b)
+		encrypted[n] = &s
+	}
+
+	return encrypted, nil
+}
+
+// decryptVars returns a copy of vars with any encrypted values decrypted.
+func decryptVars(c *valueCipher, vars Vars) (Vars, error) {
+	decrypted := make(Vars, len(vars))
+
+	for n, v := range vars {
+		v, err := decryptValue(c, v)
+		if err != nil {
+			return nil, &VarError{Name: n, Err: err}
+		}
+		decrypted[n] = v
+	}
+
+	return decrypted, nil
+}
+
+// decryptValue decrypts v if it's encrypted.
+func decryptValue(c *valueCipher, v *string) (*string, error) {
+	if !isEncrypted(v) {
+		return v, nil
+	}
+
+	b, err := c.Decrypt([]byte(*v))
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(b)
+	return &s, nil
+}
+
+// envelopeKeys is a KeyProvider that gives each app its own random data key,
+// which is stored in config_data_keys wrapped by a MasterKey. If the data key
+// for one app is exposed, only that apps configs can be decrypted with it.
+type envelopeKeys struct {
+	// Data keys are always read and created outside of any transaction
+	// that the config is being stored in, so that a key that's been used
+	// can't be lost to a rollback.
+	db *gorm.DB
+
+	// The first key wraps new data keys, and the rest are only used to
+	// unwrap data keys that haven't been rewrapped yet.
+	masters []MasterKey
+
+	mu sync.Mutex
+
+	// Unwrapped data keys, by app id. Rewrapping doesn't change the data
+	// keys, so they never need to be evicted.
+	keys map[string][]byte
+}
+
+func newEnvelopeKeys(db *gorm.DB, masters []MasterKey) *envelopeKeys {
+	return &envelopeKeys{
+		db:      db,
+		masters: masters,
+		keys:    make(map[string][]byte),
+	}
+}
+
+// DataKey implements the KeyProvider interface.
+func (k *envelopeKeys) DataKey(appID string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[appID]; ok {
+		return key, nil
+	}
+
+	key, err := k.find(appID)
+	if err == sql.ErrNoRows {
+		key, err = k.create(appID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k.keys[appID] = key
+	return key, nil
+}
+
+// find selects and unwraps the data key for the app.
+func (k *envelopeKeys) find(appID string) ([]byte, error) {
+	var (
+		wrapped []byte
+		master  string
+	)
+
+	row := k.db.Raw(`select wrapped_key, master_key_id from config_data_keys where app_id = ?`, appID).Row()
+	if err := row.Scan(&wrapped, &master); err != nil {
+		return nil, err
+	}
+
+	return k.unwrap(master, wrapped)
+}
+
+// create generates a data key for the app, unless another caller created one
+// first, and returns the key that was stored.
+func (k *envelopeKeys) create(appID string) ([]byte, error) {
+	if len(k.masters) == 0 {
+		return nil, ErrNoMasterKeys
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	master := k.masters[0]
+	wrapped, err := master.Wrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.db.Exec(`insert into config_data_keys (app_id, wrapped_key, master_key_id)
+		select ?, ?, ? where not exists (select 1 from config_data_keys where app_id = ?)`,
+		appID, wrapped, master.ID(), appID).Error; err != nil {
+		return nil, err
+	}
+
+	return k.find(appID)
+}
+
+// unwrap unwraps a data key with the master key that it was wrapped with.
+func (k *envelopeKeys) unwrap(masterID string, wrapped []byte) ([]byte, error) {
+	for _, m := range k.masters {
+		if m.ID() == masterID {
+			return m.Unwrap(wrapped)
+		}
+	}
+
+	return nil, ErrMasterKeyNotFound
+}
+
+// Rewrap rewraps every data key that isn't wrapped by the first master key
+// with it, in a single transaction, returning the number of keys that were
+// rewrapped. Config values are encrypted with the data keys, which don't
+// change, so none of them need to be re-encrypted. Once this returns, the
+// master keys that were replaced are no longer needed.
+func (k *envelopeKeys) Rewrap() (int, error) {
+	if len(k.masters) == 0 {
+		return 0, ErrNoMasterKeys
+	}
+
+	master := k.masters[0]
+
+	t := k.db.Begin()
+
+	rows, err := t.Raw(`select app_id, wrapped_key, master_key_id from config_data_keys where master_key_id <> ? for update`, master.ID()).Rows()
+	if err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	type dataKey struct {
+		appID, master string
+		wrapped       []byte
+	}
+
+	var keys []dataKey
+	for rows.Next() {
+		var key dataKey
+		if err := rows.Scan(&key.appID, &key.wrapped, &key.master); err != nil {
+			rows.Close()
+			t.Rollback()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	for _, key := range keys {
+		unwrapped, err := k.unwrap(key.master, key.wrapped)
+		if err != nil {
+			t.Rollback()
+			return 0, err
+		}
+
+		wrapped, err := master.Wrap(unwrapped)
+		if err != nil {
+			t.Rollback()
+			return 0, err
+		}
+
+		if err := t.Exec(`update config_data_keys set wrapped_key = ?, master_key_id = ? where app_id = ?`, wrapped, master.ID(), key.appID).Error; err != nil {
+			t.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
+// cipherFor returns the cipher for the values of the app, or nil if config
+// values aren't encrypted.
+func (s *store) cipherFor(appID string) (*valueCipher, error) {
+	if s.keys == nil {
+		return nil, nil
+	}
+
+	key, err := s.keys.DataKey(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newValueCipher(key)
+}
+
+// ConfigsEncryptor returns the Encryptor that the values of the apps configs
+// are encrypted with, or nil if they aren't encrypted.
+func (s *store) ConfigsEncryptor(app *App) (Encryptor, error) {
+	c, err := s.cipherFor(app.ID)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// encryptVars returns vars with the values encrypted with the apps data key,
+// or vars itself if config values aren't encrypted.
+func (s *store) encryptVars(appID string, vars Vars) (Vars, error) {
+	c, err := s.cipherFor(appID)
+	if err != nil || c == nil {
+		return vars, err
+	}
+
+	return encryptVars(c, vars)
+}
+
+// decryptVars returns vars with any encrypted values decrypted with the apps
+// data key. The key is only looked up if there's something to decrypt, so
+// reading plaintext configs never creates a data key.
+func (s *store) decryptVars(appID string, vars Vars) (Vars, error) {
+	encrypted := false
+	for _, v := range vars {
+		if isEncrypted(v) {
+			encrypted = true
+			break
+		}
+	}
+
+	if !encrypted {
+		return vars, nil
+	}
+
+	if s.keys == nil {
+		return nil, ErrConfigEncrypted
+	}
+
+	c, err := s.cipherFor(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptVars(c, vars)
+}
+
+// decryptValue decrypts a single value of the apps config.
+func (s *store) decryptValue(appID string, v *string) (*string, error) {
+	vars, err := s.decryptVars(appID, Vars{"": v})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars[""], nil
+}
+
+// decryptConfig decrypts the values of a config that was read from the
+// database.
+func (s *store) decryptConfig(c *Config) error {
+	vars, err := s.decryptVars(c.AppID, c.Vars)
+	if err != nil {
+		return err
+	}
+
+	c.Vars = vars
+	return nil
+}
+
+// openConfig decrypts a config that was read from the database, then verifies
+// its integrity.
+func (s *store) openConfig(c *Config) error {
+	if err := s.decryptConfig(c); err != nil {
+		return err
+	}
+
+	return s.checkIntegrity(c)
+}
+
+// sealConfig prepares the config to be inserted by storing the fingerprint of
+// its plaintext vars, then encrypting them. The returned function puts the
+// plaintext vars back, and must be called once the config has been inserted,
+// whether or not that succeeded.
+func (s *store) sealConfig(c *Config) (restore func(), err error) {
+	fingerprint := c.Fingerprint()
+	c.VarsFingerprint = &fingerprint
+
+	return s.sealVars(c.AppID, &c.Vars)
+}
+
+// sealVars encrypts vars in place, returning a function that puts the
+// plaintext back, like sealConfig.
+func (s *store) sealVars(appID string, vars *Vars) (restore func(), err error) {
+	plaintext := *vars
+
+	encrypted, err := s.encryptVars(appID, plaintext)
+	if err != nil {
+		return func() {}, err
+	}
+
+	*vars = encrypted
+	return func() { *vars = plaintext }, nil
+}
+
+// ConfigsRewrapDataKeys rewraps the data keys of every app with the current
+// master key. See envelopeKeys.Rewrap.
+func (s *store) ConfigsRewrapDataKeys() (int, error) {
+	k, ok := s.keys.(*envelopeKeys)
+	if !ok {
+		return 0, ErrNoMasterKeys
+	}
+
+	return k.Rewrap()
+}
@@ -0,0 +1,198 @@
+package empire
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValueCipher(t *testing.T) {
+	c, err := newValueCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, empty := "hunter2", ""
+	vars := Vars{"SECRET": &secret, "EMPTY": &empty, "UNSET": nil}
+
+	encrypted, err := encryptVars(c, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := *encrypted["SECRET"]; !strings.HasPrefix(v, encryptedValuePrefix) || strings.Contains(v, secret) {
+		t.Fatalf("SECRET => %q; want ciphertext", v)
+	}
+
+	// Empty and unset values aren't encrypted.
+	if got, want := encrypted["EMPTY"], &empty; got != want {
+		t.Fatalf("EMPTY => %v; want %v", got, want)
+	}
+
+	if v, ok := encrypted["UNSET"]; !ok || v != nil {
+		t.Fatalf("UNSET => %v; want nil", v)
+	}
+
+	decrypted, err := decryptVars(c, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := decrypted, vars; !reflect.DeepEqual(got, want) {
+		t.Fatalf("decryptVars => %v; want %v", got, want)
+	}
+
+	// Plaintext values, like ones stored before encryption was enabled, are
+	// read as is.
+	plain, err := decryptVars(c, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := plain, vars; !reflect.DeepEqual(got, want) {
+		t.Fatalf("decryptVars => %v; want %v", got, want)
+	}
+
+	// Values can only be decrypted with the apps own key.
+	other, err := newValueCipher(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decryptVars(other, encrypted); err == nil {
+		t.Fatal("expected an error decrypting with another key")
+	}
+}
+
+func TestValueCipher_Size(t *testing.T) {
+	c, err := newValueCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plaintext size that valueSizeSQL calculates from the size of the
+	// stored value.
+	size := func(stored []byte) int {
+		return (len(stored)-len(encryptedValuePrefix))*3/4 - 28
+	}
+
+	for n := 1; n < 100; n++ {
+		stored, err := c.Encrypt(bytes.Repeat([]byte("a"), n))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := size(stored); got != n {
+			t.Fatalf("size of %d byte value => %d", n, got)
+		}
+	}
+}
+
+func TestAESMasterKey(t *testing.T) {
+	if _, err := NewAESMasterKey("short", []byte("key")); err != ErrInvalidKey {
+		t.Fatalf("err => %v; want %v", err, ErrInvalidKey)
+	}
+
+	k, err := NewAESMasterKey("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey := bytes.Repeat([]byte{3}, 32)
+
+	wrapped, err := k.Wrap(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(wrapped, dataKey) {
+		t.Fatal("wrapped key contains the data key")
+	}
+
+	unwrapped, err := k.Unwrap(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("Unwrap => %x; want %x", unwrapped, dataKey)
+	}
+}
+
+func TestStore_DecryptVars(t *testing.T) {
+	c, err := newValueCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "hunter2"
+	encrypted, err := encryptVars(c, Vars{"SECRET": &secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted values can't be read without keys.
+	s := &store{}
+	if _, err := s.decryptVars("app", encrypted); err != ErrConfigEncrypted {
+		t.Fatalf("err => %v; want %v", err, ErrConfigEncrypted)
+	}
+
+	s.keys = keyProviderFunc(func(appID string) ([]byte, error) {
+		return bytes.Repeat([]byte{1}, 32), nil
+	})
+
+	vars, err := s.decryptVars("app", encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (Vars{"SECRET": &secret}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("decryptVars => %v; want %v", got, want)
+	}
+}
+
+func TestStore_SealConfig(t *testing.T) {
+	s := &store{keys: keyProviderFunc(func(appID string) ([]byte, error) {
+		return bytes.Repeat([]byte{1}, 32), nil
+	})}
+
+	secret := "hunter2"
+	c := &Config{AppID: "app", Vars: Vars{"SECRET": &secret}}
+	fingerprint := c.Fingerprint()
+
+	restore, err := s.sealConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isEncrypted(c.Vars["SECRET"]) {
+		t.Fatalf("SECRET => %q; want ciphertext", *c.Vars["SECRET"])
+	}
+
+	// The fingerprint is of the plaintext, so it isn't changed by
+	// BeforeCreate.
+	if err := c.BeforeCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := *c.VarsFingerprint, fingerprint; got != want {
+		t.Fatalf("VarsFingerprint => %s; want %s", got, want)
+	}
+
+	restore()
+
+	if got, want := c.Vars, (Vars{"SECRET": &secret}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	if err := c.checkIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type keyProviderFunc func(string) ([]byte, error)
+
+func (f keyProviderFunc) DataKey(appID string) ([]byte, error) {
+	return f(appID)
+}
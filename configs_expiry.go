@@ -0,0 +1,243 @@
+package empire
+
+import (
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq/hstore"
+	"github.com/remind101/pkg/timex"
+	"golang.org/x/net/context"
+)
+
+// ErrInvalidTTL is returned when setting a variable with a TTL that isn't
+// positive.
+var ErrInvalidTTL = errors.New("A config var TTL must be positive.")
+
+// configsExpiringAppsSQL selects the ids of the apps whose current config has
+// variables that expire.
+const configsExpiringAppsSQL = `select a.id
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+where c.expires is not null and c.expires <> ''::hstore
+order by a.id`
+
+// ConfigsExpiringApps returns the ids of the apps whose current config has
+// variables that expire, whether or not they've expired yet.
+func (s *store) ConfigsExpiringApps() ([]string, error) {
+	rows, err := s.db.Raw(configsExpiringAppsSQL).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Expirations maps the names of variables to the time that they expire.
+type Expirations map[Variable]time.Time
+
+// Scan implements the sql.Scanner interface.
+func (e *Expirations) Scan(src interface{}) error {
+	h := hstore.Hstore{}
+	if err := h.Scan(src); err != nil {
+		return err
+	}
+
+	expirations := make(Expirations, len(h.Map))
+
+	for k, v := range h.Map {
+		if !v.Valid {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, v.String)
+		if err != nil {
+			return err
+		}
+		expirations[Variable(k)] = t
+	}
+
+	*e = expirations
+
+	return nil
+}
+
+// Value implements the driver.Value interface. Times are stored in UTC, in
+// RFC 3339 format, and no expirations are stored as NULL.
+func (e Expirations) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+
+	names := make([]Variable, 0, len(e))
+	for n := range e {
+		names = append(names, n)
+	}
+	sort.Sort(variablesByName(names))
+
+	parts := make([]string, 0, len(e))
+	for _, n := range names {
+		parts = append(parts, hstoreQuote(string(n))+"=>"+hstoreQuote(e[n].UTC().Format(time.RFC3339Nano)))
+	}
+
+	return []byte(strings.Join(parts, ",")), nil
+}
+
+// Expired returns the sorted names of the variables in the config that have
+// expired as of now.
+func (c *Config) Expired(now time.Time) []Variable {
+	var expired []Variable
+
+	for _, n := range c.Vars.Keys() {
+		if t, ok := c.Expires[n]; ok && !t.After(now) {
+			expired = append(expired, n)
+		}
+	}
+
+	return expired
+}
+
+// Unexpired returns a copy of the config without the variables that have
+// expired as of now. If nothing has expired, the config is returned as is.
+func (c *Config) Unexpired(now time.Time) *Config {
+	expired := c.Expired(now)
+	if len(expired) == 0 {
+		return c
+	}
+
+	vars := make(Vars, len(c.Vars))
+	for n, v := range c.Vars {
+		vars[n] = v
+	}
+
+	expires := make(Expirations, len(c.Expires))
+	for n, t := range c.Expires {
+		expires[n] = t
+	}
+
+	for _, n := range expired {
+		delete(vars, n)
+		delete(expires, n)
+	}
+
+	u := *c
+	u.Vars = vars
+	u.Expires = expires
+	return &u
+}
+
+// mergeExpirations returns the expirations for a config with vars merged into
+// old, removing any expired variables from merged. Variables that are set, or
+// unset, by vars no longer expire.
+func mergeExpirations(old Expirations, vars, merged Vars, now time.Time) Expirations {
+	var expires Expirations
+
+	for n, t := range old {
+		if _, ok := vars[n]; ok {
+			continue
+		}
+
+		if !t.After(now) {
+			delete(merged, n)
+			continue
+		}
+
+		if expires == nil {
+			expires = make(Expirations)
+		}
+		expires[n] = t
+	}
+
+	return expires
+}
+
+// ConfigsSetWithTTL sets the variable to value, and expires it after ttl.
+// Expired variables are dropped whenever a config is read, so they're never
+// added to the environment of a new release, even if ConfigsPruneExpired
+// hasn't removed them yet. Processes that are already running keep the value
+// until the app is released again, which ConfigsPruneAllExpired does once the
+// variable expires. Setting the variable again, without a TTL, removes the
+// expiry.
+func (s *configsService) ConfigsSetWithTTL(ctx context.Context, app *App, name Variable, value string, ttl time.Duration) (*Config, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		expires := Expirations{name: timex.Now().Add(ttl).UTC()}
+		return s.applyChange(ctx, app, configChange{vars: Vars{name: &value}, expires: expires})
+	})
+}
+
+// ConfigsPruneExpired removes the variables that have expired from the apps
+// current config, and releases the app, returning the new config and the names
+// of the variables that were removed. If nothing has expired, the current
+// config is returned.
+func (s *configsService) ConfigsPruneExpired(ctx context.Context, app *App) (*Config, []Variable, error) {
+	var expired []Variable
+	c, err := s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		expired = old.Expired(timex.Now())
+		if len(expired) == 0 {
+			return old, nil, nil
+		}
+
+		vars := make(Vars, len(expired))
+		for _, n := range expired {
+			vars[n] = nil
+		}
+
+		return s.apply(ctx, app, vars)
+	})
+	return c, expired, err
+}
+
+// ConfigsPruneAllExpired runs ConfigsPruneExpired for every app with variables
+// that have expired, so that running processes don't keep expired values,
+// returning the names of the variables that were removed, and the error for
+// each app that couldn't be pruned, by app name.
+func (s *configsService) ConfigsPruneAllExpired(ctx context.Context) (map[string][]Variable, map[string]error, error) {
+	ids, err := s.store.ConfigsExpiringApps()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pruned := make(map[string][]Variable)
+	failed := make(map[string]error)
+
+	for _, id := range ids {
+		id := id
+		app, err := s.store.AppsFirst(AppsQuery{ID: &id})
+		if err != nil {
+			return pruned, failed, err
+		}
+
+		_, expired, err := s.ConfigsPruneExpired(WithReason(ctx, "Expired config vars"), app)
+		if err != nil {
+			failed[app.Name] = err
+			continue
+		}
+
+		if len(expired) > 0 {
+			pruned[app.Name] = expired
+		}
+	}
+
+	return pruned, failed, nil
+}
@@ -0,0 +1,92 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig_Unexpired(t *testing.T) {
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := &Config{
+		Vars: Vars{
+			"EXPIRED":   strptr("old token"),
+			"EXPIRING":  strptr("new token"),
+			"RAILS_ENV": strptr("production"),
+		},
+		Expires: Expirations{
+			"EXPIRED":  now,
+			"EXPIRING": now.Add(time.Hour),
+		},
+	}
+
+	if got, want := c.Expired(now), []Variable{"EXPIRED"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expired => %v; want %v", got, want)
+	}
+
+	u := c.Unexpired(now)
+
+	if got, want := u.Vars, (Vars{"EXPIRING": strptr("new token"), "RAILS_ENV": strptr("production")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got.format(false), want.format(false))
+	}
+
+	if got, want := u.Expires, (Expirations{"EXPIRING": now.Add(time.Hour)}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expires => %v; want %v", got, want)
+	}
+
+	// The original config is unchanged.
+	if got, want := len(c.Vars), 3; got != want {
+		t.Fatalf("len(c.Vars) => %d; want %d", got, want)
+	}
+}
+
+func TestMergeExpirations(t *testing.T) {
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	old := Expirations{
+		"EXPIRED":  now.Add(-time.Hour),
+		"EXPIRING": now.Add(time.Hour),
+		"RESET":    now.Add(-time.Hour),
+	}
+	vars := Vars{"RESET": strptr("permanent")}
+	merged := Vars{
+		"EXPIRED":  strptr("a"),
+		"EXPIRING": strptr("b"),
+		"RESET":    strptr("permanent"),
+	}
+
+	expires := mergeExpirations(old, vars, merged, now)
+
+	if got, want := expires, (Expirations{"EXPIRING": now.Add(time.Hour)}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mergeExpirations => %v; want %v", got, want)
+	}
+
+	if got, want := merged, (Vars{"EXPIRING": strptr("b"), "RESET": strptr("permanent")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged => %v; want %v", got.format(false), want.format(false))
+	}
+}
+
+func TestExpirations_ValueScan(t *testing.T) {
+	e := Expirations{
+		"TOKEN": time.Date(2015, 1, 1, 12, 30, 0, 500, time.UTC),
+	}
+
+	v, err := e.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Expirations
+	if err := got.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, e) {
+		t.Fatalf("round trip => %v; want %v", got, e)
+	}
+
+	if v, _ := (Expirations{}).Value(); v != nil {
+		t.Fatalf("Value => %v; want nil", v)
+	}
+}
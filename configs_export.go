@@ -0,0 +1,193 @@
+package empire
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// k8sManifest represents the subset of a Kubernetes v1 Secret or ConfigMap
+// manifest that we generate, or import with ImportK8sSecret.
+type k8sManifest struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// WriteK8sSecret writes a Kubernetes v1 Secret manifest containing the secret
+// variables within the config. Values are base64 encoded, as Kubernetes
+// expects. Variables without a value are skipped.
+func (c *Config) WriteK8sSecret(w io.Writer, name, namespace string) error {
+	secret, _ := c.Partition()
+
+	data := make(map[string]string)
+	for k, v := range secret {
+		if v == nil {
+			continue
+		}
+		data[string(k)] = base64.StdEncoding.EncodeToString([]byte(*v))
+	}
+
+	return writeK8sManifest(w, &k8sManifest{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sMetadata{Name: name, Namespace: namespace},
+		Type:       "Opaque",
+		Data:       data,
+	})
+}
+
+// WriteK8sConfigMap writes a Kubernetes v1 ConfigMap manifest containing the
+// non-secret variables within the config. Variables without a value are
+// skipped.
+func (c *Config) WriteK8sConfigMap(w io.Writer, name, namespace string) error {
+	_, plain := c.Partition()
+
+	data := make(map[string]string)
+	for k, v := range plain {
+		if v == nil {
+			continue
+		}
+		data[string(k)] = *v
+	}
+
+	return writeK8sManifest(w, &k8sManifest{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   k8sMetadata{Name: name, Namespace: namespace},
+		Data:       data,
+	})
+}
+
+func writeK8sManifest(w io.Writer, m *k8sManifest) error {
+	raw, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(raw)
+	return err
+}
+
+// HCLIdentifierPattern matches names that are valid HCL identifiers.
+var HCLIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// WriteTFVars writes the config as Terraform variable definitions, in the
+// .tfvars format, with one KEY = "value" line per variable, sorted by name.
+// Variables whose names aren't valid HCL identifiers are skipped.
+func (c *Config) WriteTFVars(w io.Writer) error {
+	for _, k := range c.Vars.Keys() {
+		v := c.Vars[k]
+		if v == nil || !HCLIdentifierPattern.MatchString(string(k)) {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s = %s\n", k, hclQuote(*v)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hclEscaper escapes the characters that have special meaning within an HCL
+// string, including the start of interpolation and template directives.
+var hclEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+// hclQuote returns s as a quoted HCL string.
+func hclQuote(s string) string {
+	return `"` + hclEscaper.Replace(s) + `"`
+}
+
+// SafeExport writes every variable in the config in the .env format, sorted by
+// name, with the values of secrets replaced by ***, so the output can be
+// attached to a bug report. To make sure that no secret leaks through a
+// variable that isn't classified as one, a value is also masked if it looks
+// like a secret to DefaultSecretClassifier, or contains the value of a secret
+// variable, and passwords within urls are masked. Values that need it are
+// quoted, and unset variables are written as comments.
+func (c *Config) SafeExport(w io.Writer) error {
+	secret, _ := c.Partition()
+
+	for _, k := range c.Vars.Keys() {
+		v := c.Vars[k]
+
+		var line string
+		if v == nil {
+			line = fmt.Sprintf("# %s is unset", k)
+		} else {
+			line = fmt.Sprintf("%s=%s", k, envQuote(safeValue(k, *v, secret)))
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// safeValue returns the value of a variable as it should be shown by
+// SafeExport, given the secret variables in the config.
+func safeValue(name Variable, value string, secret Vars) string {
+	if _, ok := secret[name]; ok {
+		return "***"
+	}
+
+	if _, ok := DefaultSecretClassifier(name, value); ok {
+		return "***"
+	}
+
+	for _, s := range secret {
+		if *s != "" && strings.Contains(value, *s) {
+			return "***"
+		}
+	}
+
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			masked := url.User(u.User.Username()).String() + ":***@"
+			if r := strings.Replace(value, u.User.String()+"@", masked, 1); r != value {
+				return r
+			}
+
+			// The userinfo isn't written the way it would be encoded, so it
+			// can't be replaced reliably.
+			return "***"
+		}
+	}
+
+	return value
+}
+
+// envQuote returns s as is if it can be written unquoted in a .env file, and
+// as a double quoted string otherwise.
+func envQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\r\"'#$\\") {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
@@ -0,0 +1,212 @@
+package empire
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrFrozenExists is returned when freezing a config under a name that's
+	// already used by the app. Frozen configs can't be moved.
+	ErrFrozenExists = errors.New("A frozen config with that name already exists.")
+
+	// ErrFrozenNotFound is returned when an app doesn't have a frozen config
+	// with the given name.
+	ErrFrozenNotFound = errors.New("Frozen config could not be found.")
+)
+
+// ConfigsFreeze records the config under an immutable name for the app. If the
+// name is already used, ErrFrozenExists is returned.
+func (s *store) ConfigsFreeze(app *App, name string, config *Config) error {
+	return configsFreeze(s.db, app, name, config)
+}
+
+// ConfigsFrozen returns the config that was frozen under the name for the app.
+func (s *store) ConfigsFrozen(app *App, name string) (*Config, error) {
+	var config Config
+	if err := s.db.Where(`id = (select config_id from frozen_configs where app_id = ? and name = ?)`, app.ID, name).First(&config).Error; err != nil {
+		return &config, err
+	}
+
+	return &config, s.openConfig(&config)
+}
+
+// configsFreeze inserts the frozen config, unless one with the same name
+// already exists for the app.
+func configsFreeze(db *gorm.DB, app *App, name string, config *Config) error {
+	r := db.Exec(`insert into frozen_configs (app_id, name, config_id)
+		select ?, ?, ? where not exists (select 1 from frozen_configs where app_id = ? and name = ?)`,
+		app.ID, name, config.ID, app.ID, name)
+	if err := r.Error; err != nil {
+		return err
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrFrozenExists
+	}
+
+	return nil
+}
+
+// ConfigsFreeze records the id of the apps current config under name, so that
+// exactly that config can be found with ConfigsResolveFrozen later, like to
+// deploy the config from a specific release again. Unlike the current config,
+// which rolling back changes by creating a new config, a frozen config can
+// never be changed or moved to another config once it's created.
+func (s *configsService) ConfigsFreeze(app *App, name string) (string, error) {
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	c, err := s.current(app)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.store.ConfigsFreeze(app, name, c); err != nil {
+		return "", err
+	}
+
+	return c.ID, nil
+}
+
+// ConfigsResolveFrozen returns the config that was frozen under name for the
+// app, or ErrFrozenNotFound.
+func (s *configsService) ConfigsResolveFrozen(app *App, name string) (*Config, error) {
+	c, err := s.store.ConfigsFrozen(app, name)
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, ErrFrozenNotFound
+		}
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ConfigsTagAll tags the current config of every app with label, by freezing it
+// under label like ConfigsFreeze, so that every app can be rolled back to it
+// together with ConfigsRollbackAllToTag, like before a platform wide
+// migration. The ids of the tagged configs are returned, keyed by app name.
+// Apps without a config are skipped, rather than creating an empty config to
+// tag.
+//
+// Apps whose config is already tagged with label are left as is, so if an app
+// can't be tagged, like when it already has a different config frozen under
+// label, the apps that were already tagged stay tagged, they're returned along
+// with the error, and ConfigsTagAll can be run again once the problem is
+// fixed.
+func (s *configsService) ConfigsTagAll(label string) (map[string]string, error) {
+	apps, err := s.store.Apps(AppsQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := make(map[string]string)
+	for _, app := range apps {
+		id, err := s.tag(app, label)
+		if err != nil {
+			return tagged, err
+		}
+
+		if id != "" {
+			tagged[app.Name] = id
+		}
+	}
+
+	return tagged, nil
+}
+
+// tag freezes the apps current config under label, returning its id, or an
+// empty string if the app doesn't have a config. If the current config is
+// already frozen under label, its id is returned.
+func (s *configsService) tag(app *App, label string) (string, error) {
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	c, err := s.store.ConfigsCurrent(app)
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	err = s.store.ConfigsFreeze(app, label, c)
+	if err == nil {
+		return c.ID, nil
+	}
+
+	if err != ErrFrozenExists {
+		return "", err
+	}
+
+	frozen, err := s.store.ConfigsFrozen(app, label)
+	if err != nil {
+		return "", err
+	}
+
+	if frozen.ID != c.ID {
+		return "", ErrFrozenExists
+	}
+
+	return c.ID, nil
+}
+
+// ConfigsRollbackAllToTag replaces the config of every app that was tagged with
+// label by ConfigsTagAll with the vars from its tagged config, returning the
+// new configs keyed by app name. Apps without the tag, like ones that were
+// created after the apps were tagged, are left alone. Like ConfigsTagAll, if
+// an app can't be rolled back, the apps that were already rolled back are
+// returned along with the error.
+func (s *configsService) ConfigsRollbackAllToTag(ctx context.Context, label string) (map[string]*Config, error) {
+	apps, err := s.store.Apps(AppsQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*Config)
+	for _, app := range apps {
+		c, err := s.rollbackToTag(ctx, app, label)
+		if err == ErrFrozenNotFound {
+			continue
+		}
+
+		if err != nil {
+			return configs, err
+		}
+		configs[app.Name] = c
+	}
+
+	return configs, nil
+}
+
+// rollbackToTag replaces the apps config with the vars from the config that
+// was frozen under label.
+func (s *configsService) rollbackToTag(ctx context.Context, app *App, label string) (*Config, error) {
+	tagged, err := s.ConfigsResolveFrozen(app, label)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c, r, err := s.applyChange(ctx, app, configChange{
+			vars:     replaceVars(old.Vars, tagged.Vars),
+			restores: tagged,
+		})
+		return c, r, err
+	})
+}
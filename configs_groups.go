@@ -0,0 +1,370 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrConfigGroupExists is returned when creating a config group with a
+	// name that's already used.
+	ErrConfigGroupExists = errors.New("A config group with that name already exists.")
+
+	// ErrConfigGroupNotFound is returned when a config group with the given
+	// name doesn't exist.
+	ErrConfigGroupNotFound = errors.New("Config group could not be found.")
+
+	// ErrInheritanceCycle is used to indicate that a config group would
+	// inherit from itself through its parents.
+	ErrInheritanceCycle = errors.New("Config groups can't inherit from themselves.")
+)
+
+// InheritanceCycleError is returned when config groups inherit from each
+// other in a loop.
+type InheritanceCycleError struct {
+	// The names of the groups in the cycle, in order of inheritance,
+	// starting and ending with the same group.
+	Chain []string
+}
+
+func (e *InheritanceCycleError) Error() string {
+	return fmt.Sprintf("%v %s", ErrInheritanceCycle, strings.Join(e.Chain, " -> "))
+}
+
+// ConfigGroup is a named set of variables that's shared by every app attached
+// to it, like the credentials for a service that many apps use. Variables set
+// in an apps own config take precedence over those of its group.
+type ConfigGroup struct {
+	ID   string
+	Name string
+	Vars Vars
+
+	// The group that this group inherits vars from, if any. Vars set in
+	// this group take precedence over those of its parent.
+	ParentID *string
+}
+
+// ConfigGroupsCreate inserts the group, unless a group with the same name
+// already exists.
+func (s *store) ConfigGroupsCreate(group *ConfigGroup) (*ConfigGroup, error) {
+	rows, err := s.db.Raw(`insert into config_groups (name, vars)
+		select ?, ? where not exists (select 1 from config_groups where name = ?)
+		returning id`, group.Name, group.Vars, group.Name).Rows()
+	if err != nil {
+		return group, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return group, err
+		}
+		return group, ErrConfigGroupExists
+	}
+
+	return group, rows.Scan(&group.ID)
+}
+
+// ConfigGroupsFirst returns the group with the name.
+func (s *store) ConfigGroupsFirst(name string) (*ConfigGroup, error) {
+	var group ConfigGroup
+	if err := s.db.Where(`name = ?`, name).First(&group).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			err = ErrConfigGroupNotFound
+		}
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// ConfigGroupsFind returns the group with the id.
+func (s *store) ConfigGroupsFind(id string) (*ConfigGroup, error) {
+	var group ConfigGroup
+	if err := s.db.Where(`id = ?`, id).First(&group).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			err = ErrConfigGroupNotFound
+		}
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// ConfigGroupsSetParent sets the parent of the group, or removes it if parent
+// is nil.
+func (s *store) ConfigGroupsSetParent(group, parent *ConfigGroup) error {
+	var parentID *string
+	if parent != nil {
+		parentID = &parent.ID
+	}
+
+	return s.db.Exec(`update config_groups set parent_id = ? where id = ?`, parentID, group.ID).Error
+}
+
+// ConfigGroupsUpdate replaces the vars of the group. If notify is enabled, a
+// ConfigChange is sent for every app that uses the group, in the same
+// transaction.
+func (s *store) ConfigGroupsUpdate(group *ConfigGroup) error {
+	return configGroupsUpdate(s.db, group, s.notifyConfigChanges)
+}
+
+// ConfigGroupsAttach attaches the app to the group, replacing the group that
+// it was attached to, if any.
+func (s *store) ConfigGroupsAttach(app *App, group *ConfigGroup) error {
+	r := s.db.Exec(`update config_group_apps set group_id = ? where app_id = ?`, group.ID, app.ID)
+	if err := r.Error; err != nil {
+		return err
+	}
+
+	if r.RowsAffected > 0 {
+		return nil
+	}
+
+	return s.db.Exec(`insert into config_group_apps (app_id, group_id) values (?, ?)`, app.ID, group.ID).Error
+}
+
+// ConfigGroupsDetach detaches the app from its group.
+func (s *store) ConfigGroupsDetach(app *App) error {
+	return s.db.Exec(`delete from config_group_apps where app_id = ?`, app.ID).Error
+}
+
+// ConfigGroupsForApp returns the group that the app is attached to, or nil if
+// it isn't attached to one.
+func (s *store) ConfigGroupsForApp(app *App) (*ConfigGroup, error) {
+	var group ConfigGroup
+	if err := s.db.Where(`id = (select group_id from config_group_apps where app_id = ?)`, app.ID).First(&group).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			err = nil
+		}
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// AppsUsingGroup returns the sorted names of the apps that are attached to the
+// group, or to a group that inherits from it. An empty slice is returned if no
+// apps use it.
+func (s *store) AppsUsingGroup(groupID string) ([]string, error) {
+	return appsUsingGroup(s.db, groupID)
+}
+
+// groupAppsSQL selects the app_id of every app that's attached to a group, or
+// to a group that inherits from it. Using union, rather than union all, stops
+// the recursion if the groups inherit from each other in a loop.
+const groupAppsSQL = `with recursive descendants(id) as (
+	select ?::uuid
+	union
+	select g.id from config_groups g join descendants d on g.parent_id = d.id
+)
+select app_id from config_group_apps where group_id in (select id from descendants)`
+
+// appsUsingGroup selects the names of the apps that use the group.
+func appsUsingGroup(db *gorm.DB, groupID string) ([]string, error) {
+	rows, err := db.Raw(`select name from apps where id in (`+groupAppsSQL+`)`, groupID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, rows.Err()
+}
+
+// configGroupsUpdate updates the vars of the group within a transaction,
+// notifying every app that uses it, including through inheritance, if notify
+// is true. The notifications have
+// an empty ConfigID, since the apps configs haven't changed.
+func configGroupsUpdate(db *gorm.DB, group *ConfigGroup, notify bool) error {
+	t := db.Begin()
+
+	r := t.Exec(`update config_groups set vars = ? where id = ?`, group.Vars, group.ID)
+	if err := r.Error; err != nil {
+		t.Rollback()
+		return err
+	}
+
+	if r.RowsAffected == 0 {
+		t.Rollback()
+		return ErrConfigGroupNotFound
+	}
+
+	if notify {
+		if err := t.Exec(`select pg_notify(?, app_id::text || ':') from (`+groupAppsSQL+`) apps`, ConfigChangesChannel, group.ID).Error; err != nil {
+			t.Rollback()
+			return err
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// ConfigGroupsCreate creates a config group with the vars, which apps can be
+// attached to with ConfigsAttachGroup.
+func (s *configsService) ConfigGroupsCreate(name string, vars Vars) (*ConfigGroup, error) {
+	vars = normalizeVars(vars, s.normalizers)
+	if errs := validateForStorage(vars, s.validators); len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	return s.store.ConfigGroupsCreate(&ConfigGroup{Name: name, Vars: mergeVars(nil, vars)})
+}
+
+// ConfigGroupsSet merges vars into the vars of the group, where a nil value
+// unsets the variable. Every app that uses the group is sent a ConfigChange,
+// so that a change to the group, like rotating a shared secret, reaches all of
+// them.
+func (s *configsService) ConfigGroupsSet(name string, vars Vars) (*ConfigGroup, error) {
+	group, err := s.store.ConfigGroupsFirst(name)
+	if err != nil {
+		return nil, err
+	}
+
+	vars = normalizeVars(vars, s.normalizers)
+	if errs := validateForStorage(vars, s.validators); len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	group.Vars = mergeVars(group.Vars, vars)
+
+	return group, s.store.ConfigGroupsUpdate(group)
+}
+
+// ConfigsAttachGroup attaches the app to the group with the name, so that its
+// effective config includes the vars of the group.
+func (s *configsService) ConfigsAttachGroup(app *App, name string) error {
+	group, err := s.store.ConfigGroupsFirst(name)
+	if err != nil {
+		return err
+	}
+
+	return s.store.ConfigGroupsAttach(app, group)
+}
+
+// ConfigsDetachGroup detaches the app from its group, if it has one.
+func (s *configsService) ConfigsDetachGroup(app *App) error {
+	return s.store.ConfigGroupsDetach(app)
+}
+
+// AppsUsingGroup returns the sorted names of the apps that are attached to the
+// group, or to a group that inherits from it, which are the apps that a change
+// to the group affects.
+func (s *configsService) AppsUsingGroup(groupID string) ([]string, error) {
+	return s.store.AppsUsingGroup(groupID)
+}
+
+// ConfigGroupsSetParent makes the group inherit the vars of the parent group.
+// If parent is empty, the group no longer inherits from another group. If the
+// parent already inherits from the group, an InheritanceCycleError is
+// returned.
+func (s *configsService) ConfigGroupsSetParent(name, parent string) error {
+	group, err := s.store.ConfigGroupsFirst(name)
+	if err != nil {
+		return err
+	}
+
+	if parent == "" {
+		return s.store.ConfigGroupsSetParent(group, nil)
+	}
+
+	p, err := s.store.ConfigGroupsFirst(parent)
+	if err != nil {
+		return err
+	}
+
+	// Check the chain as it would be with the new parent.
+	child := *group
+	child.ParentID = &p.ID
+	if _, err := inheritedVars(&child, s.store.ConfigGroupsFind); err != nil {
+		return err
+	}
+
+	return s.store.ConfigGroupsSetParent(group, p)
+}
+
+// ConfigsEffective returns the vars of the apps current config, with the vars
+// that it inherits from its group added for any variables that the config
+// doesn't set.
+func (s *configsService) ConfigsEffective(app *App) (Vars, error) {
+	c, err := s.ConfigsCurrent(app)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := s.store.ConfigGroupsForApp(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaults Vars
+	if group != nil {
+		defaults, err = inheritedVars(group, s.store.ConfigGroupsFind)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.WithDefaults(defaults), nil
+}
+
+// inheritedVars returns the vars of the group merged over those of its
+// ancestors, which are found with find. If a group is reached twice, an
+// InheritanceCycleError is returned rather than following the chain forever.
+func inheritedVars(group *ConfigGroup, find func(id string) (*ConfigGroup, error)) (Vars, error) {
+	chain := []*ConfigGroup{group}
+	seen := map[string]bool{group.ID: true}
+
+	for g := group; g.ParentID != nil; {
+		id := *g.ParentID
+
+		if seen[id] {
+			var names []string
+			for i, c := range chain {
+				if c.ID == id {
+					for _, c := range chain[i:] {
+						names = append(names, c.Name)
+					}
+					names = append(names, c.Name)
+					break
+				}
+			}
+			return nil, &InheritanceCycleError{Chain: names}
+		}
+
+		p, err := find(id)
+		if err != nil {
+			return nil, err
+		}
+
+		seen[id] = true
+		chain = append(chain, p)
+		g = p
+	}
+
+	var vars Vars
+	for i := len(chain) - 1; i >= 0; i-- {
+		vars = mergeVars(vars, chain[i].Vars)
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,52 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInheritedVars(t *testing.T) {
+	groups := map[string]*ConfigGroup{
+		"1": {ID: "1", Name: "base", Vars: Vars{"A": strptr("base"), "B": strptr("base")}},
+		"2": {ID: "2", Name: "shared", Vars: Vars{"B": strptr("shared")}, ParentID: strptr("1")},
+	}
+
+	vars, err := inheritedVars(groups["2"], findConfigGroup(groups))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (Vars{"A": strptr("base"), "B": strptr("shared")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("inheritedVars => %v; want %v", got, want)
+	}
+}
+
+func TestInheritedVars_Cycle(t *testing.T) {
+	groups := map[string]*ConfigGroup{
+		"1": {ID: "1", Name: "a", ParentID: strptr("2")},
+		"2": {ID: "2", Name: "b", ParentID: strptr("3")},
+		"3": {ID: "3", Name: "c", ParentID: strptr("1")},
+	}
+
+	_, err := inheritedVars(groups["1"], findConfigGroup(groups))
+
+	expected := &InheritanceCycleError{Chain: []string{"a", "b", "c", "a"}}
+	if got, want := err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("err => %v; want %v", got, want)
+	}
+
+	if got, want := err.Error(), "Config groups can't inherit from themselves. a -> b -> c -> a"; got != want {
+		t.Fatalf("Error => %q; want %q", got, want)
+	}
+}
+
+// findConfigGroup returns a function that finds groups by id in groups.
+func findConfigGroup(groups map[string]*ConfigGroup) func(string) (*ConfigGroup, error) {
+	return func(id string) (*ConfigGroup, error) {
+		g, ok := groups[id]
+		if !ok {
+			return nil, ErrConfigGroupNotFound
+		}
+		return g, nil
+	}
+}
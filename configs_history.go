@@ -0,0 +1,123 @@
+package empire
+
+import "time"
+
+// ConfigsHistoryDiffsOpts represents options that can be passed when listing
+// the changes to an apps config.
+type ConfigsHistoryDiffsOpts struct {
+	// If greater than 0, only the limit most recent changes are returned.
+	Limit int
+
+	// If true, the values of secret variables are redacted, like with
+	// ConfigDiff.Redacted.
+	Redact bool
+}
+
+// VersionedDiff is the change made by a config, compared to the config that
+// came before it.
+type VersionedDiff struct {
+	ConfigID  string
+	CreatedAt *time.Time
+	Diff      ConfigDiff
+}
+
+// ConfigsHistoryDiffs returns the change made by each config in the apps
+// history, newest first. The configs are fetched in a single query, and the
+// first config the app ever had is compared to an empty config.
+func (s *configsService) ConfigsHistoryDiffs(app *App, opts ConfigsHistoryDiffsOpts) ([]VersionedDiff, error) {
+	q := ConfigsQuery{App: app}
+
+	// Each diff needs the config before it, so one more config than the
+	// number of diffs is needed.
+	if opts.Limit > 0 {
+		q.Limit = opts.Limit + 1
+	}
+
+	configs, err := s.store.Configs(q)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := versionedDiffs(configs, opts.Limit)
+
+	if opts.Redact {
+		for i := range diffs {
+			diffs[i].Diff = diffs[i].Diff.Redacted()
+		}
+	}
+
+	return diffs, nil
+}
+
+// versionedDiffs returns the diff of each config, ordered newest first, against
+// the next config in the list. If limit is greater than 0 and there are more
+// than limit configs, the last one is only used as a predecessor.
+func versionedDiffs(configs []*Config, limit int) []VersionedDiff {
+	n := len(configs)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	diffs := make([]VersionedDiff, 0, n)
+
+	for i, c := range configs[:n] {
+		prev := &Config{}
+		if i+1 < len(configs) {
+			prev = configs[i+1]
+		}
+
+		diffs = append(diffs, VersionedDiff{
+			ConfigID:  c.ID,
+			CreatedAt: c.CreatedAt,
+			Diff:      c.Diff(prev),
+		})
+	}
+
+	return diffs
+}
+
+// ConfigsHistoryForOpts represents options that can be passed when finding the
+// configs where a variable changed.
+type ConfigsHistoryForOpts struct {
+	// If greater than 0, only the limit most recent changes are returned.
+	Limit int
+}
+
+// ConfigsHistoryFor returns the configs in the apps history where the value of
+// the variable differs from the config before it, newest first, which are the
+// configs that set, changed or unset it. Unlike ConfigsHistoryDiffs, whole
+// configs are returned, so the rest of the config at each change is
+// available.
+func (s *configsService) ConfigsHistoryFor(app *App, name Variable, opts ConfigsHistoryForOpts) ([]*Config, error) {
+	configs, err := s.store.Configs(ConfigsQuery{App: app})
+	if err != nil {
+		return nil, err
+	}
+
+	return varChangePoints(configs, name, opts.Limit), nil
+}
+
+// varChangePoints returns the configs, ordered newest first, where the value
+// of the variable differs from the next config in the list. The last config is
+// compared to an empty config. If limit is greater than 0, at most limit
+// configs are returned.
+func varChangePoints(configs []*Config, name Variable, limit int) []*Config {
+	changes := []*Config{}
+
+	for i, c := range configs {
+		if limit > 0 && len(changes) >= limit {
+			break
+		}
+
+		var prev *string
+		if i+1 < len(configs) {
+			prev = configs[i+1].Vars[name]
+		}
+
+		if !varEqual(c.Vars[name], prev) {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,90 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionedDiffs(t *testing.T) {
+	configs := []*Config{
+		{ID: "3", Vars: Vars{"RAILS_ENV": strptr("production")}},
+		{ID: "2", Vars: Vars{"RAILS_ENV": strptr("staging"), "DEBUG": strptr("1")}},
+		{ID: "1", Vars: Vars{"RAILS_ENV": strptr("staging")}},
+	}
+
+	expected := []VersionedDiff{
+		{ConfigID: "3", Diff: ConfigDiff{
+			{Name: "DEBUG", Old: strptr("1")},
+			{Name: "RAILS_ENV", Old: strptr("staging"), New: strptr("production")},
+		}},
+		{ConfigID: "2", Diff: ConfigDiff{
+			{Name: "DEBUG", New: strptr("1")},
+		}},
+		{ConfigID: "1", Diff: ConfigDiff{
+			{Name: "RAILS_ENV", New: strptr("staging")},
+		}},
+	}
+
+	if got, want := versionedDiffs(configs, 0), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("versionedDiffs => %v; want %v", got, want)
+	}
+
+	// With a limit, the extra config is only used as the predecessor.
+	if got, want := versionedDiffs(configs, 2), expected[:2]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("versionedDiffs => %v; want %v", got, want)
+	}
+
+	if got := versionedDiffs(nil, 0); len(got) != 0 {
+		t.Fatalf("versionedDiffs => %v; want none", got)
+	}
+}
+
+func TestVarChangePoints(t *testing.T) {
+	configs := []*Config{
+		{ID: "6", Vars: Vars{"STRIPE_KEY": strptr("c"), "DEBUG": strptr("1")}},
+		{ID: "5", Vars: Vars{"STRIPE_KEY": strptr("c")}},
+		{ID: "4", Vars: Vars{}},
+		{ID: "3", Vars: Vars{"STRIPE_KEY": strptr("b")}},
+		{ID: "2", Vars: Vars{"STRIPE_KEY": strptr("a"), "DEBUG": strptr("1")}},
+		{ID: "1", Vars: Vars{"STRIPE_KEY": strptr("a")}},
+		{ID: "0", Vars: Vars{}},
+	}
+
+	ids := func(configs []*Config) []string {
+		ids := []string{}
+		for _, c := range configs {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name  Variable
+		limit int
+		ids   []string
+	}{
+		{"STRIPE_KEY", 0, []string{"5", "4", "3", "1"}},
+		{"STRIPE_KEY", 2, []string{"5", "4"}},
+		{"DEBUG", 0, []string{"6", "3", "2"}},
+		{"MISSING", 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		if got, want := ids(varChangePoints(configs, tt.name, tt.limit)), tt.ids; !reflect.DeepEqual(got, want) {
+			t.Errorf("varChangePoints(%s, %d) => %v; want %v", tt.name, tt.limit, got, want)
+		}
+	}
+}
+
+func TestVersionedDiffs_FlaggedSecret(t *testing.T) {
+	configs := []*Config{
+		{ID: "2", Vars: Vars{"STRIPE": strptr("sk_live")}, SecretFlags: SecretFlags{"STRIPE": SecretFlagSecret}},
+		{ID: "1", Vars: Vars{}},
+	}
+
+	diffs := versionedDiffs(configs, 0)
+
+	if got, want := *diffs[0].Diff.Redacted()[0].New, "***"; got != want {
+		t.Fatalf("New => %q; want %q", got, want)
+	}
+}
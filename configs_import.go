@@ -0,0 +1,317 @@
+package empire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrUnknownImportFormat is returned by ImportAuto when the format of
+	// the content can't be determined.
+	ErrUnknownImportFormat = errors.New("Unable to determine the format of the config vars. Expected .env, JSON or YAML.")
+
+	// ErrNotK8sSecret is returned by ImportK8sSecret when the manifest
+	// isn't a Kubernetes v1 Secret.
+	ErrNotK8sSecret = errors.New("Manifest is not a Kubernetes v1 Secret.")
+)
+
+// ImportFormat is a format that config vars can be imported from.
+type ImportFormat int
+
+const (
+	// FormatEnv is the .env format, with a KEY=value pair on each line.
+	FormatEnv ImportFormat = iota
+
+	// FormatJSON is a JSON object, mapping names to string values.
+	FormatJSON
+
+	// FormatYAML is a YAML mapping of names to scalar values.
+	FormatYAML
+)
+
+func (f ImportFormat) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatYAML:
+		return "yaml"
+	default:
+		return "env"
+	}
+}
+
+// ParseEnvFile parses variables from r in the .env format, where each line is
+// a KEY=value pair, optionally preceded by export. Blank lines and lines
+// starting with # are ignored, and values may optionally be wrapped in single
+// or double quotes.
+func ParseEnvFile(r io.Reader) (Vars, error) {
+	vars, errs := parseEnvFile(r)
+	if len(errs) > 0 {
+		return vars, errs[0]
+	}
+	return vars, nil
+}
+
+// ImportAndValidate parses variables from r in the .env format, then runs all
+// of the validators, and the checks that every stored var must pass, against
+// them. Unlike ParseEnvFile, it doesn't stop at the first problem; every parse
+// and validation error is returned, so they can be fixed in one pass. The vars
+// should only be applied if no errors are returned.
+func ImportAndValidate(r io.Reader, validators []Validator) (Vars, []error) {
+	vars, errs := parseEnvFile(r)
+	return vars, append(errs, validateForStorage(vars, validators)...)
+}
+
+// ParseExportScript parses variables from r, where each variable is set by a
+// shell export statement, like `export KEY=value`. Blank lines and comments are
+// ignored. If strict is true, an error is returned for the first line that
+// isn't an export statement. Otherwise, other shell commands are ignored.
+func ParseExportScript(r io.Reader, strict bool) (Vars, error) {
+	vars, errs := parseLines(r, strict, "expected export KEY=value", func(line string) (Variable, string, bool) {
+		stmt, ok := trimExport(line)
+		if !ok {
+			return "", "", false
+		}
+		return parseAssignment(stmt)
+	})
+	if len(errs) > 0 {
+		return vars, errs[0]
+	}
+	return vars, nil
+}
+
+// ParseJSON parses variables from r, which should contain a JSON object mapping
+// variable names to string values. A null value unsets the variable.
+func ParseJSON(r io.Reader) (Vars, error) {
+	var vars Vars
+	if err := json.NewDecoder(r).Decode(&vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// ParseYAML parses variables from r, which should contain a YAML mapping of
+// variable names to scalar values. Scalars, like numbers and booleans, are
+// imported as they're written, and a null value unsets the variable.
+func ParseYAML(r io.Reader) (Vars, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]*string
+	if err := yaml.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+
+	vars := make(Vars, len(m))
+	for k, v := range m {
+		vars[Variable(k)] = v
+	}
+	return vars, nil
+}
+
+// ImportAuto parses variables from r after detecting whether it's in the .env,
+// JSON or YAML format, returning the format that was detected. This is useful
+// for content that was pasted, rather than uploaded with a file extension. If
+// the format can't be determined, ErrUnknownImportFormat is returned.
+func ImportAuto(r io.Reader) (Vars, ImportFormat, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Editors on Windows often add a byte order mark, which would otherwise
+	// end up in the name of the first variable.
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+
+	f, ok := detectImportFormat(raw)
+	if !ok {
+		return nil, 0, ErrUnknownImportFormat
+	}
+
+	var vars Vars
+	switch f {
+	case FormatJSON:
+		vars, err = ParseJSON(bytes.NewReader(raw))
+	case FormatYAML:
+		vars, err = ParseYAML(bytes.NewReader(raw))
+	default:
+		vars, err = ParseEnvFile(bytes.NewReader(raw))
+	}
+
+	return vars, f, err
+}
+
+// yamlKeyPattern matches a line that starts a YAML mapping, like `key: value`.
+var yamlKeyPattern = regexp.MustCompile(`^[^\s:#=]+:(\s|$)`)
+
+// detectImportFormat sniffs the format of the content from its first line that
+// isn't blank or a comment. A leading { means JSON, a leading --- or key: means
+// YAML, and a KEY=value line, optionally preceded by export, means .env.
+func detectImportFormat(raw []byte) (ImportFormat, bool) {
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return FormatJSON, true
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "---") || yamlKeyPattern.MatchString(line) {
+			return FormatYAML, true
+		}
+
+		if stmt, ok := trimExport(line); ok {
+			line = stmt
+		}
+
+		if i := strings.Index(line, "="); i > 0 && !strings.ContainsAny(line[:i], ": \t") {
+			return FormatEnv, true
+		}
+
+		return 0, false
+	}
+
+	return 0, false
+}
+
+// parseEnvFile parses variables in the .env format from r, returning an error
+// for each line that couldn't be parsed. Lines may start with export, so that
+// the file can also be sourced by a shell.
+func parseEnvFile(r io.Reader) (Vars, []error) {
+	return parseLines(r, true, "expected KEY=value", func(line string) (Variable, string, bool) {
+		if stmt, ok := trimExport(line); ok {
+			line = stmt
+		}
+		return parseAssignment(line)
+	})
+}
+
+// parseLines calls parse with each line from r, other than blank lines and
+// comments, collecting the variables that it returns. If strict is true, an
+// error is returned for each line that parse doesn't accept.
+func parseLines(r io.Reader, strict bool, expected string, parse func(string) (Variable, string, bool)) (Vars, []error) {
+	var errs []error
+	vars := make(Vars)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := parse(line)
+		if !ok {
+			if strict {
+				errs = append(errs, fmt.Errorf("line %d: %s", n, expected))
+			}
+			continue
+		}
+
+		vars[name] = &value
+	}
+
+	if err := s.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return vars, errs
+}
+
+// trimExport removes the export keyword from the start of line, returning
+// false if the line doesn't start with it.
+func trimExport(line string) (string, bool) {
+	const keyword = "export"
+
+	if !strings.HasPrefix(line, keyword) {
+		return line, false
+	}
+
+	rest := line[len(keyword):]
+	if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return line, false
+	}
+
+	return strings.TrimSpace(rest), true
+}
+
+// parseAssignment parses a KEY=value assignment. Only the first = separates the
+// name from the value, so values can contain =.
+func parseAssignment(line string) (Variable, string, bool) {
+	i := strings.Index(line, "=")
+	if i < 1 {
+		return "", "", false
+	}
+
+	name := Variable(strings.TrimSpace(line[:i]))
+	value := unquote(strings.TrimSpace(line[i+1:]))
+	return name, value, true
+}
+
+// unquote removes matching single or double quotes surrounding s.
+func unquote(s string) string {
+	if len(s) >= 2 {
+		if q := s[0]; (q == '"' || q == '\'') && s[len(s)-1] == q {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// ImportK8sSecret parses variables from a Kubernetes v1 Secret manifest, like
+// one written by WriteK8sSecret, base64 decoding the values in data. Values in
+// stringData are used as is, and take precedence over data, like they do in
+// Kubernetes. Comparing the result with an apps config shows where the two
+// have drifted apart. Manifests of any other kind are rejected with
+// ErrNotK8sSecret, and a value that isn't valid base64 is reported with its
+// key.
+func ImportK8sSecret(r io.Reader) (Vars, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var m k8sManifest
+	if err := yaml.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+
+	if m.APIVersion != "v1" || m.Kind != "Secret" {
+		return nil, ErrNotK8sSecret
+	}
+
+	vars := make(Vars, len(m.Data)+len(m.StringData))
+	for k, v := range m.Data {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, &VarError{Name: Variable(k), Err: err}
+		}
+
+		value := string(b)
+		vars[Variable(k)] = &value
+	}
+
+	for k, v := range m.StringData {
+		value := v
+		vars[Variable(k)] = &value
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,207 @@
+package empire
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	in := `# Comment
+RAILS_ENV=production
+
+DATABASE_URL="postgres://localhost/db?sslmode=disable"
+GREETING='hello world'
+EMPTY=
+`
+
+	vars, err := ParseEnvFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		production = "production"
+		db         = "postgres://localhost/db?sslmode=disable"
+		greeting   = "hello world"
+		empty      = ""
+	)
+
+	expected := Vars{
+		"RAILS_ENV":    &production,
+		"DATABASE_URL": &db,
+		"GREETING":     &greeting,
+		"EMPTY":        &empty,
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseEnvFile => %v; want %v", got, want)
+	}
+}
+
+func TestImportAndValidate(t *testing.T) {
+	in := `RAILS_ENV=production
+not a var
+1FOO=bar
+FOO-BAR=baz
+`
+
+	_, errs := ImportAndValidate(strings.NewReader(in), DefaultValidators)
+
+	expected := []string{
+		"line 2: expected KEY=value",
+		"1FOO: " + ErrInvalidVarName.Error(),
+		"FOO-BAR: " + ErrInvalidVarName.Error(),
+	}
+
+	if got, want := len(errs), len(expected); got != want {
+		t.Fatalf("len(errs) => %d; want %d: %v", got, want, errs)
+	}
+
+	for i, err := range errs {
+		if got, want := err.Error(), expected[i]; got != want {
+			t.Errorf("#%d: err => %q; want %q", i, got, want)
+		}
+	}
+}
+
+func TestParseExportScript(t *testing.T) {
+	in := `#!/bin/sh
+set -e
+
+# Database
+export DATABASE_URL="postgres://localhost/db?sslmode=disable&a=b"
+export	RAILS_ENV=production
+echo "done"
+`
+
+	vars, err := ParseExportScript(strings.NewReader(in), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"DATABASE_URL": strptr("postgres://localhost/db?sslmode=disable&a=b"),
+		"RAILS_ENV":    strptr("production"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseExportScript => %v; want %v", got, want)
+	}
+
+	_, err = ParseExportScript(strings.NewReader(in), true)
+	if got, want := err.Error(), "line 2: expected export KEY=value"; got != want {
+		t.Fatalf("err => %q; want %q", got, want)
+	}
+}
+
+func TestParseEnvFile_Export(t *testing.T) {
+	vars, err := ParseEnvFile(strings.NewReader("export RAILS_ENV=production\nexported=true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"RAILS_ENV": strptr("production"),
+		"exported":  strptr("true"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseEnvFile => %v; want %v", got, want)
+	}
+}
+
+func TestImportAuto(t *testing.T) {
+	tests := []struct {
+		in     string
+		format ImportFormat
+	}{
+		{"# Comment\nexport RAILS_ENV=production\nGREETING='a: b'\n", FormatEnv},
+		{"\xef\xbb\xbfRAILS_ENV=production\nGREETING=a: b\n", FormatEnv},
+		{`  {"RAILS_ENV": "production", "GREETING": "a: b"}`, FormatJSON},
+		{"---\nRAILS_ENV: production\nGREETING: 'a: b'\n", FormatYAML},
+		{"# Comment\nRAILS_ENV: production\nGREETING: \"a: b\"\n", FormatYAML},
+	}
+
+	expected := Vars{
+		"RAILS_ENV": strptr("production"),
+		"GREETING":  strptr("a: b"),
+	}
+
+	for i, tt := range tests {
+		vars, format, err := ImportAuto(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("#%d: ImportAuto => %v", i, err)
+		}
+
+		if got, want := format, tt.format; got != want {
+			t.Errorf("#%d: format => %v; want %v", i, got, want)
+		}
+
+		if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: vars => %v; want %v", i, got.format(false), want.format(false))
+		}
+	}
+
+	for _, in := range []string{"", "# only a comment\n", "just some text\n", "[1, 2, 3]"} {
+		if _, _, err := ImportAuto(strings.NewReader(in)); err != ErrUnknownImportFormat {
+			t.Errorf("ImportAuto(%q) => %v; want %v", in, err, ErrUnknownImportFormat)
+		}
+	}
+}
+
+func TestImportK8sSecret(t *testing.T) {
+	in := `apiVersion: v1
+kind: Secret
+metadata:
+  name: acme-inc
+type: Opaque
+data:
+  API_KEY: c2VjcmV0
+  EMPTY: ""
+stringData:
+  RAILS_ENV: production
+`
+
+	vars, err := ImportK8sSecret(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"API_KEY":   strptr("secret"),
+		"EMPTY":     strptr(""),
+		"RAILS_ENV": strptr("production"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ImportK8sSecret => %v; want %v", got.format(false), want.format(false))
+	}
+}
+
+func TestImportK8sSecret_Invalid(t *testing.T) {
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: acme-inc
+data:
+  RAILS_ENV: production
+`
+
+	if _, err := ImportK8sSecret(strings.NewReader(configMap)); err != ErrNotK8sSecret {
+		t.Fatalf("err => %v; want %v", err, ErrNotK8sSecret)
+	}
+
+	malformed := `apiVersion: v1
+kind: Secret
+metadata:
+  name: acme-inc
+data:
+  API_KEY: not base64!
+`
+
+	_, err := ImportK8sSecret(strings.NewReader(malformed))
+	if err, ok := err.(*VarError); !ok || err.Name != "API_KEY" {
+		t.Fatalf("err => %v; want a *VarError for API_KEY", err)
+	}
+}
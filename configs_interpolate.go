@@ -0,0 +1,59 @@
+package empire
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// appPlaceholder matches references to an attribute of the app within a
+// value, like ${app.name}.
+var appPlaceholder = regexp.MustCompile(`\$\{app\.([a-z_]+)\}`)
+
+// appFields returns the attributes of the app that can be referenced from a
+// value.
+func appFields(app *App) map[string]string {
+	fields := map[string]string{
+		"id":       app.ID,
+		"name":     app.Name,
+		"exposure": app.Exposure,
+	}
+
+	if app.Repo != nil {
+		fields["repo"] = *app.Repo
+	}
+
+	return fields
+}
+
+// Interpolate returns the variables in the config with references to
+// attributes of the app, like ${app.name}, replaced with their values. The
+// stored config keeps the references. Unknown references are left as is,
+// unless strict is true, in which case an error is returned.
+func (c *Config) Interpolate(app *App, strict bool) (Vars, error) {
+	fields := appFields(app)
+	vars := make(Vars, len(c.Vars))
+
+	for _, n := range c.Vars.Keys() {
+		v := c.Vars[n]
+
+		var unknown string
+		expanded := appPlaceholder.ReplaceAllStringFunc(*v, func(ref string) string {
+			f, ok := fields[appPlaceholder.FindStringSubmatch(ref)[1]]
+			if !ok {
+				if unknown == "" {
+					unknown = ref
+				}
+				return ref
+			}
+			return f
+		})
+
+		if strict && unknown != "" {
+			return nil, &VarError{Name: n, Err: fmt.Errorf("unknown reference %s", unknown)}
+		}
+
+		vars[n] = &expanded
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,44 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_Interpolate(t *testing.T) {
+	app := &App{ID: "1234", Name: "acme-inc", Repo: strptr("remind101/acme-inc")}
+
+	c := &Config{
+		Vars: Vars{
+			"SERVICE_NAME": strptr("${app.name}"),
+			"STATSD_TAGS":  strptr("app:${app.name},repo:${app.repo}"),
+			"UNKNOWN":      strptr("${app.nope}"),
+			"RAILS_ENV":    strptr("production"),
+		},
+	}
+
+	vars, err := c.Interpolate(app, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"SERVICE_NAME": strptr("acme-inc"),
+		"STATSD_TAGS":  strptr("app:acme-inc,repo:remind101/acme-inc"),
+		"UNKNOWN":      strptr("${app.nope}"),
+		"RAILS_ENV":    strptr("production"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Interpolate => %v; want %v", Vars(got).format(false), Vars(want).format(false))
+	}
+
+	// The stored config keeps the template.
+	if got, want := *c.Vars["SERVICE_NAME"], "${app.name}"; got != want {
+		t.Fatalf("SERVICE_NAME => %v; want %v", got, want)
+	}
+
+	if _, err := c.Interpolate(app, true); err == nil {
+		t.Fatal("Expected an error interpolating an unknown reference")
+	}
+}
@@ -0,0 +1,155 @@
+package empire
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// The kinds of LineageEdge.
+const (
+	// LineageParent links a config to the config that it was created from.
+	LineageParent = "parent"
+
+	// LineageRestores links a config to the earlier config that it
+	// restored, like when a release or a tag is rolled back to, or a
+	// snapshot of the config is restored.
+	LineageRestores = "restores"
+)
+
+// LineageGraph is the history of an apps configs as a graph, for rendering
+// a visual history.
+type LineageGraph struct {
+	// Every config in the apps history, oldest first.
+	Nodes []LineageNode
+
+	Edges []LineageEdge
+}
+
+// LineageNode is a single config within a LineageGraph.
+type LineageNode struct {
+	ConfigID  string
+	CreatedAt *time.Time
+
+	// The sorted names that the config has been frozen under with
+	// ConfigsFreeze or ConfigsTagAll.
+	Tags []string
+
+	// The name of the user that created the config, if it was created by
+	// a user.
+	Actor string
+}
+
+// LineageEdge links two configs within a LineageGraph.
+type LineageEdge struct {
+	// The id of the newer config.
+	From string
+
+	// The id of the older config.
+	To string
+
+	// LineageParent or LineageRestores.
+	Kind string
+}
+
+// ConfigsLineageNodes returns every config for the app, oldest first, with
+// only the fields that are needed for its lineage. Vars aren't selected.
+func (s *store) ConfigsLineageNodes(app *App) ([]*Config, error) {
+	rows, err := s.db.Raw(`select id, created_at, parent_id, restores_id, actor from configs where app_id = ? order by created_at, seq`, app.ID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []*Config{}
+	for rows.Next() {
+		c := &Config{AppID: app.ID}
+		if err := rows.Scan(&c.ID, &c.CreatedAt, &c.ParentID, &c.RestoresID, &c.Actor); err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+
+	return configs, rows.Err()
+}
+
+// ConfigsFrozenNames returns the names of the apps frozen configs, keyed by the
+// id of the config that they were frozen with.
+func (s *store) ConfigsFrozenNames(app *App) (map[string][]string, error) {
+	rows, err := s.db.Raw(`select config_id, name from frozen_configs where app_id = ?`, app.ID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string][]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = append(names[id], name)
+	}
+
+	return names, rows.Err()
+}
+
+// ConfigsLineage returns the history of the apps configs as a graph, with an
+// edge from each config to the config that it was created from, and to the
+// config that it restored, if any. A rollback is a new config that restores an
+// older one, so "v7 is a rollback to v3" is an edge from v7 to v3.
+func (s *configsService) ConfigsLineage(app *App) (*LineageGraph, error) {
+	configs, err := s.store.ConfigsLineageNodes(app)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := s.store.ConfigsFrozenNames(app)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildLineage(configs, tags), nil
+}
+
+// buildLineage returns the graph for the configs, which must be ordered oldest
+// first, with the frozen names of each config keyed by config id.
+func buildLineage(configs []*Config, tags map[string][]string) *LineageGraph {
+	g := &LineageGraph{
+		Nodes: make([]LineageNode, 0, len(configs)),
+		Edges: []LineageEdge{},
+	}
+
+	for _, c := range configs {
+		names := append([]string{}, tags[c.ID]...)
+		sort.Strings(names)
+
+		g.Nodes = append(g.Nodes, LineageNode{
+			ConfigID:  c.ID,
+			CreatedAt: c.CreatedAt,
+			Tags:      names,
+			Actor:     c.Actor,
+		})
+
+		if c.ParentID != nil {
+			g.Edges = append(g.Edges, LineageEdge{From: c.ID, To: *c.ParentID, Kind: LineageParent})
+		}
+
+		if c.RestoresID != nil {
+			g.Edges = append(g.Edges, LineageEdge{From: c.ID, To: *c.RestoresID, Kind: LineageRestores})
+		}
+	}
+
+	return g
+}
+
+// actorFromContext returns the name of the user making the change, or an
+// empty string if it isn't being made by a user.
+func actorFromContext(ctx context.Context) string {
+	if u, ok := UserFromContext(ctx); ok && u != nil {
+		return u.Name
+	}
+
+	return ""
+}
@@ -0,0 +1,60 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestBuildLineage(t *testing.T) {
+	configs := []*Config{
+		{ID: "1"},
+		{ID: "2", ParentID: strptr("1"), Actor: "fake"},
+		{ID: "3", ParentID: strptr("2"), RestoresID: strptr("1")},
+		{ID: "4"},
+	}
+
+	tags := map[string][]string{
+		"1": {"v2", "v1"},
+	}
+
+	expected := &LineageGraph{
+		Nodes: []LineageNode{
+			{ConfigID: "1", Tags: []string{"v1", "v2"}},
+			{ConfigID: "2", Tags: []string{}, Actor: "fake"},
+			{ConfigID: "3", Tags: []string{}},
+			{ConfigID: "4", Tags: []string{}},
+		},
+		Edges: []LineageEdge{
+			{From: "2", To: "1", Kind: LineageParent},
+			{From: "3", To: "2", Kind: LineageParent},
+			{From: "3", To: "1", Kind: LineageRestores},
+		},
+	}
+
+	if got, want := buildLineage(configs, tags), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildLineage => %v; want %v", got, want)
+	}
+}
+
+func TestNewConfig_Parent(t *testing.T) {
+	if c := NewConfig(&Config{}, nil); c.ParentID != nil {
+		t.Fatalf("ParentID => %v; want nil", *c.ParentID)
+	}
+
+	if got, want := NewConfig(&Config{ID: "1"}, nil).ParentID, strptr("1"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParentID => %v; want %v", got, want)
+	}
+}
+
+func TestActorFromContext(t *testing.T) {
+	if got := actorFromContext(context.Background()); got != "" {
+		t.Fatalf("actorFromContext => %q; want empty", got)
+	}
+
+	ctx := WithUser(context.Background(), &User{Name: "fake"})
+	if got, want := actorFromContext(ctx), "fake"; got != want {
+		t.Fatalf("actorFromContext => %q; want %q", got, want)
+	}
+}
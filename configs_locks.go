@@ -0,0 +1,116 @@
+package empire
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+)
+
+// ConfigLock prevents any changes to an apps config while it exists. Locks are
+// persisted, so they survive restarts.
+type ConfigLock struct {
+	AppID  string
+	Reason string
+
+	CreatedAt *time.Time
+}
+
+// Set created_at before inserting.
+func (l *ConfigLock) BeforeCreate() error {
+	t := timex.Now()
+	l.CreatedAt = &t
+	return nil
+}
+
+// ConfigLockedError is returned when attempting to change the config of an app
+// that's locked.
+type ConfigLockedError struct {
+	Reason string
+}
+
+func (e *ConfigLockedError) Error() string {
+	return fmt.Sprintf("Config is locked: %s", e.Reason)
+}
+
+// ConfigLocksFind returns the lock for the app, or nil if it isn't locked.
+func (s *store) ConfigLocksFind(app *App) (*ConfigLock, error) {
+	return configLocksFind(s.db, app)
+}
+
+// ConfigLocksCreate locks the apps config, replacing any existing lock.
+func (s *store) ConfigLocksCreate(lock *ConfigLock) (*ConfigLock, error) {
+	return configLocksCreate(s.db, lock)
+}
+
+// ConfigLocksDestroy unlocks the apps config.
+func (s *store) ConfigLocksDestroy(app *App) error {
+	return configLocksDestroy(s.db, app)
+}
+
+func configLocksFind(db *gorm.DB, app *App) (*ConfigLock, error) {
+	var lock ConfigLock
+	if err := db.Where("app_id = ?", app.ID).First(&lock).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func configLocksCreate(db *gorm.DB, lock *ConfigLock) (*ConfigLock, error) {
+	t := db.Begin()
+
+	if err := configLocksDestroy(t, &App{ID: lock.AppID}); err != nil {
+		t.Rollback()
+		return lock, err
+	}
+
+	if err := t.Create(lock).Error; err != nil {
+		t.Rollback()
+		return lock, err
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return lock, err
+	}
+
+	return lock, nil
+}
+
+func configLocksDestroy(db *gorm.DB, app *App) error {
+	return db.Exec(`delete from config_locks where app_id = ?`, app.ID).Error
+}
+
+// ConfigsLock locks the apps config, so that it can't be changed until it's
+// unlocked. Reads are still allowed.
+func (s *configsService) ConfigsLock(app *App, reason string) error {
+	_, err := s.store.ConfigLocksCreate(&ConfigLock{
+		AppID:  app.ID,
+		Reason: reason,
+	})
+	return err
+}
+
+// ConfigsUnlock unlocks the apps config.
+func (s *configsService) ConfigsUnlock(app *App) error {
+	return s.store.ConfigLocksDestroy(app)
+}
+
+// checkLock returns a ConfigLockedError if the apps config is locked.
+func (s *configsService) checkLock(app *App) error {
+	lock, err := s.store.ConfigLocksFind(app)
+	if err != nil {
+		return err
+	}
+
+	if lock != nil {
+		return &ConfigLockedError{Reason: lock.Reason}
+	}
+
+	return nil
+}
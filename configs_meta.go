@@ -0,0 +1,132 @@
+package empire
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+)
+
+// ConfigMeta describes an apps current config without including any of the
+// values. It's meant for list views, like showing which variables an app has
+// and how big they are, which don't need the values. Building the environment
+// for a process needs the values, so it should use the full config instead.
+type ConfigMeta struct {
+	// The id of the config. Empty if the app doesn't have a config.
+	ID string
+
+	CreatedAt *time.Time
+
+	// The variables in the config, sorted by name.
+	Vars []VarMeta
+}
+
+// VarMeta describes a single variable within a config, without its value.
+type VarMeta struct {
+	Name Variable
+
+	// The size, in bytes, of the value.
+	Size int
+
+	// True if the variable is flagged as a secret in SecretFlags, or if it
+	// isn't flagged and its name matches SecretPattern. Since the value
+	// isn't available, DefaultSecretDetector isn't consulted, so a detector
+	// that inspects values may classify an unflagged variable differently.
+	Secret bool
+
+	// If set, the time that the variable expires.
+	ExpiresAt *time.Time
+}
+
+// ConfigsCurrentMeta returns metadata about the variables in the current config
+// for the app. Values are never selected, so they don't leave the database.
+// Expired variables are not included.
+func (s *store) ConfigsCurrentMeta(app *App) (*ConfigMeta, error) {
+	defer s.logSlow("ConfigsCurrentMeta", app.ID, time.Now())
+
+	return configsCurrentMeta(s.db, app, timex.Now())
+}
+
+// configsCurrentMetaSQL selects the current config for an app joined with the
+// name, value size and expiry of each variable.
+const configsCurrentMetaSQL = `select c.id, c.created_at, e.key, ` + valueSizeSQL + `, c.expires -> e.key, c.secret_flags -> e.key
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+left join lateral each(c.vars) e on true
+where a.id = ?`
+
+// configsCurrentMeta selects the ConfigMeta for the current config of the app.
+func configsCurrentMeta(db *gorm.DB, app *App, now time.Time) (*ConfigMeta, error) {
+	rows, err := db.Raw(configsCurrentMetaSQL, app.ID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meta := &ConfigMeta{Vars: []VarMeta{}}
+	for rows.Next() {
+		var (
+			name    *string
+			size    int
+			expires *string
+			flag    *string
+		)
+
+		if err := rows.Scan(&meta.ID, &meta.CreatedAt, &name, &size, &expires, &flag); err != nil {
+			return nil, err
+		}
+
+		// A config without any variables has a single row without a
+		// key.
+		if name == nil {
+			continue
+		}
+
+		v := VarMeta{
+			Name:   Variable(*name),
+			Size:   size,
+			Secret: varMetaSecret(Variable(*name), flag),
+		}
+
+		if expires != nil {
+			t, err := time.Parse(time.RFC3339Nano, *expires)
+			if err != nil {
+				return nil, err
+			}
+
+			if !t.After(now) {
+				continue
+			}
+			v.ExpiresAt = &t
+		}
+
+		meta.Vars = append(meta.Vars, v)
+	}
+
+	sort.Sort(varMetaByName(meta.Vars))
+
+	return meta, rows.Err()
+}
+
+// varMetaSecret returns true if the variable is a secret, based on its secret
+// flag, if it has one, or its name.
+func varMetaSecret(name Variable, flag *string) bool {
+	if flag != nil {
+		switch SecretFlag(*flag) {
+		case SecretFlagSecret, SecretFlagAuto:
+			return true
+		case SecretFlagPlain:
+			return false
+		}
+	}
+
+	return SecretPattern.MatchString(string(name))
+}
+
+// varMetaByName implements sort.Interface to sort VarMeta by name.
+type varMetaByName []VarMeta
+
+func (s varMetaByName) Len() int           { return len(s) }
+func (s varMetaByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s varMetaByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,317 @@
+package empire
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// ConfigChangesChannel is the postgres channel that config change
+// notifications are sent on.
+const ConfigChangesChannel = "empire_config_change"
+
+// How often to ping the database connection when no notifications have been
+// received, to detect connection loss.
+var listenerPingInterval = 90 * time.Second
+
+// ConfigChange is a notification that a new config was created for an app. When
+// the ConfigGroup that an app uses changes, the ConfigID is empty.
+//
+// Notifications sent while the listener was disconnected are lost, so after
+// reconnecting, a ConfigChange with an empty AppID and ConfigID is sent. When
+// receiving one of these, consumers should assume that every app may have
+// changed.
+type ConfigChange struct {
+	AppID    string
+	ConfigID string
+}
+
+// configsCreateWith inserts a Config in the database, then calls fn, if
+// provided, within the same transaction. If notify is true, a notification is
+// also sent on the ConfigChangesChannel, which is only delivered if the config
+// is committed.
+func configsCreateWith(db *gorm.DB, config *Config, notify bool, fn func(*gorm.DB) error) (*Config, error) {
+	t := db.Begin()
+
+	if err := t.Create(config).Error; err != nil {
+		t.Rollback()
+		return config, err
+	}
+
+	if notify {
+		if err := notifyConfigChange(t, config); err != nil {
+			t.Rollback()
+			return config, err
+		}
+	}
+
+	if fn != nil {
+		if err := fn(t); err != nil {
+			t.Rollback()
+			return config, err
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return config, err
+	}
+
+	return config, nil
+}
+
+// notifyConfigChange sends a notification on the ConfigChangesChannel for the
+// config. Notifications are only delivered if the transaction commits.
+func notifyConfigChange(db *gorm.DB, config *Config) error {
+	appID := config.AppID
+	if appID == "" && config.App != nil {
+		appID = config.App.ID
+	}
+
+	payload := fmt.Sprintf("%s:%s", appID, config.ID)
+	return db.Exec(`select pg_notify(?, ?)`, ConfigChangesChannel, payload).Error
+}
+
+// ConfigsListenChanges listens for config change notifications using a
+// dedicated connection to the database. The underlying connection is
+// re-established automatically if it's lost. The returned channel is closed
+// when the context is cancelled.
+func (s *store) ConfigsListenChanges(ctx context.Context) (<-chan ConfigChange, error) {
+	l := pq.NewListener(s.url, 10*time.Second, time.Minute, nil)
+
+	if err := l.Listen(ConfigChangesChannel); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	ch := make(chan ConfigChange)
+
+	go func() {
+		defer close(ch)
+		defer l.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-l.Notify:
+				if !ok {
+					return
+				}
+
+				// A nil notification is sent after the connection has been
+				// re-established.
+				var c ConfigChange
+				if n != nil {
+					c = parseConfigChange(n.Extra)
+				}
+
+				select {
+				case ch <- c:
+				case <-ctx.Done():
+					return
+				}
+			case <-time.After(listenerPingInterval):
+				// Errors are ignored, since the listener will
+				// reconnect on its own.
+				go l.Ping()
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// ConfigChangeEvent is sent by ConfigsFollow whenever an apps current config
+// changes.
+type ConfigChangeEvent struct {
+	// The id of the new current config.
+	ConfigID string
+
+	// The time that the new config was created.
+	CreatedAt *time.Time
+
+	// The changes from the previous current config, with the values of
+	// secret variables redacted.
+	Diff ConfigDiff
+}
+
+// Summary returns a human readable summary of the changes.
+func (e ConfigChangeEvent) Summary() string {
+	return e.Diff.Summary(true)
+}
+
+// ConfigsFollow returns a channel that receives a ConfigChangeEvent whenever
+// the apps current config changes. Config changes are learned about through
+// ConfigsListenChanges, so NotifyChanges needs to be enabled. After the
+// listener reconnects, the current config is checked again, so a change made
+// while disconnected is still sent, although intermediate changes may be
+// coalesced. The returned channel is closed when the context is cancelled.
+func (s *configsService) ConfigsFollow(ctx context.Context, app *App) (<-chan ConfigChangeEvent, error) {
+	head, err := s.followHead(app)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := s.store.ConfigsListenChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ConfigChangeEvent)
+
+	go func() {
+		defer close(ch)
+
+		for c := range changes {
+			if c.AppID != "" && c.AppID != app.ID {
+				continue
+			}
+
+			// Errors are ignored, since the current config will be
+			// checked again on the next change or reconnect.
+			next, err := s.followHead(app)
+			if err != nil || next.ID == head.ID {
+				continue
+			}
+
+			select {
+			case ch <- newConfigChangeEvent(head, next):
+			case <-ctx.Done():
+				return
+			}
+
+			head = next
+		}
+	}()
+
+	return ch, nil
+}
+
+// newConfigChangeEvent returns the event for the current config changing from
+// head to next.
+func newConfigChangeEvent(head, next *Config) ConfigChangeEvent {
+	return ConfigChangeEvent{
+		ConfigID:  next.ID,
+		CreatedAt: next.CreatedAt,
+		Diff:      next.Diff(head).Redacted(),
+	}
+}
+
+// followHead returns the current config for the app, or an empty config if the
+// app doesn't have one yet. Unlike current, a config is never created.
+func (s *configsService) followHead(app *App) (*Config, error) {
+	c, err := s.store.ConfigsCurrent(app)
+	if err == gorm.RecordNotFound {
+		return &Config{Vars: make(Vars)}, nil
+	}
+	return c, err
+}
+
+// DebounceConfigChanges coalesces changes for the same app that arrive within
+// window of each other, sending only the last change once no more changes have
+// arrived for the app within the window. So that an app that keeps changing
+// isn't held back forever, a pending change is sent no later than maxDelay
+// after the first change that it coalesced, even if changes are still
+// arriving. A maxDelay of 0 means there's no maximum. Changes without an app,
+// like the ones sent when reconnecting, are sent immediately. When in is
+// closed, any pending changes are sent before the returned channel is closed,
+// so the returned channel should be drained until it's closed.
+func DebounceConfigChanges(in <-chan ConfigChange, window, maxDelay time.Duration) <-chan ConfigChange {
+	out := make(chan ConfigChange)
+
+	go func() {
+		defer close(out)
+
+		pending := make(map[string]ConfigChange)
+		deadlines := make(map[string]time.Time)
+		latest := make(map[string]time.Time)
+
+		// A single timer is used for the earliest deadline, and reset
+		// whenever it changes.
+		timer := time.NewTimer(window)
+		armed := true
+		stop := func() {
+			if armed && !timer.Stop() {
+				<-timer.C
+			}
+			armed = false
+		}
+		defer stop()
+
+		for {
+			next := nextDeadline(deadlines)
+
+			stop()
+			if next != "" {
+				timer.Reset(deadlines[next].Sub(time.Now()))
+				armed = true
+			}
+
+			select {
+			case c, ok := <-in:
+				if !ok {
+					for next := nextDeadline(deadlines); next != ""; next = nextDeadline(deadlines) {
+						out <- pending[next]
+						delete(deadlines, next)
+					}
+					return
+				}
+
+				if c.AppID == "" {
+					out <- c
+					continue
+				}
+
+				now := time.Now()
+				if _, ok := pending[c.AppID]; !ok && maxDelay > 0 {
+					latest[c.AppID] = now.Add(maxDelay)
+				}
+
+				deadline := now.Add(window)
+				if l, ok := latest[c.AppID]; ok && l.Before(deadline) {
+					deadline = l
+				}
+
+				pending[c.AppID] = c
+				deadlines[c.AppID] = deadline
+			case <-timer.C:
+				armed = false
+
+				c := pending[next]
+				delete(pending, next)
+				delete(deadlines, next)
+				delete(latest, next)
+				out <- c
+			}
+		}
+	}()
+
+	return out
+}
+
+// nextDeadline returns the app with the earliest deadline, or an empty string
+// if there are no deadlines.
+func nextDeadline(deadlines map[string]time.Time) string {
+	var next string
+	for app, d := range deadlines {
+		if next == "" || d.Before(deadlines[next]) {
+			next = app
+		}
+	}
+	return next
+}
+
+// parseConfigChange parses the payload of a config change notification.
+func parseConfigChange(payload string) ConfigChange {
+	p := strings.SplitN(payload, ":", 2)
+	if len(p) != 2 {
+		return ConfigChange{}
+	}
+
+	return ConfigChange{AppID: p[0], ConfigID: p[1]}
+}
@@ -0,0 +1,118 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConfigChange(t *testing.T) {
+	tests := []struct {
+		in  string
+		out ConfigChange
+	}{
+		{"4321:1234", ConfigChange{AppID: "4321", ConfigID: "1234"}},
+		{"", ConfigChange{}},
+		{"4321", ConfigChange{}},
+	}
+
+	for _, tt := range tests {
+		if got, want := parseConfigChange(tt.in), tt.out; got != want {
+			t.Errorf("parseConfigChange(%q) => %v; want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestDebounceConfigChanges(t *testing.T) {
+	in := make(chan ConfigChange)
+	out := DebounceConfigChanges(in, 50*time.Millisecond, 0)
+
+	in <- ConfigChange{AppID: "a", ConfigID: "1"}
+	in <- ConfigChange{AppID: "b", ConfigID: "2"}
+	in <- ConfigChange{AppID: "a", ConfigID: "3"}
+	in <- ConfigChange{}
+
+	// Changes without an app are sent right away.
+	if got, want := <-out, (ConfigChange{}); got != want {
+		t.Fatalf("change => %v; want %v", got, want)
+	}
+
+	// b is due first, and a is coalesced to the last change.
+	var changes []ConfigChange
+	changes = append(changes, <-out, <-out)
+
+	expected := []ConfigChange{
+		{AppID: "b", ConfigID: "2"},
+		{AppID: "a", ConfigID: "3"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("changes => %v; want %v", changes, expected)
+	}
+
+	// Pending changes are flushed when the input is closed.
+	in <- ConfigChange{AppID: "a", ConfigID: "4"}
+	close(in)
+
+	if got, want := <-out, (ConfigChange{AppID: "a", ConfigID: "4"}); got != want {
+		t.Fatalf("change => %v; want %v", got, want)
+	}
+
+	if _, ok := <-out; ok {
+		t.Fatal("expected channel to be closed")
+	}
+}
+
+func TestDebounceConfigChanges_MaxDelay(t *testing.T) {
+	in := make(chan ConfigChange)
+	out := DebounceConfigChanges(in, time.Hour, 50*time.Millisecond)
+	defer close(in)
+
+	in <- ConfigChange{AppID: "a", ConfigID: "1"}
+	in <- ConfigChange{AppID: "a", ConfigID: "2"}
+
+	// The window would hold the change for an hour, but it's sent once
+	// the max delay has passed.
+	select {
+	case c := <-out:
+		if got, want := c, (ConfigChange{AppID: "a", ConfigID: "2"}); got != want {
+			t.Fatalf("change => %v; want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the change to be sent after the max delay")
+	}
+
+	// The max delay starts over with the next change.
+	start := time.Now()
+	in <- ConfigChange{AppID: "a", ConfigID: "3"}
+
+	select {
+	case c := <-out:
+		if got, want := c, (ConfigChange{AppID: "a", ConfigID: "3"}); got != want {
+			t.Fatalf("change => %v; want %v", got, want)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("change sent after %v; want at least 50ms", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the change to be sent after the max delay")
+	}
+}
+
+func TestNewConfigChangeEvent_FlaggedSecret(t *testing.T) {
+	head := &Config{Vars: Vars{"STRIPE": strptr("sk_test")}}
+	next := &Config{
+		ID:          "2",
+		Vars:        Vars{"STRIPE": strptr("sk_live")},
+		SecretFlags: SecretFlags{"STRIPE": SecretFlagAuto},
+	}
+
+	e := newConfigChangeEvent(head, next)
+
+	if got, want := e.Summary(), "~ STRIPE (changed)"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+
+	if got, want := *e.Diff[0].New, "***"; got != want {
+		t.Fatalf("New => %q; want %q", got, want)
+	}
+}
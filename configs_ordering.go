@@ -0,0 +1,56 @@
+package empire
+
+import "github.com/jinzhu/gorm"
+
+// ConfigsBackfillOrdering repairs configs without a created_at, like ones that
+// were imported from another system, returning the number of configs that
+// were repaired. Configs are ordered newest first with created_at desc, which
+// puts a null created_at first, so an app with one of these configs would have
+// it returned as its latest config, regardless of when it was inserted.
+//
+// Each config is given the created_at of the newest config inserted before it
+// for the same app, or, if there isn't one, the oldest config inserted after
+// it, according to seq. That leaves it with the same created_at as one of its
+// neighbours, and configs with identical timestamps, whether they were
+// repaired or imported that way, are ordered by seq everywhere that configs
+// are ordered, so this makes ordering follow insertion order. Running it
+// again has no further effect.
+//
+// Configs that existed before seq was added were numbered in the order that
+// they were stored in the table, which, for configs that were never updated,
+// is the order that they were inserted.
+func (s *store) ConfigsBackfillOrdering() (int, error) {
+	return configsBackfillOrdering(s.db)
+}
+
+// configsBackfillOrderingSQL sets the created_at of every config where it's
+// null, based on the configs that were inserted around it. Apps that have no
+// configs with a created_at get the current time.
+const configsBackfillOrderingSQL = `update configs c set created_at = r.created_at
+from (
+	select id, coalesce(
+		max(created_at) over (partition by app_id order by seq rows between unbounded preceding and current row),
+		min(created_at) over (partition by app_id order by seq rows between current row and unbounded following),
+		(now() at time zone 'utc')
+	) as created_at
+	from configs
+) r
+where c.id = r.id and c.created_at is null`
+
+// configsBackfillOrdering repairs the configs within a transaction.
+func configsBackfillOrdering(db *gorm.DB) (int, error) {
+	t := db.Begin()
+
+	r := t.Exec(configsBackfillOrderingSQL)
+	if err := r.Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	return int(r.RowsAffected), nil
+}
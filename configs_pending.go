@@ -0,0 +1,75 @@
+package empire
+
+import "time"
+
+// The kinds of PendingChange.
+const (
+	PendingScheduled = "scheduled"
+	PendingProposal  = "proposal"
+)
+
+// PendingChange is a change to an apps config that hasn't taken effect yet,
+// either because it's scheduled for later or it hasn't been approved.
+type PendingChange struct {
+	// Either PendingScheduled or PendingProposal.
+	Kind string
+
+	// The id of the scheduled change, or the proposal.
+	ID string
+
+	// The user that proposed the change. Only set for proposals.
+	Requester string
+
+	// When the change will take effect. Only set for scheduled changes.
+	EffectiveAt *time.Time
+
+	CreatedAt *time.Time
+
+	// A summary of the changes relative to the current config, with secret
+	// values redacted.
+	Summary string
+}
+
+// ConfigsPending returns the scheduled changes that haven't been applied yet,
+// followed by the proposals that haven't been approved or rejected.
+func (s *configsService) ConfigsPending(app *App) ([]PendingChange, error) {
+	current, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled, err := s.store.ConfigsScheduled(app)
+	if err != nil {
+		return nil, err
+	}
+
+	proposals, err := s.store.ConfigProposalsPending(app)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []PendingChange{}
+
+	for _, c := range scheduled {
+		effectiveAt := c.EffectiveAt
+		pending = append(pending, PendingChange{
+			Kind:        PendingScheduled,
+			ID:          c.ID,
+			EffectiveAt: &effectiveAt,
+			CreatedAt:   c.CreatedAt,
+			Summary:     NewConfig(current, Vars(c.Vars)).Diff(current).Summary(true),
+		})
+	}
+
+	for _, p := range proposals {
+		pending = append(pending, PendingChange{
+			Kind:      PendingProposal,
+			ID:        p.ID,
+			Requester: p.Requester,
+			CreatedAt: p.CreatedAt,
+			Summary:   NewConfig(current, Vars(p.Vars)).Diff(current).Summary(true),
+		})
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,232 @@
+package empire
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+	"golang.org/x/net/context"
+)
+
+// The states that a ConfigProposal can be in.
+const (
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+)
+
+var (
+	// ErrProposalNotFound is returned when a proposal doesn't exist.
+	ErrProposalNotFound = errors.New("Config proposal not found.")
+
+	// ErrProposalNotPending is returned when approving or rejecting a
+	// proposal that has already been approved or rejected.
+	ErrProposalNotPending = errors.New("Config proposal has already been approved or rejected.")
+
+	// ErrSelfApproval is returned when the requester of a proposal attempts
+	// to approve it.
+	ErrSelfApproval = errors.New("Config proposals must be approved by someone other than the requester.")
+)
+
+// ConfigProposal is a change to an apps config that has to be approved before
+// it's applied. Until then, it has no effect on the apps config.
+type ConfigProposal struct {
+	ID    string
+	AppID string
+
+	// The vars to apply. A nil value unsets the variable.
+	Vars VarsUpdate
+
+	// One of ProposalPending, ProposalApproved or ProposalRejected.
+	Status string
+
+	// The user that proposed the change.
+	Requester string
+
+	// The user that approved the change, once it's approved.
+	Approver string
+
+	// The reason the change was rejected, once it's rejected.
+	Reason string
+
+	// The config that was created when the change was approved.
+	ConfigID *string
+
+	CreatedAt *time.Time
+	DecidedAt *time.Time
+}
+
+// Set created_at before inserting.
+func (p *ConfigProposal) BeforeCreate() error {
+	t := timex.Now()
+	p.CreatedAt = &t
+	return nil
+}
+
+// ConfigProposalsFind returns the proposal with the given id.
+func (s *store) ConfigProposalsFind(id string) (*ConfigProposal, error) {
+	p, err := configProposalsFind(s.db, id)
+	if err != nil {
+		return p, err
+	}
+	return p, s.decryptProposals(p)
+}
+
+// ConfigProposalsCreate persists the proposal.
+func (s *store) ConfigProposalsCreate(p *ConfigProposal) (*ConfigProposal, error) {
+	restore, err := s.sealVars(p.AppID, (*Vars)(&p.Vars))
+	defer restore()
+	if err != nil {
+		return p, err
+	}
+
+	return p, s.db.Create(p).Error
+}
+
+// ConfigProposalsUpdate updates the proposal.
+func (s *store) ConfigProposalsUpdate(p *ConfigProposal) error {
+	restore, err := s.sealVars(p.AppID, (*Vars)(&p.Vars))
+	defer restore()
+	if err != nil {
+		return err
+	}
+
+	return configProposalsUpdate(s.db, p)
+}
+
+// ConfigProposalsPending returns the pending proposals for the app, oldest
+// first.
+func (s *store) ConfigProposalsPending(app *App) ([]*ConfigProposal, error) {
+	var proposals []*ConfigProposal
+	if err := s.db.Where("app_id = ? and status = ?", app.ID, ProposalPending).Order("created_at").Find(&proposals).Error; err != nil {
+		return proposals, err
+	}
+	return proposals, s.decryptProposals(proposals...)
+}
+
+// decryptProposals decrypts the vars of proposals that were read from the
+// database.
+func (s *store) decryptProposals(proposals ...*ConfigProposal) error {
+	for _, p := range proposals {
+		vars, err := s.decryptVars(p.AppID, Vars(p.Vars))
+		if err != nil {
+			return err
+		}
+		p.Vars = VarsUpdate(vars)
+	}
+
+	return nil
+}
+
+func configProposalsUpdate(db *gorm.DB, p *ConfigProposal) error {
+	return db.Save(p).Error
+}
+
+func configProposalsFind(db *gorm.DB, id string) (*ConfigProposal, error) {
+	var p ConfigProposal
+	if err := db.Where("id = ?", id).First(&p).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, ErrProposalNotFound
+		}
+
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ConfigsPropose stores vars as a pending change to the apps config, which is
+// only applied once it's approved with ConfigsApprove. The vars are validated
+// now, so that invalid changes can't be proposed, and again when they're
+// applied.
+func (s *configsService) ConfigsPropose(app *App, vars Vars, requester string) (*ConfigProposal, error) {
+	if errs := validateForStorage(vars, s.validators); len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	return s.store.ConfigProposalsCreate(&ConfigProposal{
+		AppID:     app.ID,
+		Vars:      VarsUpdate(vars),
+		Status:    ProposalPending,
+		Requester: requester,
+	})
+}
+
+// ConfigsApprove applies a pending proposal to the current config for its app,
+// returning the new config. The approver must be someone other than the
+// requester.
+func (s *configsService) ConfigsApprove(ctx context.Context, id, approver string) (*Config, error) {
+	p, err := s.store.ConfigProposalsFind(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Requester == approver {
+		return nil, ErrSelfApproval
+	}
+
+	app, err := s.store.AppsFirst(AppsQuery{ID: &p.AppID})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		// Reload the proposal now that the app is locked, in case it
+		// was approved or rejected in the meantime.
+		p, err := s.store.ConfigProposalsFind(id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if p.Status != ProposalPending {
+			return nil, nil, ErrProposalNotPending
+		}
+
+		// The proposal is marked as approved in the same transaction
+		// that creates the config, so that it can't be applied without
+		// being approved, or approved without being applied.
+		return s.applyChange(ctx, app, configChange{
+			vars: Vars(p.Vars),
+			then: func(c *Config, tx configsStore) error {
+				t := timex.Now()
+				p.Status = ProposalApproved
+				p.Approver = approver
+				p.ConfigID = &c.ID
+				p.DecidedAt = &t
+
+				return tx.ConfigProposalsUpdate(p)
+			},
+		})
+	})
+}
+
+// ConfigsReject rejects a pending proposal, so that it can't be approved.
+func (s *configsService) ConfigsReject(id, reason string) error {
+	p, err := s.store.ConfigProposalsFind(id)
+	if err != nil {
+		return err
+	}
+
+	// Lock the app so that the proposal can't be approved at the same time.
+	unlock, err := s.store.AppsLock(&App{ID: p.AppID})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p, err = s.store.ConfigProposalsFind(id)
+	if err != nil {
+		return err
+	}
+
+	if p.Status != ProposalPending {
+		return ErrProposalNotPending
+	}
+
+	t := timex.Now()
+	p.Status = ProposalRejected
+	p.Reason = reason
+	p.DecidedAt = &t
+
+	return s.store.ConfigProposalsUpdate(p)
+}
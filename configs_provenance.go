@@ -0,0 +1,128 @@
+package empire
+
+import "time"
+
+// EnvSource describes where the value of a variable in the environment of a
+// process came from.
+type EnvSource int
+
+const (
+	// EnvSourceConfig means the value was set in the apps config.
+	EnvSourceConfig EnvSource = iota
+
+	// EnvSourceDefault means the value is a platform default that the app
+	// doesn't override.
+	EnvSourceDefault
+
+	// EnvSourceExtra means the value was provided for the process, which
+	// takes precedence over the config.
+	EnvSourceExtra
+
+	// EnvSourceResolved means the config referenced an external secret,
+	// and the value was resolved from it.
+	EnvSourceResolved
+)
+
+func (s EnvSource) String() string {
+	switch s {
+	case EnvSourceDefault:
+		return "default"
+	case EnvSourceExtra:
+		return "extra"
+	case EnvSourceResolved:
+		return "resolved"
+	default:
+		return "config"
+	}
+}
+
+// EnvEntry is the value of a variable in the environment of a process, and
+// where it came from.
+type EnvEntry struct {
+	Value  string
+	Source EnvSource
+}
+
+// ConfigsEffectiveEnvWithProvenance is like ConfigsEffectiveEnv, but returns
+// where each value came from. If redact is true, the values of variables that
+// the config treats as secrets, and of defaults and extras that look like
+// secrets, are masked.
+func (s *configsService) ConfigsEffectiveEnvWithProvenance(id string, defaults, extra Vars, redact bool) (map[Variable]EnvEntry, error) {
+	app, c, at, err := s.findReleased(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return envProvenance(s.env, app, c, at, defaults, extra, redact)
+}
+
+// envProvenance builds the environment for a process for the app using the
+// config, with b, and records where each value came from.
+func envProvenance(b *envBuilder, app *App, c *Config, now time.Time, defaults, extra Vars, redact bool) (map[Variable]EnvEntry, error) {
+	vars, err := b.varsAt(app, c, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map the names in the environment back to the variables in the config
+	// that they came from, since they may have been transformed.
+	c = c.Unexpired(now)
+	from := make(map[Variable]Variable, len(c.Vars))
+	for n := range c.Vars {
+		name := n
+		if b.transformKeys != nil {
+			name = b.transformKeys(n)
+		}
+		from[name] = n
+	}
+
+	env := make(map[Variable]EnvEntry)
+	secret := make(map[Variable]bool)
+
+	for n, v := range defaults {
+		if v != nil {
+			env[n] = EnvEntry{Value: *v, Source: EnvSourceDefault}
+		}
+	}
+
+	for n, v := range vars {
+		k, ok := from[n]
+		if !ok {
+			env[n] = EnvEntry{Value: *v, Source: EnvSourceDefault}
+			continue
+		}
+
+		source := EnvSourceConfig
+		if raw := *c.Vars[k]; b.resolvers.resolver(raw) != nil || appRefPattern.MatchString(raw) {
+			source = EnvSourceResolved
+		}
+		env[n] = EnvEntry{Value: *v, Source: source}
+		secret[n] = c.IsSecret(k)
+	}
+
+	for n, v := range extra {
+		if v == nil {
+			delete(env, n)
+			continue
+		}
+		env[n] = EnvEntry{Value: *v, Source: EnvSourceExtra}
+	}
+
+	if redact {
+		for n, e := range env {
+			// Values from the config are classified by the config, so
+			// that secret flags are respected.
+			isSecret := secret[n]
+			if e.Source == EnvSourceDefault || e.Source == EnvSourceExtra {
+				isSecret = isSecret || DefaultSecretDetector(n, e.Value)
+			}
+
+			if isSecret {
+				e.Value = "***"
+				env[n] = e
+			}
+		}
+	}
+
+	return env, nil
+}
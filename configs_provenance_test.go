@@ -0,0 +1,90 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvProvenance(t *testing.T) {
+	resolvers := SecretResolvers{
+		"vault": SecretResolverFunc(func(ref string) (string, error) {
+			return "s3cr3t", nil
+		}),
+	}
+
+	b := &envBuilder{
+		resolvers: resolvers,
+		defaults:  Vars{"REGION": strptr("us-east-1")},
+	}
+
+	c := &Config{
+		Vars: Vars{
+			"API_KEY":      strptr("vault://secret/app#API_KEY"),
+			"RAILS_ENV":    strptr("production"),
+			"PORT":         strptr("80"),
+			"PUBLIC_KEY":   strptr("ssh-rsa AAAA"),
+			"DEPLOY":       strptr("hunter2"),
+			"STATSD_HOST":  strptr("${app.name}.statsd"),
+			"EXPIRED_FLAG": strptr("on"),
+		},
+		SecretFlags: SecretFlags{
+			"PUBLIC_KEY": SecretFlagPlain,
+			"DEPLOY":     SecretFlagSecret,
+		},
+		Expires: Expirations{
+			"EXPIRED_FLAG": time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	app := &App{Name: "acme-inc"}
+	now := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	defaults := Vars{
+		"PORT":     strptr("8080"),
+		"LOG_JSON": strptr("true"),
+	}
+	extra := Vars{
+		"RAILS_ENV": strptr("test"),
+		"LOG_JSON":  nil,
+	}
+
+	tests := []struct {
+		redact bool
+		out    map[Variable]EnvEntry
+	}{
+		{false, map[Variable]EnvEntry{
+			"API_KEY":     {Value: "s3cr3t", Source: EnvSourceResolved},
+			"RAILS_ENV":   {Value: "test", Source: EnvSourceExtra},
+			"PORT":        {Value: "80", Source: EnvSourceConfig},
+			"PUBLIC_KEY":  {Value: "ssh-rsa AAAA", Source: EnvSourceConfig},
+			"DEPLOY":      {Value: "hunter2", Source: EnvSourceConfig},
+			"STATSD_HOST": {Value: "acme-inc.statsd", Source: EnvSourceConfig},
+			"REGION":      {Value: "us-east-1", Source: EnvSourceDefault},
+		}},
+		{true, map[Variable]EnvEntry{
+			"API_KEY":     {Value: "***", Source: EnvSourceResolved},
+			"RAILS_ENV":   {Value: "test", Source: EnvSourceExtra},
+			"PORT":        {Value: "80", Source: EnvSourceConfig},
+			"PUBLIC_KEY":  {Value: "ssh-rsa AAAA", Source: EnvSourceConfig},
+			"DEPLOY":      {Value: "***", Source: EnvSourceConfig},
+			"STATSD_HOST": {Value: "acme-inc.statsd", Source: EnvSourceConfig},
+			"REGION":      {Value: "us-east-1", Source: EnvSourceDefault},
+		}},
+	}
+
+	for _, tt := range tests {
+		env, err := envProvenance(b, app, c, now, defaults, extra, tt.redact)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := env, tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("envProvenance(%v) => %v; want %v", tt.redact, got, want)
+		}
+	}
+
+	env, _ := envProvenance(&envBuilder{}, app, &Config{Vars: Vars{}}, now, defaults, nil, false)
+	if got, want := env["PORT"], (EnvEntry{Value: "8080", Source: EnvSourceDefault}); got != want {
+		t.Errorf("PORT => %v; want %v", got, want)
+	}
+}
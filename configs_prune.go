@@ -0,0 +1,110 @@
+package empire
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidRetention is returned when pruning configs without keeping at
+// least one config per app.
+var ErrInvalidRetention = errors.New("At least one config must be kept for each app.")
+
+// DefaultPruneBatchSize is the number of apps that are pruned by each statement
+// in ConfigsPruneAll, unless ConfigsOptions.PruneBatchSize is set.
+const DefaultPruneBatchSize = 100
+
+// ConfigsPruneAll deletes all but the keep newest configs for every app,
+// returning the number of configs that were deleted for each app, keyed by app
+// name. Apps are pruned in batches, each in its own statement, so locks are
+// only held on a few apps at a time. The current config, and configs referenced
+// by a release, a proposal, a scheduled change or a frozen config are never
+// deleted.
+//
+// If an error occurs, the batches that were already pruned stay pruned, and
+// the counts for them are returned along with the error.
+func (s *store) ConfigsPruneAll(keep int) (map[string]int, error) {
+	if keep < 1 {
+		return nil, ErrInvalidRetention
+	}
+
+	batch := s.pruneBatchSize
+	if batch <= 0 {
+		batch = DefaultPruneBatchSize
+	}
+
+	return configsPruneAll(s.db, keep, batch)
+}
+
+// configsPruneSQL deletes all but the newest configs for a batch of apps,
+// returning the number deleted for each app.
+const configsPruneSQL = `with ranked as (
+	select c.id, c.app_id, row_number() over (partition by c.app_id order by c.created_at desc, c.seq desc) as n
+	from configs c
+	where c.app_id in (?)
+), deleted as (
+	delete from configs c
+	using ranked r, apps a
+	where c.id = r.id
+		and a.id = r.app_id
+		and r.n > ?
+		and c.id <> ` + currentConfigSQL + `
+		and not exists (select 1 from releases rl where rl.config_id = c.id)
+		and not exists (select 1 from config_proposals p where p.config_id = c.id)
+		and not exists (select 1 from scheduled_configs s where s.config_id = c.id)
+		and not exists (select 1 from frozen_configs f where f.config_id = c.id)
+	returning c.app_id
+)
+select a.name, count(*) from deleted d join apps a on a.id = d.app_id group by a.name`
+
+// configsPruneAll prunes the configs for every app, batch apps at a time.
+func configsPruneAll(db *gorm.DB, keep, batch int) (map[string]int, error) {
+	deleted := make(map[string]int)
+
+	var after string
+	for {
+		q := db.Table("apps").Order("id").Limit(batch)
+		if after != "" {
+			q = q.Where("id > ?", after)
+		}
+
+		var ids []string
+		if err := q.Pluck("id", &ids).Error; err != nil {
+			return deleted, err
+		}
+
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+
+		if err := configsPrune(db, ids, keep, deleted); err != nil {
+			return deleted, err
+		}
+
+		after = ids[len(ids)-1]
+	}
+}
+
+// configsPrune prunes the configs for the apps, adding the number deleted for
+// each app to deleted.
+func configsPrune(db *gorm.DB, ids []string, keep int, deleted map[string]int) error {
+	rows, err := db.Raw(configsPruneSQL, ids, keep).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			app string
+			n   int
+		)
+
+		if err := rows.Scan(&app, &n); err != nil {
+			return err
+		}
+		deleted[app] = n
+	}
+
+	return rows.Err()
+}
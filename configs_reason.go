@@ -0,0 +1,34 @@
+package empire
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ErrReasonRequired is returned when a reason is required for config changes,
+// but the change was made without one.
+var ErrReasonRequired = errors.New("A reason is required to change config vars.")
+
+// WithReason adds the reason for a config change to the context.Context.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, ReasonKey, reason)
+}
+
+// ReasonFromContext returns the reason for a config change from the
+// context.Context, or an empty string if there isn't one.
+func ReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(ReasonKey).(string)
+	return reason
+}
+
+// checkReason returns ErrReasonRequired if reasons are required and ctx doesn't
+// have one. A reason of only whitespace doesn't count.
+func (s *configsService) checkReason(ctx context.Context) error {
+	if s.requireReason && strings.TrimSpace(ReasonFromContext(ctx)) == "" {
+		return ErrReasonRequired
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package empire
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestConfigsService_CheckReason(t *testing.T) {
+	s := &configsService{requireReason: true}
+
+	tests := []struct {
+		ctx context.Context
+		err error
+	}{
+		{context.Background(), ErrReasonRequired},
+		{WithReason(context.Background(), " "), ErrReasonRequired},
+		{WithReason(context.Background(), "system:rotation"), nil},
+	}
+
+	for i, tt := range tests {
+		if got, want := s.checkReason(tt.ctx), tt.err; got != want {
+			t.Errorf("#%d: checkReason => %v; want %v", i, got, want)
+		}
+	}
+
+	// Reasons aren't required by default.
+	if err := (&configsService{}).checkReason(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
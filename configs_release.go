@@ -0,0 +1,43 @@
+package empire
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrReleaseNotFound is returned when a release with the given id doesn't
+// exist.
+var ErrReleaseNotFound = errors.New("Release could not be found.")
+
+// ReleasesConfigID returns the id of the config that the release was created
+// with, or gorm.RecordNotFound if the release doesn't exist.
+func (s *store) ReleasesConfigID(releaseID string) (string, error) {
+	var ids []string
+	if err := s.db.Table("releases").Where("id = ?", releaseID).Pluck("config_id", &ids).Error; err != nil {
+		return "", err
+	}
+
+	if len(ids) == 0 {
+		return "", gorm.RecordNotFound
+	}
+
+	return ids[0], nil
+}
+
+// ConfigsFindForRelease returns the config that the release with the given id
+// was created with, which is the environment its processes ran with. If the
+// release doesn't exist, ErrReleaseNotFound is returned, and if the release
+// exists but its config doesn't, like when it's been pruned, ErrConfigNotFound
+// is returned.
+func (s *configsService) ConfigsFindForRelease(releaseID string) (*Config, error) {
+	id, err := s.store.ReleasesConfigID(releaseID)
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, err
+	}
+
+	return s.ConfigsFind(id)
+}
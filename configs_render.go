@@ -0,0 +1,108 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Renderer checks that a variable can be represented in one of the formats
+// that configs are exported to, like a docker --env-file.
+type Renderer struct {
+	// The name of the format, like "docker", which is included in errors.
+	Name string
+
+	// Returns an error if the variable can't be represented.
+	Check func(name Variable, value string) error
+}
+
+// RenderError is returned when a variable can't be represented by a Renderer.
+type RenderError struct {
+	// The name of the Renderer.
+	Renderer string
+
+	Err error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("can't be rendered for %s: %s", e.Renderer, e.Err)
+}
+
+var (
+	// ErrRenderNewline is returned by renderers for formats that can't
+	// represent values with line breaks.
+	ErrRenderNewline = errors.New("values can't contain line breaks")
+
+	// ErrRenderName is returned by renderers for formats that don't allow
+	// the variable name.
+	ErrRenderName = errors.New("variable name isn't allowed")
+)
+
+// RenderDocker checks that a variable can be written to a docker --env-file,
+// which has one KEY=value per line and no quoting, so values can't contain
+// line breaks.
+var RenderDocker = Renderer{
+	Name: "docker",
+	Check: func(name Variable, value string) error {
+		if strings.ContainsAny(value, "\n\r") {
+			return ErrRenderNewline
+		}
+
+		return nil
+	},
+}
+
+// k8sEnvNamePattern matches the names that Kubernetes allows for container
+// environment variables.
+var k8sEnvNamePattern = regexp.MustCompile(`^[-._a-zA-Z][-._a-zA-Z0-9]*$`)
+
+// RenderK8s checks that a variable can be used as an environment variable in a
+// Kubernetes container, like when it's exported with WriteK8sSecret or
+// WriteK8sConfigMap.
+var RenderK8s = Renderer{
+	Name: "k8s",
+	Check: func(name Variable, value string) error {
+		if !k8sEnvNamePattern.MatchString(string(name)) {
+			return ErrRenderName
+		}
+
+		return nil
+	},
+}
+
+// RenderTFVars checks that a variable can be written by WriteTFVars, which
+// skips variables whose names aren't valid HCL identifiers.
+var RenderTFVars = Renderer{
+	Name: "tfvars",
+	Check: func(name Variable, value string) error {
+		if !HCLIdentifierPattern.MatchString(string(name)) {
+			return ErrRenderName
+		}
+
+		return nil
+	},
+}
+
+// checkRender returns an error for each variable set by vars that can't be
+// represented by one of the renderers, ordered by variable name. Only the
+// variables that are being set are checked, so that a variable that was stored
+// before a renderer was added doesn't block unrelated changes.
+func checkRender(vars Vars, renderers []Renderer) []error {
+	var errs []error
+
+	for _, n := range vars.Keys() {
+		v := vars[n]
+		if v == nil {
+			continue
+		}
+
+		for _, r := range renderers {
+			if err := r.Check(n, *v); err != nil {
+				errs = append(errs, &VarError{Name: n, Err: &RenderError{Renderer: r.Name, Err: err}})
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,33 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckRender(t *testing.T) {
+	vars := Vars{
+		"CERT":     strptr("-----BEGIN CERTIFICATE-----\nMIIC..."),
+		"app.name": strptr("acme"),
+		"OK":       strptr("fine"),
+		"UNSET":    nil,
+	}
+
+	expected := []error{
+		&VarError{Name: "CERT", Err: &RenderError{Renderer: "docker", Err: ErrRenderNewline}},
+		&VarError{Name: "app.name", Err: &RenderError{Renderer: "tfvars", Err: ErrRenderName}},
+	}
+
+	errs := checkRender(vars, []Renderer{RenderDocker, RenderK8s, RenderTFVars})
+	if got, want := errs, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkRender => %v; want %v", got, want)
+	}
+
+	if got, want := errs[0].Error(), "CERT: can't be rendered for docker: values can't contain line breaks"; got != want {
+		t.Fatalf("Error => %q; want %q", got, want)
+	}
+
+	if errs := checkRender(vars, nil); len(errs) != 0 {
+		t.Fatalf("checkRender => %v; want none", errs)
+	}
+}
@@ -0,0 +1,150 @@
+package empire
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/remind101/pkg/timex"
+)
+
+// SecretResolver resolves references to secrets that are stored outside of
+// Empire, like vault://secret/app#KEY. Configs only store the reference, and
+// it's resolved whenever the environment for a process is built.
+type SecretResolver interface {
+	// Resolve returns the secret value for the reference.
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc is a function that implements the SecretResolver
+// interface.
+type SecretResolverFunc func(string) (string, error)
+
+// Resolve implements the SecretResolver interface.
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// SecretResolvers maps a reference scheme, like "vault", to the SecretResolver
+// for references using that scheme. Values with any other scheme, like
+// postgres://, are treated as literals.
+type SecretResolvers map[string]SecretResolver
+
+// resolver returns the SecretResolver for the value, or nil if the value isn't
+// a reference.
+func (r SecretResolvers) resolver(value string) SecretResolver {
+	i := strings.Index(value, "://")
+	if i < 1 {
+		return nil
+	}
+
+	return r[value[:i]]
+}
+
+// Resolve returns the variables in the config with any external secret
+// references resolved. Literal values are passed through unchanged. If any
+// reference can't be resolved, an error is returned, so that a process never
+// receives the reference in place of the secret.
+func (c *Config) Resolve(resolvers SecretResolvers) (Vars, error) {
+	vars := make(Vars, len(c.Vars))
+
+	for _, n := range c.Vars.Keys() {
+		v := c.Vars[n]
+
+		r := resolvers.resolver(*v)
+		if r == nil {
+			vars[n] = v
+			continue
+		}
+
+		resolved, err := r.Resolve(*v)
+		if err != nil {
+			return nil, &VarError{Name: n, Err: fmt.Errorf("unable to resolve %s: %v", *v, err)}
+		}
+		vars[n] = &resolved
+	}
+
+	return vars, nil
+}
+
+// checkResolvable returns an error for each variable in vars, ordered by name,
+// whose value can't be fully resolved for the app, the same way that it would
+// be when building the environment for a process. Unset variables are skipped.
+func checkResolvable(app *App, vars Vars, resolvers SecretResolvers, strict bool) []error {
+	var errs []error
+
+	for _, n := range vars.Keys() {
+		if vars[n] == nil {
+			continue
+		}
+
+		interpolated, err := (&Config{Vars: Vars{n: vars[n]}}).Interpolate(app, strict)
+		if err == nil {
+			_, err = (&Config{Vars: interpolated}).Resolve(resolvers)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// envBuilder builds the variables for the environment of a process from a
+// config.
+type envBuilder struct {
+	// Default variables to add to the environment of every process.
+	defaults Vars
+
+	// Used to resolve references to external secrets.
+	resolvers SecretResolvers
+
+	// If true, references to unknown app attributes, like ${app.nope}, are
+	// an error, rather than being left as is.
+	strictInterpolation bool
+
+	// If provided, used to rename variables before they're added to the
+	// environment.
+	transformKeys KeyTransformer
+
+	// If provided, used to resolve references to variables in other apps
+	// configs.
+	appRefs *appRefResolver
+}
+
+// Vars returns the variables for the environment of a process for the app
+// using the config. Variables that have expired are dropped. The apps own
+// values are interpolated before references to other apps configs are
+// resolved, so that referenced values are only interpolated against the app
+// that owns them.
+func (b *envBuilder) Vars(app *App, c *Config) (Vars, error) {
+	return b.varsAt(app, c, timex.Now())
+}
+
+// varsAt is like Vars, but drops the variables that had expired as of now.
+func (b *envBuilder) varsAt(app *App, c *Config, now time.Time) (Vars, error) {
+	vars, err := c.Unexpired(now).Interpolate(app, b.strictInterpolation)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.appRefs != nil {
+		vars, err = b.appRefs.Resolve(app, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vars, err = (&Config{Vars: vars}).Resolve(b.resolvers)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err = (&Config{Vars: vars}).TransformKeys(b.transformKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&Config{Vars: vars}).WithDefaults(b.defaults), nil
+}
@@ -0,0 +1,90 @@
+package empire
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_Resolve(t *testing.T) {
+	resolvers := SecretResolvers{
+		"vault": SecretResolverFunc(func(ref string) (string, error) {
+			switch ref {
+			case "vault://secret/app#API_KEY":
+				return "s3cr3t", nil
+			default:
+				return "", errors.New("not found")
+			}
+		}),
+	}
+
+	c := &Config{
+		Vars: Vars{
+			"API_KEY":      strptr("vault://secret/app#API_KEY"),
+			"DATABASE_URL": strptr("postgres://localhost"),
+			"RAILS_ENV":    strptr("production"),
+		},
+	}
+
+	vars, err := c.Resolve(resolvers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"API_KEY":      strptr("s3cr3t"),
+		"DATABASE_URL": strptr("postgres://localhost"),
+		"RAILS_ENV":    strptr("production"),
+	}
+
+	if got, want := vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Resolve => %v; want %v", Vars(got).format(false), Vars(want).format(false))
+	}
+
+	// The stored config keeps the reference.
+	if got, want := *c.Vars["API_KEY"], "vault://secret/app#API_KEY"; got != want {
+		t.Fatalf("API_KEY => %v; want %v", got, want)
+	}
+
+	c.Vars["MISSING"] = strptr("vault://secret/app#MISSING")
+	if _, err := c.Resolve(resolvers); err == nil {
+		t.Fatal("Expected an error resolving a missing secret")
+	}
+}
+
+func TestCheckResolvable(t *testing.T) {
+	resolvers := SecretResolvers{
+		"vault": SecretResolverFunc(func(ref string) (string, error) {
+			if ref == "vault://secret/acme-inc#API_KEY" {
+				return "s3cr3t", nil
+			}
+			return "", errors.New("not found")
+		}),
+	}
+
+	vars := Vars{
+		"API_KEY":      strptr("vault://secret/${app.name}#API_KEY"),
+		"MISSING":      strptr("vault://secret/${app.name}#MISSING"),
+		"UNKNOWN":      strptr("${app.nope}"),
+		"DATABASE_URL": strptr("postgres://localhost"),
+		"REMOVED":      nil,
+	}
+
+	app := &App{Name: "acme-inc"}
+
+	errs := checkResolvable(app, vars, resolvers, true)
+	if got, want := len(errs), 2; got != want {
+		t.Fatalf("len(errs) => %d; want %d: %v", got, want, errs)
+	}
+
+	for i, name := range []Variable{"MISSING", "UNKNOWN"} {
+		if got, want := errs[i].(*VarError).Name, name; got != want {
+			t.Errorf("errs[%d] => %v; want %v", i, got, want)
+		}
+	}
+
+	// Unknown references are left as is when not strict.
+	if errs := checkResolvable(app, vars, resolvers, false); len(errs) != 1 {
+		t.Fatalf("errs => %v; want only MISSING", errs)
+	}
+}
@@ -0,0 +1,92 @@
+package empire
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/remind101/pkg/timex"
+)
+
+// ConfigsFindReusedSecrets finds secret values that are used by the current
+// config of more than one app, which should be made app specific. Each reused
+// secret is keyed by a hash of its value, and maps to the sorted names of the
+// apps that use it. Values are hashed with HMAC-SHA256 using a random key
+// that's discarded afterwards, so the hashes can be logged safely: they can't
+// be reversed or matched against guesses, and they're only comparable within a
+// single result. Configs are streamed with ConfigsScanAll, so not every config
+// is held in memory at once.
+func (s *store) ConfigsFindReusedSecrets() (map[string][]string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	r := newSecretReuse(key)
+	if _, err := s.ConfigsScanAll(func(app string, c *Config) []error {
+		r.add(app, c)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return r.reused(), nil
+}
+
+// secretReuse tracks which apps use each secret value, by its hash.
+type secretReuse struct {
+	key  []byte
+	apps map[string]map[string]bool
+}
+
+func newSecretReuse(key []byte) *secretReuse {
+	return &secretReuse{
+		key:  key,
+		apps: make(map[string]map[string]bool),
+	}
+}
+
+// add records the secret values in the apps config. Empty and expired values
+// are ignored.
+func (r *secretReuse) add(app string, c *Config) {
+	c = c.Unexpired(timex.Now())
+
+	for n, v := range c.Vars {
+		if v == nil || *v == "" || !c.IsSecret(n) {
+			continue
+		}
+
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(*v))
+		h := hex.EncodeToString(mac.Sum(nil))
+
+		if r.apps[h] == nil {
+			r.apps[h] = make(map[string]bool)
+		}
+		r.apps[h][app] = true
+	}
+}
+
+// reused returns the hashes of the values used by more than one app, mapped
+// to the sorted names of the apps.
+func (r *secretReuse) reused() map[string][]string {
+	reused := make(map[string][]string)
+
+	for h, apps := range r.apps {
+		if len(apps) < 2 {
+			continue
+		}
+
+		names := make([]string, 0, len(apps))
+		for app := range apps {
+			names = append(names, app)
+		}
+		sort.Strings(names)
+
+		reused[h] = names
+	}
+
+	return reused
+}
@@ -0,0 +1,42 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecretReuse(t *testing.T) {
+	r := newSecretReuse([]byte("key"))
+
+	r.add("api", &Config{Vars: Vars{
+		"STRIPE_KEY":   strptr("sk_live_shared"),
+		"API_TOKEN":    strptr("sk_live_shared"),
+		"GITHUB_TOKEN": strptr("api only"),
+		"EMPTY_TOKEN":  strptr(""),
+		"RAILS_ENV":    strptr("production"),
+	}})
+	r.add("web", &Config{Vars: Vars{
+		"PAYMENTS_KEY": strptr("sk_live_shared"),
+		"EMPTY_TOKEN":  strptr(""),
+		"RAILS_ENV":    strptr("production"),
+	}})
+	r.add("worker", &Config{Vars: Vars{
+		"RAILS_ENV": strptr("production"),
+	}})
+
+	reused := r.reused()
+
+	if got, want := len(reused), 1; got != want {
+		t.Fatalf("len(reused) => %d; want %d (%v)", got, want, reused)
+	}
+
+	for h, apps := range reused {
+		if got, want := apps, []string{"api", "web"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("apps => %v; want %v", got, want)
+		}
+
+		if h == "sk_live_shared" || len(h) != 64 {
+			t.Fatalf("hash => %q; want a hex encoded HMAC", h)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package empire
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrRewriteSameName is returned when rewriting a variable name to
+	// itself.
+	ErrRewriteSameName = errors.New("A variable can't be rewritten to the same name.")
+
+	// ErrRewriteConflict is returned when rewriting a variable name to a
+	// name that's already used in one of the apps configs.
+	ErrRewriteConflict = errors.New("The new variable name is already used in the app's config history.")
+
+	// ErrRewriteFrozen is returned when rewriting a variable name that's
+	// used by one of the apps frozen configs, which can't be changed.
+	ErrRewriteFrozen = errors.New("The variable is used by a frozen config, which can't be rewritten.")
+)
+
+// ConfigsRewriteHistoryVarName renames the variable in every config for the
+// app, including historical ones, along with any scheduled changes and owner
+// for it, in a single transaction, returning the number of configs that were
+// changed.
+func (s *store) ConfigsRewriteHistoryVarName(app *App, from, to Variable) (int, error) {
+	return configsRewriteHistoryVarName(s.db, app, from, to, s.decryptConfig)
+}
+
+// configsRewriteHistoryConflictsSQL counts the configs, scheduled changes and
+// owners for the app that use both names.
+const configsRewriteHistoryConflictsSQL = `select
+	(select count(*) from configs where app_id = ? and exist(vars, ?) and exist(vars, ?)) +
+	(select count(*) from scheduled_configs where app_id = ? and applied_at is null and exist(vars, ?) and exist(vars, ?)) +
+	(select count(*) from config_var_owners o1 join config_var_owners o2 on o2.app_id = o1.app_id where o1.app_id = ? and o1.name = ? and o2.name = ?)`
+
+// configsRewriteHistoryFrozenSQL counts the frozen configs for the app that use
+// the name.
+const configsRewriteHistoryFrozenSQL = `select count(*)
+from frozen_configs f
+join configs c on c.id = f.config_id
+where f.app_id = ? and exist(c.vars, ?)`
+
+// configsRewriteHistoryVarName renames the hstore key, and any expiry or secret
+// flag for it, in every config for the app within a transaction, along with
+// the scheduled changes that haven't been applied yet, and the owner of the
+// variable.
+func configsRewriteHistoryVarName(db *gorm.DB, app *App, from, to Variable, decrypt func(*Config) error) (int, error) {
+	t := db.Begin()
+
+	var conflicts int
+	if err := t.Raw(configsRewriteHistoryConflictsSQL,
+		app.ID, string(from), string(to),
+		app.ID, string(from), string(to),
+		app.ID, string(from), string(to)).Row().Scan(&conflicts); err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if conflicts > 0 {
+		t.Rollback()
+		return 0, ErrRewriteConflict
+	}
+
+	var frozen int
+	if err := t.Raw(configsRewriteHistoryFrozenSQL, app.ID, string(from)).Row().Scan(&frozen); err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if frozen > 0 {
+		t.Rollback()
+		return 0, ErrRewriteFrozen
+	}
+
+	r := t.Exec(`update configs set
+		vars = delete(vars, ?) || hstore(?, vars -> ?),
+		expires = case when exist(expires, ?) then delete(expires, ?) || hstore(?, expires -> ?) else expires end,
+		secret_flags = case when exist(secret_flags, ?) then delete(secret_flags, ?) || hstore(?, secret_flags -> ?) else secret_flags end
+		where app_id = ? and exist(vars, ?)`,
+		string(from), string(to), string(from),
+		string(from), string(from), string(to), string(from),
+		string(from), string(from), string(to), string(from),
+		app.ID, string(from))
+	if err := r.Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if err := t.Exec(`update scheduled_configs set vars = delete(vars, ?) || hstore(?, vars -> ?)
+		where app_id = ? and applied_at is null and exist(vars, ?)`,
+		string(from), string(to), string(from), app.ID, string(from)).Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	if err := t.Exec(`update config_var_owners set name = ? where app_id = ? and name = ?`, string(to), app.ID, string(from)).Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	// The vars changed, so the stored fingerprints need to be updated to
+	// match. Fingerprints are of the plaintext, so encrypted values are
+	// decrypted first.
+	var configs []*Config
+	if err := t.Where(`app_id = ? and exist(vars, ?) and vars_fingerprint is not null`, app.ID, string(to)).Find(&configs).Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	for _, c := range configs {
+		if err := decrypt(c); err != nil {
+			t.Rollback()
+			return 0, err
+		}
+
+		if err := t.Exec(`update configs set vars_fingerprint = ? where id = ?`, c.Fingerprint(), c.ID).Error; err != nil {
+			t.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return 0, err
+	}
+
+	return int(r.RowsAffected), nil
+}
+
+// ConfigsRewriteHistoryVarName renames a variable in every config the app has
+// ever had, returning the number of configs that were changed. This is meant
+// for scrubbing a sensitive variable name, and unlike every other config
+// operation, it's destructive: history is mutated in place, no new config is
+// created, and the change can't be undone or rolled back to. Scheduled changes
+// that haven't been applied yet, and the owner of the variable, are renamed
+// along with it.
+//
+// Like any other change, the apps config can't be locked, a reason is needed
+// if one is required, and the user has to be allowed to change both
+// variables. Frozen configs are guaranteed to never change, so if the variable
+// is used by any of the apps frozen configs, ErrRewriteFrozen is returned and
+// nothing is rewritten.
+//
+// Running processes keep the old name until the app is released again, and
+// the descriptions of past releases aren't changed.
+func (s *configsService) ConfigsRewriteHistoryVarName(ctx context.Context, app *App, from, to Variable) (int, error) {
+	if from == to {
+		return 0, ErrRewriteSameName
+	}
+
+	if !VarNamePattern.MatchString(string(to)) {
+		return 0, &VarError{Name: to, Err: ErrInvalidVarName}
+	}
+
+	if err := s.checkReason(ctx); err != nil {
+		return 0, err
+	}
+
+	unlock, err := s.store.AppsLock(app)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := s.checkLock(app); err != nil {
+		return 0, err
+	}
+
+	if s.authorizer != nil {
+		owners, err := s.ConfigsOwners(app)
+		if err != nil {
+			return 0, err
+		}
+
+		if errs := authorizeVars(ctx, app, Vars{from: nil, to: nil}, owners, s.authorizer); len(errs) > 0 {
+			return 0, VarErrors(errs)
+		}
+	}
+
+	return s.store.ConfigsRewriteHistoryVarName(app, from, to)
+}
@@ -0,0 +1,257 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrScheduleInPast is returned when scheduling a config change for a
+	// time that isn't in the future.
+	ErrScheduleInPast = errors.New("Scheduled config changes must take effect in the future.")
+
+	// ErrNotScheduled is returned when cancelling a config change that isn't
+	// scheduled, or has already taken effect.
+	ErrNotScheduled = errors.New("Config is not scheduled, or has already taken effect.")
+)
+
+// ScheduledConfig is a change to an apps config that's applied at a later
+// time, by ConfigsApplyScheduled. Until then, it has no effect on the apps
+// config.
+type ScheduledConfig struct {
+	ID    string
+	AppID string
+
+	// The vars to apply. A nil value unsets the variable.
+	Vars VarsUpdate
+
+	// When the change should be applied.
+	EffectiveAt time.Time
+
+	CreatedAt *time.Time
+
+	// When the change was applied, and the config it created. Both are nil
+	// until then.
+	AppliedAt *time.Time
+	ConfigID  *string
+}
+
+// Set created_at before inserting.
+func (c *ScheduledConfig) BeforeCreate() error {
+	t := timex.Now()
+	c.CreatedAt = &t
+	return nil
+}
+
+// ConfigsScheduleCreate persists the scheduled change.
+func (s *store) ConfigsScheduleCreate(c *ScheduledConfig) (*ScheduledConfig, error) {
+	restore, err := s.sealVars(c.AppID, (*Vars)(&c.Vars))
+	defer restore()
+	if err != nil {
+		return c, err
+	}
+
+	return c, s.db.Create(c).Error
+}
+
+// ConfigsScheduledFind returns the scheduled change with the given id, if it
+// hasn't been applied yet.
+func (s *store) ConfigsScheduledFind(id string) (*ScheduledConfig, error) {
+	var c ScheduledConfig
+	if err := s.db.Where(`id = ? and applied_at is null`, id).First(&c).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, ErrNotScheduled
+		}
+
+		return nil, err
+	}
+	return &c, s.decryptScheduled(&c)
+}
+
+// ConfigsScheduled returns the scheduled changes for the app that haven't been
+// applied yet, in the order that they'll be applied.
+func (s *store) ConfigsScheduled(app *App) ([]*ScheduledConfig, error) {
+	var configs []*ScheduledConfig
+	if err := s.db.Where(`app_id = ? and applied_at is null`, app.ID).Order("effective_at, created_at").Find(&configs).Error; err != nil {
+		return configs, err
+	}
+	return configs, s.decryptScheduled(configs...)
+}
+
+// ConfigsScheduledDue returns the scheduled changes for every app that should
+// have been applied by now, in the order that they should be applied.
+func (s *store) ConfigsScheduledDue(now time.Time) ([]*ScheduledConfig, error) {
+	var configs []*ScheduledConfig
+	if err := s.db.Where(`applied_at is null and effective_at <= ?`, now.UTC()).Order("effective_at, created_at").Find(&configs).Error; err != nil {
+		return configs, err
+	}
+	return configs, s.decryptScheduled(configs...)
+}
+
+// decryptScheduled decrypts the vars of scheduled changes that were read from
+// the database.
+func (s *store) decryptScheduled(configs ...*ScheduledConfig) error {
+	for _, c := range configs {
+		vars, err := s.decryptVars(c.AppID, Vars(c.Vars))
+		if err != nil {
+			return err
+		}
+		c.Vars = VarsUpdate(vars)
+	}
+
+	return nil
+}
+
+// ConfigsCancelScheduled removes a scheduled change that hasn't been applied
+// yet.
+func (s *store) ConfigsCancelScheduled(app *App, id string) error {
+	return configsCancelScheduled(s.db, app, id)
+}
+
+// configsCancelScheduled deletes the scheduled change if it hasn't been
+// applied.
+func configsCancelScheduled(db *gorm.DB, app *App, id string) error {
+	r := db.Exec(`delete from scheduled_configs where id = ? and app_id = ? and applied_at is null`, id, app.ID)
+	if err := r.Error; err != nil {
+		return err
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrNotScheduled
+	}
+
+	return nil
+}
+
+// ConfigsScheduledApplied records that the scheduled change was applied by
+// creating the config.
+func (s *store) ConfigsScheduledApplied(id string, config *Config) error {
+	return configsScheduledApplied(s.db, id, config, timex.Now())
+}
+
+// configsScheduledApplied records that the scheduled change was applied by
+// creating the config.
+func configsScheduledApplied(db *gorm.DB, id string, config *Config, now time.Time) error {
+	r := db.Exec(`update scheduled_configs set applied_at = ?, config_id = ? where id = ? and applied_at is null`, now.UTC(), config.ID, id)
+	if err := r.Error; err != nil {
+		return err
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrNotScheduled
+	}
+
+	return nil
+}
+
+// ConfigsSchedule stores vars as a change to the apps config that's applied at
+// the given time by ConfigsApplyScheduled, which is run periodically by
+// RunConfigsScheduler. The vars are merged into the config that's current when
+// the change is applied, so changes applied in the meantime are kept, and the
+// app is released like it would be by ConfigsApply. The vars are validated now,
+// so that invalid changes can't be scheduled, and again when they're applied.
+func (s *configsService) ConfigsSchedule(ctx context.Context, app *App, vars Vars, at time.Time) (*ScheduledConfig, error) {
+	if !at.After(timex.Now()) {
+		return nil, ErrScheduleInPast
+	}
+
+	if err := s.checkLock(app); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkReason(ctx); err != nil {
+		return nil, err
+	}
+
+	old, err := s.current(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(ctx, app, old, vars); err != nil {
+		return nil, err
+	}
+
+	if _, errs := s.validate(app, old, vars); len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	return s.store.ConfigsScheduleCreate(&ScheduledConfig{
+		AppID:       app.ID,
+		Vars:        VarsUpdate(vars),
+		EffectiveAt: at.UTC(),
+	})
+}
+
+// ConfigsCancelScheduled cancels a config change created with
+// ConfigsSchedule, if it hasn't been applied yet.
+func (s *configsService) ConfigsCancelScheduled(app *App, id string) error {
+	return s.store.ConfigsCancelScheduled(app, id)
+}
+
+// ConfigsApplyScheduled applies every scheduled change that has come due,
+// oldest first, returning the new configs, along with an error for each
+// scheduled change, by id, that couldn't be applied, like changes to an app
+// whose config is locked. Changes that fail stay scheduled, so they're tried
+// again the next time this is called, until they're cancelled.
+func (s *configsService) ConfigsApplyScheduled(ctx context.Context) ([]*Config, map[string]error, error) {
+	due, err := s.store.ConfigsScheduledDue(timex.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configs := []*Config{}
+	failed := make(map[string]error)
+
+	for _, c := range due {
+		config, err := s.applyScheduled(ctx, c.ID, c.AppID)
+		if err != nil {
+			failed[c.ID] = err
+			continue
+		}
+
+		if config != nil {
+			configs = append(configs, config)
+		}
+	}
+
+	return configs, failed, nil
+}
+
+// applyScheduled applies the scheduled change, returning nil if it has already
+// been applied or cancelled.
+func (s *configsService) applyScheduled(ctx context.Context, id, appID string) (*Config, error) {
+	app, err := s.store.AppsFirst(AppsQuery{ID: &appID})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = WithReason(ctx, fmt.Sprintf("Scheduled config change %s", id))
+
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		// Reload the change now that the app is locked, in case it was
+		// applied by another instance, or cancelled, in the meantime.
+		scheduled, err := s.store.ConfigsScheduledFind(id)
+		if err == ErrNotScheduled {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The change was authorized for the user that scheduled it,
+		// and there isn't a user when it's applied.
+		return s.applyChange(ctx, app, configChange{
+			vars:       Vars(scheduled.Vars),
+			authorized: true,
+			then: func(c *Config, tx configsStore) error {
+				return tx.ConfigsScheduledApplied(scheduled.ID, c)
+			},
+		})
+	})
+}
@@ -0,0 +1,88 @@
+package empire
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrInvalidSignature is returned when importing a signed config bundle whose
+// signature doesn't verify.
+var ErrInvalidSignature = errors.New("Config bundle signature is invalid.")
+
+// Signer signs the payload of a config bundle, like with an operator's private
+// key.
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+}
+
+// SignerFunc is a function that implements the Signer interface.
+type SignerFunc func([]byte) ([]byte, error)
+
+// Sign implements the Signer interface.
+func (f SignerFunc) Sign(payload []byte) ([]byte, error) {
+	return f(payload)
+}
+
+// Verifier verifies the signature of a config bundle, returning an error if
+// it's not valid for the payload.
+type Verifier interface {
+	Verify(payload, signature []byte) error
+}
+
+// VerifierFunc is a function that implements the Verifier interface.
+type VerifierFunc func(payload, signature []byte) error
+
+// Verify implements the Verifier interface.
+func (f VerifierFunc) Verify(payload, signature []byte) error {
+	return f(payload, signature)
+}
+
+// signedBundle is the JSON representation of a signed config. The payload is
+// kept as the exact bytes that were signed, rather than being embedded as an
+// object, so that it doesn't need to be re-encoded to be verified.
+type signedBundle struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// ExportSigned writes the config to w as a JSON bundle, along with a detached
+// signature of it from signer, which can be imported with ImportSigned.
+func (c *Config) ExportSigned(w io.Writer, signer Signer) error {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&signedBundle{
+		Payload:   payload,
+		Signature: signature,
+	})
+}
+
+// ImportSigned reads a bundle written by ExportSigned from r, returning the
+// vars from the config once the signature has been verified with verifier. If
+// the signature doesn't verify, ErrInvalidSignature is returned and the
+// payload isn't decoded.
+func ImportSigned(r io.Reader, verifier Verifier) (Vars, error) {
+	var b signedBundle
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+
+	if len(b.Signature) == 0 || verifier.Verify(b.Payload, b.Signature) != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	var c Config
+	if err := json.Unmarshal(b.Payload, &c); err != nil {
+		return nil, err
+	}
+
+	return c.Vars, nil
+}
@@ -0,0 +1,60 @@
+package empire
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfig_ExportSigned(t *testing.T) {
+	key := []byte("operator key")
+
+	signer := SignerFunc(func(payload []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(payload)
+		return mac.Sum(nil), nil
+	})
+
+	verifier := VerifierFunc(func(payload, signature []byte) error {
+		expected, _ := signer.Sign(payload)
+		if !hmac.Equal(signature, expected) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	})
+
+	c := &Config{ID: "1", Vars: Vars{"RAILS_ENV": strptr("production")}}
+
+	buf := new(bytes.Buffer)
+	if err := c.ExportSigned(buf, signer); err != nil {
+		t.Fatal(err)
+	}
+	bundle := buf.String()
+
+	vars, err := ImportSigned(strings.NewReader(bundle), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, c.Vars; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ImportSigned => %v; want %v", got, want)
+	}
+
+	// Sign a different payload with another key.
+	other := SignerFunc(func(payload []byte) ([]byte, error) {
+		return []byte("forged"), nil
+	})
+
+	buf.Reset()
+	if err := (&Config{Vars: Vars{"RAILS_ENV": strptr("evil")}}).ExportSigned(buf, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ImportSigned(buf, verifier); err != ErrInvalidSignature {
+		t.Fatalf("ImportSigned => %v; want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,94 @@
+package empire
+
+import "sort"
+
+// FindSimilarKeys groups the names of variables in the config that are within
+// threshold edits (the Levenshtein distance) of each other, like DATABASE_URL
+// and DATABASEURL. Names are grouped transitively, so every name in a group is
+// similar to at least one other name in the group. Only groups with more than
+// one name are returned, which is useful for finding variables that may be
+// duplicates. Nothing is merged.
+func (c *Config) FindSimilarKeys(threshold int) [][]Variable {
+	keys := c.Vars.Keys()
+
+	// Union find, where each key starts in its own group.
+	parent := make([]int, len(keys))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if levenshtein(string(keys[i]), string(keys[j])) <= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]Variable)
+	for i, k := range keys {
+		root := find(i)
+		groups[root] = append(groups[root], k)
+	}
+
+	var similar [][]Variable
+	for _, g := range groups {
+		if len(g) > 1 {
+			similar = append(similar, g)
+		}
+	}
+
+	// Keys were added to each group in sorted order, so sorting by the first
+	// key in each group makes the result stable.
+	sort.Sort(variableGroups(similar))
+
+	return similar
+}
+
+type variableGroups [][]Variable
+
+func (g variableGroups) Len() int           { return len(g) }
+func (g variableGroups) Less(i, j int) bool { return g[i][0] < g[j][0] }
+func (g variableGroups) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+
+// levenshtein returns the minimum number of single character insertions,
+// deletions or substitutions needed to change a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,55 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_FindSimilarKeys(t *testing.T) {
+	c := &Config{Vars: Vars{
+		"DATABASE_URL": strptr(""),
+		"DATABASEURL":  strptr(""),
+		"DATABASE_URI": strptr(""),
+		"DB_URL":       strptr(""),
+		"PORT":         strptr(""),
+		"PORTS":        strptr(""),
+		"RAILS_ENV":    strptr(""),
+	}}
+
+	tests := []struct {
+		threshold int
+		out       [][]Variable
+	}{
+		{0, nil},
+		{1, [][]Variable{
+			{"DATABASEURL", "DATABASE_URI", "DATABASE_URL"},
+			{"PORT", "PORTS"},
+		}},
+	}
+
+	for _, tt := range tests {
+		out := c.FindSimilarKeys(tt.threshold)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Fatalf("FindSimilarKeys(%d) => %v; want %v", tt.threshold, out, tt.out)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		out  int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"DATABASE_URL", "DATABASEURL", 1},
+		{"DATABASE_URL", "DB_URL", 6},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if out := levenshtein(tt.a, tt.b); out != tt.out {
+			t.Fatalf("levenshtein(%q, %q) => %d; want %d", tt.a, tt.b, out, tt.out)
+		}
+	}
+}
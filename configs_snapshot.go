@@ -0,0 +1,130 @@
+package empire
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/pkg/timex"
+	"golang.org/x/net/context"
+)
+
+// ErrSnapshotAppMismatch is returned when restoring a snapshot that was taken
+// from a different app, without forcing it.
+var ErrSnapshotAppMismatch = errors.New("Snapshot was taken from a different app.")
+
+// ConfigSnapshot is a point in time export of an apps config, which can be
+// restored with ConfigsRestoreSnapshot.
+type ConfigSnapshot struct {
+	// The name of the app that the snapshot was taken from.
+	App string `json:"app"`
+
+	// The config that was current when the snapshot was taken.
+	Config *Config `json:"config"`
+}
+
+// WriteSnapshot writes a JSON ConfigSnapshot of the config, which belongs to
+// app, to w.
+func (c *Config) WriteSnapshot(w io.Writer, app *App) error {
+	return json.NewEncoder(w).Encode(&ConfigSnapshot{
+		App:    app.Name,
+		Config: c,
+	})
+}
+
+// ReadSnapshot decodes a JSON ConfigSnapshot from r.
+func ReadSnapshot(r io.Reader) (*ConfigSnapshot, error) {
+	var snapshot ConfigSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+
+	if snapshot.Config == nil {
+		snapshot.Config = &Config{}
+	}
+
+	return &snapshot, nil
+}
+
+// snapshotValidators are always run against the vars in a snapshot before
+// it's restored, whatever validators are configured, since a snapshot file may
+// have been edited by hand.
+var snapshotValidators = []Validator{
+	ValidateVarName,
+	ValidateVarValue,
+	ValidateHstore,
+}
+
+// ConfigsRestoreSnapshot replaces the apps config with the vars, expirations
+// and secret flags from the snapshot. Variables that aren't in the snapshot are
+// unset, and variables that have expired since the snapshot was taken aren't
+// restored. If the snapshot was taken from a different app,
+// ErrSnapshotAppMismatch is returned unless force is true.
+func (s *configsService) ConfigsRestoreSnapshot(ctx context.Context, app *App, r io.Reader, force bool) (*Config, error) {
+	snapshot, err := ReadSnapshot(r)
+	if err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+
+	if snapshot.App != app.Name && !force {
+		return nil, ErrSnapshotAppMismatch
+	}
+
+	if errs := validateVars(snapshot.Config.Vars, snapshotValidators); len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+
+	return s.locked(ctx, app, func() (*Config, *Release, error) {
+		old, err := s.current(app)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		change := replacement(old, snapshot.Config)
+
+		// If the snapshot was taken from a config in the apps history,
+		// the new config restores it.
+		if id := snapshot.Config.ID; id != "" {
+			restored, err := s.store.ConfigsFirst(ConfigsQuery{ID: &id, App: app})
+			if err != nil && err != gorm.RecordNotFound {
+				return nil, nil, err
+			}
+			if err == nil {
+				change.restores = restored
+			}
+		}
+
+		return s.applyChange(ctx, app, change)
+	})
+}
+
+// replacement returns the change that, when applied to old, results in the
+// vars, expirations and secret flags of new. Variables in new that have
+// already expired are dropped.
+func replacement(old, new *Config) configChange {
+	new = new.Unexpired(timex.Now())
+
+	return configChange{
+		vars:         replaceVars(old.Vars, new.Vars),
+		expires:      new.Expires,
+		flags:        new.SecretFlags,
+		replaceFlags: true,
+	}
+}
+
+// replaceVars returns the Vars that, when merged into old, result in exactly
+// new.
+func replaceVars(old, new Vars) Vars {
+	vars := make(Vars)
+
+	for k := range old {
+		vars[k] = nil
+	}
+
+	for k, v := range new {
+		vars[k] = v
+	}
+
+	return vars
+}
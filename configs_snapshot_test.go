@@ -0,0 +1,77 @@
+package empire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/remind101/pkg/timex"
+)
+
+func TestConfig_WriteSnapshot(t *testing.T) {
+	c := &Config{ID: "1", AppID: "2", Vars: Vars{"FOO": strptr("bar")}}
+
+	buf := new(bytes.Buffer)
+	if err := c.WriteSnapshot(buf, &App{ID: "2", Name: "acme-inc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := ReadSnapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := snapshot.App, "acme-inc"; got != want {
+		t.Fatalf("App => %q; want %q", got, want)
+	}
+
+	if got, want := snapshot.Config.Vars, c.Vars; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+}
+
+func TestReplaceVars(t *testing.T) {
+	old := Vars{"FOO": strptr("foo"), "BAR": strptr("bar")}
+	new := Vars{"FOO": strptr("baz"), "QUX": strptr("qux")}
+
+	vars := replaceVars(old, new)
+	if got, want := mergeVars(old, vars), new; !reflect.DeepEqual(got, want) {
+		t.Fatalf("mergeVars(old, replaceVars(old, new)) => %v; want %v", got, want)
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	timex.Now = func() time.Time { return now }
+	defer func() { timex.Now = time.Now }()
+
+	old := &Config{
+		Vars:        Vars{"FOO": strptr("foo"), "BAR": strptr("bar")},
+		Expires:     Expirations{"BAR": now.Add(time.Hour)},
+		SecretFlags: SecretFlags{"FOO": SecretFlagPlain},
+	}
+	new := &Config{
+		Vars:        Vars{"FOO": strptr("baz"), "TOKEN": strptr("abc"), "OLD": strptr("old")},
+		Expires:     Expirations{"TOKEN": now.Add(time.Hour), "OLD": now.Add(-time.Hour)},
+		SecretFlags: SecretFlags{"TOKEN": SecretFlagSecret},
+	}
+
+	change := replacement(old, new)
+
+	if got, want := mergeVars(old.Vars, change.vars), (Vars{"FOO": strptr("baz"), "TOKEN": strptr("abc")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	if got, want := change.expires, (Expirations{"TOKEN": now.Add(time.Hour)}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expires => %v; want %v", got, want)
+	}
+
+	if got, want := change.flags, new.SecretFlags; !reflect.DeepEqual(got, want) {
+		t.Fatalf("flags => %v; want %v", got, want)
+	}
+
+	if !change.replaceFlags {
+		t.Fatal("replaceFlags => false; want true")
+	}
+}
@@ -0,0 +1,135 @@
+package empire
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+// configsStore is the storage that the configsService depends on. It's
+// implemented for postgres by sqlConfigsStore, but the service doesn't rely on
+// anything outside of this interface, so configs can be stored elsewhere by
+// implementing it. Queries are passed as plain structs, rather than as scopes,
+// so implementations don't need to understand SQL.
+//
+// Implementations must guarantee that:
+//
+//   - ConfigsCurrent returns the config of the apps latest release, or its
+//     latest config if it hasn't been released, and gorm.RecordNotFound if
+//     the app doesn't have a config.
+//   - Configs and ConfigsFirst order configs newest first, with configs
+//     created within the same instant ordered by when they were inserted.
+//   - Configs are never changed once they're created. ConfigsCreate, and
+//     the other create methods, set the ID and CreatedAt of the config that
+//     they're given, and a config that's returned is never modified by the
+//     store afterwards.
+//   - The function passed to ConfigsCreateWith is called with a store
+//     that makes its changes atomically with the config being created, and
+//     an error from it prevents the config from being created.
+//   - AppsLock blocks other callers of AppsLock for the same app until the
+//     returned function is called.
+type configsStore interface {
+	Apps(q AppsQuery) ([]*App, error)
+	AppsFirst(q AppsQuery) (*App, error)
+	AppsLock(app *App) (unlock func() error, err error)
+
+	ReleasesFirst(q ReleasesQuery) (*Release, error)
+	ReleasesConfigID(releaseID string) (string, error)
+
+	Configs(q ConfigsQuery) ([]*Config, error)
+	ConfigsFirst(q ConfigsQuery) (*Config, error)
+	ConfigsCurrent(app *App) (*Config, error)
+	ConfigsEachCurrentVar(app *App, fn func(name Variable, value *string, flag SecretFlag, expires *time.Time) error) error
+	ConfigsReleasedAt(c *Config) (*time.Time, error)
+	ConfigsCreate(config *Config) (*Config, error)
+	ConfigsCreateWith(config *Config, fn func(tx configsStore) error) (*Config, error)
+	ConfigsCreateAll(configs ...*Config) error
+	ConfigsCoalesceHistory(app *App) (int, error)
+	ConfigsRewriteHistoryVarName(app *App, from, to Variable) (int, error)
+	ConfigsListenChanges(ctx context.Context) (<-chan ConfigChange, error)
+
+	ConfigsExpiringApps() ([]string, error)
+
+	ConfigsFreeze(app *App, name string, config *Config) error
+	ConfigsFrozen(app *App, name string) (*Config, error)
+	ConfigsFrozenNames(app *App) (map[string][]string, error)
+	ConfigsLineageNodes(app *App) ([]*Config, error)
+
+	ConfigsVarOwners(app *App) (map[Variable]string, error)
+	ConfigsSetVarOwner(app *App, name Variable, team string) error
+
+	ConfigLocksFind(app *App) (*ConfigLock, error)
+	ConfigLocksCreate(lock *ConfigLock) (*ConfigLock, error)
+	ConfigLocksDestroy(app *App) error
+
+	ConfigProposalsCreate(p *ConfigProposal) (*ConfigProposal, error)
+	ConfigProposalsFind(id string) (*ConfigProposal, error)
+	ConfigProposalsPending(app *App) ([]*ConfigProposal, error)
+	ConfigProposalsUpdate(p *ConfigProposal) error
+
+	ConfigsScheduleCreate(c *ScheduledConfig) (*ScheduledConfig, error)
+	ConfigsScheduled(app *App) ([]*ScheduledConfig, error)
+	ConfigsScheduledDue(now time.Time) ([]*ScheduledConfig, error)
+	ConfigsScheduledFind(id string) (*ScheduledConfig, error)
+	ConfigsScheduledApplied(id string, config *Config) error
+	ConfigsCancelScheduled(app *App, id string) error
+
+	ConfigGroupsCreate(group *ConfigGroup) (*ConfigGroup, error)
+	ConfigGroupsFirst(name string) (*ConfigGroup, error)
+	ConfigGroupsFind(id string) (*ConfigGroup, error)
+	ConfigGroupsForApp(app *App) (*ConfigGroup, error)
+	ConfigGroupsUpdate(group *ConfigGroup) error
+	ConfigGroupsSetParent(group, parent *ConfigGroup) error
+	ConfigGroupsAttach(app *App, group *ConfigGroup) error
+	ConfigGroupsDetach(app *App) error
+	AppsUsingGroup(groupID string) ([]string, error)
+}
+
+// sqlConfigsStore implements the configsStore interface with the postgres
+// backed store.
+type sqlConfigsStore struct {
+	*store
+}
+
+var _ configsStore = &sqlConfigsStore{}
+
+// Apps implements the configsStore interface.
+func (s *sqlConfigsStore) Apps(q AppsQuery) ([]*App, error) {
+	return s.store.Apps(q)
+}
+
+// AppsFirst implements the configsStore interface.
+func (s *sqlConfigsStore) AppsFirst(q AppsQuery) (*App, error) {
+	return s.store.AppsFirst(q)
+}
+
+// ReleasesFirst implements the configsStore interface.
+func (s *sqlConfigsStore) ReleasesFirst(q ReleasesQuery) (*Release, error) {
+	return s.store.ReleasesFirst(q)
+}
+
+// Configs implements the configsStore interface.
+func (s *sqlConfigsStore) Configs(q ConfigsQuery) ([]*Config, error) {
+	return s.store.Configs(q)
+}
+
+// ConfigsFirst implements the configsStore interface.
+func (s *sqlConfigsStore) ConfigsFirst(q ConfigsQuery) (*Config, error) {
+	return s.store.ConfigsFirst(q)
+}
+
+// ConfigsCreateWith implements the configsStore interface. fn is called with
+// a store that uses the transaction that the config is inserted in.
+func (s *sqlConfigsStore) ConfigsCreateWith(config *Config, fn func(tx configsStore) error) (*Config, error) {
+	var then func(*gorm.DB) error
+	if fn != nil {
+		then = func(db *gorm.DB) error {
+			tx := *s.store
+			tx.db = db
+			return fn(&sqlConfigsStore{&tx})
+		}
+	}
+
+	return s.store.ConfigsCreateWith(config, then)
+}
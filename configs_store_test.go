@@ -0,0 +1,83 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+// memoryConfigsStore is a configsStore that keeps configs in memory. Only the
+// methods needed to apply vars are implemented.
+type memoryConfigsStore struct {
+	configsStore
+
+	configs []*Config
+}
+
+func (s *memoryConfigsStore) AppsLock(app *App) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (s *memoryConfigsStore) ConfigLocksFind(app *App) (*ConfigLock, error) {
+	return nil, nil
+}
+
+func (s *memoryConfigsStore) ReleasesFirst(q ReleasesQuery) (*Release, error) {
+	return nil, gorm.RecordNotFound
+}
+
+func (s *memoryConfigsStore) ConfigsCurrent(app *App) (*Config, error) {
+	for i := len(s.configs) - 1; i >= 0; i-- {
+		if s.configs[i].AppID == app.ID {
+			return s.configs[i], nil
+		}
+	}
+
+	return nil, gorm.RecordNotFound
+}
+
+func (s *memoryConfigsStore) ConfigsCreate(config *Config) (*Config, error) {
+	return s.ConfigsCreateWith(config, nil)
+}
+
+func (s *memoryConfigsStore) ConfigsCreateWith(config *Config, fn func(configsStore) error) (*Config, error) {
+	if config.App != nil {
+		config.AppID = config.App.ID
+	}
+
+	if fn != nil {
+		if err := fn(s); err != nil {
+			return config, err
+		}
+	}
+
+	s.configs = append(s.configs, config)
+
+	return config, nil
+}
+
+func TestConfigsService_MemoryStore(t *testing.T) {
+	store := &memoryConfigsStore{}
+	s := &configsService{store: store, validators: DefaultValidators}
+	app := &App{ID: "1", Name: "acme-inc"}
+
+	if _, err := s.ConfigsApply(context.Background(), app, Vars{"RAILS_ENV": strptr("production")}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.ConfigsApply(context.Background(), app, Vars{"PORT": strptr("80")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Vars, (Vars{"RAILS_ENV": strptr("production"), "PORT": strptr("80")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	// An empty config is created the first time, before the vars are applied.
+	if got, want := len(store.configs), 3; got != want {
+		t.Fatalf("len(configs) => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package empire
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/remind101/pkg/timex"
+)
+
+// streamEntry is a single line of the stream written by WriteStream.
+type streamEntry struct {
+	Name  Variable `json:"name"`
+	Value *string  `json:"value"`
+}
+
+// WriteStream writes the variables in the config to w as newline delimited
+// JSON, with one {"name":...,"value":...} object per line, sorted by name.
+// Each variable is written as soon as it's encoded, rather than encoding the
+// whole config first, so clients can read them a line at a time. If redact is
+// true, the values of secret variables are replaced with ***.
+func (c *Config) WriteStream(w io.Writer, redact bool) error {
+	enc := json.NewEncoder(w)
+
+	for _, n := range c.Vars.Keys() {
+		v := c.Vars[n]
+		if redact && c.IsSecret(n) {
+			v = redactedValue(v)
+		}
+
+		if err := enc.Encode(&streamEntry{Name: n, Value: v}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currentVarsSQL selects each variable in the current config for an app,
+// sorted by name, along with its secret flag and expiration.
+const currentVarsSQL = `select e.key, e.value, c.secret_flags -> e.key, c.expires -> e.key
+from apps a
+join configs c on c.id = ` + currentConfigSQL + `
+cross join each(c.vars) e
+where a.id = ?
+order by e.key`
+
+// ConfigsEachCurrentVar calls fn with each variable in the current config for
+// the app, sorted by name, reading them a row at a time rather than loading
+// the whole config. Variables that expire have a non-nil expires. Nothing is
+// called if the app doesn't have a config.
+func (s *store) ConfigsEachCurrentVar(app *App, fn func(name Variable, value *string, flag SecretFlag, expires *time.Time) error) error {
+	rows, err := s.db.Raw(currentVarsSQL, app.ID).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name             string
+			value, flag, exp sql.NullString
+		)
+		if err := rows.Scan(&name, &value, &flag, &exp); err != nil {
+			return err
+		}
+
+		var v *string
+		if value.Valid {
+			v = &value.String
+		}
+
+		v, err := s.decryptValue(app.ID, v)
+		if err != nil {
+			return err
+		}
+
+		var expires *time.Time
+		if exp.Valid {
+			t, err := time.Parse(time.RFC3339Nano, exp.String)
+			if err != nil {
+				return err
+			}
+			expires = &t
+		}
+
+		if err := fn(Variable(name), v, SecretFlag(flag.String), expires); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ConfigsStreamCurrent writes the current config for the app to w in the same
+// format as WriteStream. Variables are read from the database and written a
+// row at a time, so the config is never held in memory as a whole. Expired
+// variables are not included.
+func (s *configsService) ConfigsStreamCurrent(app *App, w io.Writer, redact bool) error {
+	enc := json.NewEncoder(w)
+	now := timex.Now()
+
+	return s.store.ConfigsEachCurrentVar(app, func(name Variable, v *string, flag SecretFlag, expires *time.Time) error {
+		if expires != nil && !expires.After(now) {
+			return nil
+		}
+
+		if redact && isSecret(name, v, flag, DefaultSecretDetector) {
+			v = redactedValue(v)
+		}
+
+		return enc.Encode(&streamEntry{Name: name, Value: v})
+	})
+}
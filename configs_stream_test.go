@@ -0,0 +1,79 @@
+package empire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestConfig_WriteStream(t *testing.T) {
+	c := &Config{Vars: Vars{
+		"API_KEY":   strptr("secret"),
+		"RAILS_ENV": strptr("production"),
+	}}
+
+	buf := new(bytes.Buffer)
+	if err := c.WriteStream(buf, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"name":"API_KEY","value":"***"}
+{"name":"RAILS_ENV","value":"production"}
+`
+
+	if got, want := buf.String(), expected; got != want {
+		t.Fatalf("WriteStream => %q; want %q", got, want)
+	}
+}
+
+// maxWriteRecorder records the size of the largest single write.
+type maxWriteRecorder struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestConfig_WriteStream_Large(t *testing.T) {
+	const n = 5000
+
+	c := &Config{Vars: make(Vars, n)}
+	for i := 0; i < n; i++ {
+		c.Vars[Variable(fmt.Sprintf("VAR_%04d", i))] = strptr(fmt.Sprintf("value %d", i))
+	}
+
+	w := new(maxWriteRecorder)
+	if err := c.WriteStream(w, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write should only contain a single variable, rather than the
+	// whole config.
+	if w.max > 64 {
+		t.Fatalf("largest write => %d bytes; want at most 64", w.max)
+	}
+
+	scanner := bufio.NewScanner(&w.Buffer)
+	var lines int
+	for ; scanner.Scan(); lines++ {
+		var e streamEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := e.Name, Variable(fmt.Sprintf("VAR_%04d", lines)); got != want {
+			t.Fatalf("Name => %s; want %s", got, want)
+		}
+	}
+
+	if got, want := lines, n; got != want {
+		t.Fatalf("lines => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+// ErrSwapSameApp is returned when attempting to swap an apps config with
+// itself.
+var ErrSwapSameApp = errors.New("Cannot swap an apps config with itself.")
+
+// ConfigsCreateAll inserts the configs in a single transaction, so either all
+// of them are created or none of them are.
+func (s *store) ConfigsCreateAll(configs ...*Config) error {
+	for _, c := range configs {
+		restore, err := s.sealConfig(c)
+		defer restore()
+		if err != nil {
+			return err
+		}
+	}
+
+	return configsCreateAll(s.db, configs, s.notifyConfigChanges)
+}
+
+// configsCreateAll inserts the configs within a transaction, sending a
+// notification for each one if notify is true.
+func configsCreateAll(db *gorm.DB, configs []*Config, notify bool) error {
+	t := db.Begin()
+
+	for _, c := range configs {
+		if err := t.Create(c).Error; err != nil {
+			t.Rollback()
+			return err
+		}
+
+		if notify {
+			if err := notifyConfigChange(t, c); err != nil {
+				t.Rollback()
+				return err
+			}
+		}
+	}
+
+	if err := t.Commit().Error; err != nil {
+		t.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// ConfigsSwap gives each app a new config with the vars from the other apps
+// current config, along with their expirations and secret flags. Both configs
+// are created in a single transaction, so if either one fails validation or
+// can't be created, neither app is changed.
+//
+// Apps that have been released are then released with their new config, once
+// both apps are unlocked. The configs have already been swapped at that point,
+// so an error releasing one app doesn't undo the swap.
+func (s *configsService) ConfigsSwap(ctx context.Context, a, b *App) (*Config, *Config, error) {
+	if a.ID == b.ID {
+		return nil, nil, ErrSwapSameApp
+	}
+
+	ca, cb, releases, err := s.swap(ctx, a, b)
+	if err != nil {
+		return ca, cb, err
+	}
+
+	for _, r := range releases {
+		if err := s.submit(ctx, r); err != nil {
+			return ca, cb, err
+		}
+	}
+
+	return ca, cb, nil
+}
+
+// swap swaps the configs while holding the lock for both apps, returning the
+// releases that were created, which haven't been scheduled onto the cluster
+// yet.
+func (s *configsService) swap(ctx context.Context, a, b *App) (*Config, *Config, []*Release, error) {
+	// Always lock in the same order so that two concurrent swaps of the same
+	// apps can't deadlock.
+	first, second := a, b
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+
+	for _, app := range []*App{first, second} {
+		unlock, err := s.store.AppsLock(app)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer unlock()
+	}
+
+	for _, app := range []*App{a, b} {
+		if err := s.checkLock(app); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if err := s.checkReason(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+
+	oldA, err := s.current(a)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	oldB, err := s.current(b)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ca, err := s.swapped(ctx, a, oldA, oldB)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cb, err := s.swapped(ctx, b, oldB, oldA)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := s.store.ConfigsCreateAll(ca, cb); err != nil {
+		return nil, nil, nil, err
+	}
+
+	s.warn(a, ca)
+	s.warn(b, cb)
+
+	var releases []*Release
+	for _, c := range []struct {
+		app, other *App
+		config     *Config
+	}{{a, b, ca}, {b, a, cb}} {
+		r, err := s.release(c.app, c.config, fmt.Sprintf("Swap config with %s", c.other.Name))
+		if err != nil {
+			return ca, cb, releases, err
+		}
+
+		if r != nil {
+			releases = append(releases, r)
+		}
+	}
+
+	return ca, cb, releases, nil
+}
+
+// swapped authorizes and validates the config for the app, which replaces its
+// old config with the vars, expirations and secret flags of other, returning
+// the config to create.
+func (s *configsService) swapped(ctx context.Context, app *App, old, other *Config) (*Config, error) {
+	change := replacement(old, other)
+
+	if err := s.authorize(ctx, app, old, change.vars); err != nil {
+		return nil, err
+	}
+
+	c, errs := s.validateChange(app, old, change)
+	if len(errs) > 0 {
+		return nil, &ValidationError{Err: VarErrors(errs)}
+	}
+	c.Actor = actorFromContext(ctx)
+
+	s.warnRowSize(app, c)
+
+	return c, nil
+}
@@ -0,0 +1,54 @@
+package empire
+
+// ConfigTemplate is a named set of variables that app configs can be based on.
+type ConfigTemplate struct {
+	Name string
+	Vars Vars
+}
+
+// Source describes where the value of a variable in a config came from.
+type Source int
+
+const (
+	// SourceAppOnly means the variable isn't in the template, so it was set
+	// explicitly for the app.
+	SourceAppOnly Source = iota
+
+	// SourceTemplate means the variable has the same value as the template.
+	SourceTemplate
+
+	// SourceOverride means the variable is in the template, but the app
+	// overrides it with a different value.
+	SourceOverride
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceTemplate:
+		return "template"
+	case SourceOverride:
+		return "override"
+	default:
+		return "app"
+	}
+}
+
+// ProvenanceAgainst compares the config against the template, returning the
+// Source of every variable in the config.
+func (c *Config) ProvenanceAgainst(tmpl ConfigTemplate) map[Variable]Source {
+	p := make(map[Variable]Source, len(c.Vars))
+
+	for n, v := range c.Vars {
+		t, ok := tmpl.Vars[n]
+		switch {
+		case !ok || t == nil:
+			p[n] = SourceAppOnly
+		case varEqual(v, t):
+			p[n] = SourceTemplate
+		default:
+			p[n] = SourceOverride
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,35 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_ProvenanceAgainst(t *testing.T) {
+	tmpl := ConfigTemplate{
+		Name: "rails",
+		Vars: Vars{
+			"RAILS_ENV":  strptr("production"),
+			"LOG_LEVEL":  strptr("info"),
+			"WEB_CONCUR": strptr("2"),
+		},
+	}
+
+	c := &Config{
+		Vars: Vars{
+			"RAILS_ENV":    strptr("production"),
+			"LOG_LEVEL":    strptr("debug"),
+			"DATABASE_URL": strptr("postgres://localhost"),
+		},
+	}
+
+	expected := map[Variable]Source{
+		"RAILS_ENV":    SourceTemplate,
+		"LOG_LEVEL":    SourceOverride,
+		"DATABASE_URL": SourceAppOnly,
+	}
+
+	if got, want := c.ProvenanceAgainst(tmpl), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ProvenanceAgainst => %v; want %v", got, want)
+	}
+}
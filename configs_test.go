@@ -1,8 +1,15 @@
 package empire
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfigsQuery(t *testing.T) {
@@ -77,3 +84,762 @@ func TestMergeVars(t *testing.T) {
 		}
 	}
 }
+
+func TestVarEqual(t *testing.T) {
+	var (
+		a     = "a"
+		b     = "b"
+		other = "a"
+	)
+
+	tests := []struct {
+		a, b *string
+		out  bool
+	}{
+		{nil, nil, true},
+		{&a, nil, false},
+		{nil, &a, false},
+		{&a, &other, true},
+		{&a, &b, false},
+	}
+
+	for i, tt := range tests {
+		if got, want := varEqual(tt.a, tt.b), tt.out; got != want {
+			t.Errorf("#%d: varEqual => %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestConfig_WithDefaults(t *testing.T) {
+	var (
+		production = "production"
+		staging    = "staging"
+		empty      = ""
+		logLevel   = "info"
+	)
+
+	defaults := Vars{
+		"RAILS_ENV": &production,
+		"LOG_LEVEL": &logLevel,
+		"UNSET":     nil,
+	}
+
+	tests := []struct {
+		in  Vars
+		out Vars
+	}{
+		// Defaults are used when the config is empty.
+		{
+			Vars{},
+			Vars{
+				"RAILS_ENV": &production,
+				"LOG_LEVEL": &logLevel,
+			},
+		},
+
+		// Config takes precedence over defaults.
+		{
+			Vars{
+				"RAILS_ENV": &staging,
+			},
+			Vars{
+				"RAILS_ENV": &staging,
+				"LOG_LEVEL": &logLevel,
+			},
+		},
+
+		// An empty value is still an explicit override.
+		{
+			Vars{
+				"LOG_LEVEL": &empty,
+			},
+			Vars{
+				"RAILS_ENV": &production,
+				"LOG_LEVEL": &empty,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := &Config{Vars: tt.in}
+		v := c.WithDefaults(defaults)
+
+		if got, want := v, tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("WithDefaults => want %v; got %v", want, got)
+		}
+	}
+}
+
+func TestConfig_Env(t *testing.T) {
+	var (
+		production = "production"
+		staging    = "staging"
+		db         = "postgres://localhost"
+		cmd        = "true"
+	)
+
+	c := &Config{
+		Vars: Vars{
+			"RAILS_ENV":    &production,
+			"DATABASE_URL": &db,
+		},
+	}
+
+	env := c.Env(Vars{
+		"RAILS_ENV":    &staging,
+		"DATABASE_URL": nil,
+		"COMMAND":      &cmd,
+	})
+
+	expected := []string{
+		"COMMAND=true",
+		"RAILS_ENV=staging",
+	}
+
+	if got, want := env, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Env => %v; want %v", got, want)
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	b := &envBuilder{
+		defaults: Vars{"LOG_FORMAT": strptr("json")},
+	}
+
+	c := &Config{
+		Vars: Vars{
+			"DYNO":          strptr("web.1"),
+			"DATABASE_URL":  strptr("postgres://localhost"),
+			"RAILS_ENV":     strptr("production"),
+			"STATSD_PREFIX": strptr("${app.name}"),
+		},
+	}
+
+	env, err := processEnv(b, &App{Name: "acme-inc"}, c, "scheduler", Vars{
+		"DYNO":         strptr("scheduler.1"),
+		"DATABASE_URL": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"DYNO=scheduler.1",
+		"EMPIRE_PROCESS=scheduler",
+		"LOG_FORMAT=json",
+		"RAILS_ENV=production",
+		"STATSD_PREFIX=acme-inc",
+	}
+
+	if got, want := env, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("processEnv => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_ValueSizes(t *testing.T) {
+	c := &Config{
+		Vars: Vars{
+			"RAILS_ENV": strptr("production"),
+			"EMPTY":     strptr(""),
+			"EMOJI":     strptr("☃"),
+		},
+	}
+
+	expected := map[Variable]int{
+		"RAILS_ENV": 10,
+		"EMPTY":     0,
+		"EMOJI":     3,
+	}
+
+	if got, want := c.ValueSizes(), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ValueSizes => %v; want %v", got, want)
+	}
+}
+
+func TestNewVars(t *testing.T) {
+	vars, err := NewVars(map[string]string{
+		"RAILS_ENV": "production",
+		"_PRIVATE":  "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (Vars{"RAILS_ENV": strptr("production"), "_PRIVATE": strptr("")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("NewVars => %v; want %v", got, want)
+	}
+
+	_, err = NewVars(map[string]string{
+		"RAILS_ENV": "production",
+		"1FOO":      "foo",
+		"BAR-BAZ":   "bar",
+	})
+
+	expected := VarErrors{
+		&VarError{Name: "1FOO", Err: ErrInvalidVarName},
+		&VarError{Name: "BAR-BAZ", Err: ErrInvalidVarName},
+	}
+
+	if got, want := err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("err => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_Match(t *testing.T) {
+	c := &Config{
+		Vars: Vars{
+			"SMTP_HOST": strptr("smtp.example.com"),
+			"SMTP_PORT": strptr("25"),
+			"SMTPS":     strptr("true"),
+			"RAILS_ENV": strptr("production"),
+		},
+	}
+
+	tests := []struct {
+		pattern string
+		out     []Variable
+	}{
+		{"SMTP_*", []Variable{"SMTP_HOST", "SMTP_PORT"}},
+		{"SMTP?", []Variable{"SMTPS"}},
+		{"*_ENV", []Variable{"RAILS_ENV"}},
+		{"*", []Variable{"RAILS_ENV", "SMTPS", "SMTP_HOST", "SMTP_PORT"}},
+		{"SMTP", []Variable{}},
+		{"[", []Variable{}},
+	}
+
+	for _, tt := range tests {
+		if got, want := c.Match(tt.pattern).Keys(), tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("Match(%q) => %v; want %v", tt.pattern, got, want)
+		}
+	}
+}
+
+func TestGlobEscape(t *testing.T) {
+	c := &Config{Vars: Vars{"A*B": strptr(""), "AXB": strptr("")}}
+
+	if got, want := c.Match(globEscape("A*")+"*").Keys(), []Variable{"A*B"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Match => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_Fingerprint(t *testing.T) {
+	a := &Config{ID: "1", Vars: Vars{"RAILS_ENV": strptr("production"), "PORT": strptr("80")}}
+	b := &Config{ID: "2", Vars: Vars{"PORT": strptr("80"), "RAILS_ENV": strptr("production")}}
+	c := &Config{ID: "3", Vars: Vars{"PORT": strptr("8080"), "RAILS_ENV": strptr("production")}}
+
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Fatal("expected configs with the same vars to have the same fingerprint")
+	}
+
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Fatal("expected configs with different vars to have different fingerprints")
+	}
+
+	// Without expirations or flags, only the vars are hashed.
+	v, _ := a.Vars.Value()
+	sum := sha256.Sum256(v.([]byte))
+	if got, want := a.Fingerprint(), hex.EncodeToString(sum[:]); got != want {
+		t.Fatalf("Fingerprint => %s; want %s", got, want)
+	}
+
+	expires := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &Config{Vars: a.Vars, Expires: Expirations{"PORT": expires}}
+	e := &Config{Vars: a.Vars, Expires: Expirations{"PORT": expires.Add(time.Second)}}
+	f := &Config{Vars: a.Vars, SecretFlags: SecretFlags{"PORT": SecretFlagSecret}}
+	g := &Config{Vars: a.Vars, SecretFlags: SecretFlags{"PORT": SecretFlagPlain}}
+
+	fingerprints := map[string]bool{}
+	for _, c := range []*Config{a, d, e, f, g} {
+		fingerprints[c.Fingerprint()] = true
+	}
+
+	if got, want := len(fingerprints), 5; got != want {
+		t.Fatalf("expected configs with different expirations or flags to have different fingerprints, got %d distinct; want %d", got, want)
+	}
+
+	// Changing a flag out of band is caught.
+	fingerprint := f.Fingerprint()
+	f.VarsFingerprint = &fingerprint
+	f.SecretFlags["PORT"] = SecretFlagPlain
+	if got, want := f.checkIntegrity(), ErrConfigCorrupted; got != want {
+		t.Fatalf("checkIntegrity => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_CheckIntegrity(t *testing.T) {
+	c := &Config{Vars: Vars{"RAILS_ENV": strptr("production")}}
+
+	// Configs created before fingerprints were stored are assumed intact.
+	if err := c.checkIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.BeforeCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.checkIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Vars["RAILS_ENV"] = strptr("staging")
+	if got, want := c.checkIntegrity(), ErrConfigCorrupted; got != want {
+		t.Fatalf("checkIntegrity => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_RedactedForStorage(t *testing.T) {
+	c := &Config{
+		ID: "1234",
+		Vars: Vars{
+			"API_KEY":   strptr("s3cr3t"),
+			"RAILS_ENV": strptr("production"),
+		},
+	}
+
+	r := c.RedactedForStorage([]byte("key"))
+
+	if got, want := r.ID, c.ID; got != want {
+		t.Fatalf("ID => %v; want %v", got, want)
+	}
+
+	if got, want := *r.Vars["RAILS_ENV"], "production"; got != want {
+		t.Fatalf("RAILS_ENV => %v; want %v", got, want)
+	}
+
+	hashed := *r.Vars["API_KEY"]
+	if !strings.HasPrefix(hashed, "hmac-sha256:") || strings.Contains(hashed, "s3cr3t") {
+		t.Fatalf("API_KEY => %v; want a hash", hashed)
+	}
+
+	// Equal values hash the same, and different values don't.
+	if got := *c.RedactedForStorage([]byte("key")).Vars["API_KEY"]; got != hashed {
+		t.Fatalf("API_KEY => %v; want %v", got, hashed)
+	}
+
+	c.Vars["API_KEY"] = strptr("rotated")
+	if got := *c.RedactedForStorage([]byte("key")).Vars["API_KEY"]; got == hashed {
+		t.Fatal("expected a changed value to have a different hash")
+	}
+
+	if got, want := *c.Vars["RAILS_ENV"], "production"; got != want {
+		t.Fatalf("original RAILS_ENV => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_StoredSize(t *testing.T) {
+	c := &Config{Vars: Vars{"RAILS_ENV": strptr("production"), "PORT": strptr("80"), "EMPTY": strptr("")}}
+
+	size, err := c.StoredSize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := size, c.Size(); got != want || got != 30 {
+		t.Fatalf("StoredSize(nil) => %d; want %d", got, want)
+	}
+
+	// Simulates base64 encoded ciphertext with a 16 byte tag.
+	enc := encryptorFunc(func(plaintext []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(append(plaintext, make([]byte, 16)...))), nil
+	})
+
+	size, err = c.StoredSize(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// len("RAILS_ENV") + base64(26 bytes) + len("PORT") + base64(18 bytes)
+	// + len("EMPTY"), since empty values aren't encrypted.
+	if got, want := size, 9+36+4+24+5; got != want {
+		t.Fatalf("StoredSize => %d; want %d", got, want)
+	}
+}
+
+type encryptorFunc func([]byte) ([]byte, error)
+
+func (f encryptorFunc) Encrypt(plaintext []byte) ([]byte, error) {
+	return f(plaintext)
+}
+
+func TestConfig_Partition(t *testing.T) {
+	detector := func(name Variable, value string) bool {
+		return strings.HasPrefix(value, "sk_")
+	}
+
+	c := &Config{
+		Vars: Vars{
+			"STRIPE":    strptr("sk_live_1234"),
+			"API_KEY":   strptr("not secret to this detector"),
+			"RAILS_ENV": strptr("production"),
+		},
+	}
+
+	secret, plain := c.partition(detector)
+
+	if got, want := secret, (Vars{"STRIPE": strptr("sk_live_1234")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("secret => %v; want %v", got, want)
+	}
+
+	if got, want := plain, (Vars{"API_KEY": strptr("not secret to this detector"), "RAILS_ENV": strptr("production")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("plain => %v; want %v", got, want)
+	}
+
+	if got, want := len(c.Vars), 3; got != want {
+		t.Fatalf("len(c.Vars) => %d; want %d", got, want)
+	}
+}
+
+func TestConfig_Group(t *testing.T) {
+	c := &Config{
+		Vars: Vars{
+			"SMTP_HOST":     strptr("smtp.example.com"),
+			"SMTP_TLS_CERT": strptr("cert"),
+			"SMTPS":         strptr("true"),
+			"_PRIVATE":      strptr("1"),
+			"UNSET_VAR":     nil,
+		},
+	}
+
+	expected := map[string]Vars{
+		"SMTP": {
+			"SMTP_HOST":     strptr("smtp.example.com"),
+			"SMTP_TLS_CERT": strptr("cert"),
+		},
+		"UNSET": {"UNSET_VAR": nil},
+		"": {
+			"SMTPS":    strptr("true"),
+			"_PRIVATE": strptr("1"),
+		},
+	}
+
+	if got, want := c.Group("_"), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Group => %v; want %v", got, want)
+	}
+
+	if got, want := c.Group(""), (map[string]Vars{"": c.Vars}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Group => %v; want %v", got, want)
+	}
+
+	if got := (&Config{}).Group("_"); len(got) != 0 {
+		t.Fatalf("Group => %v; want none", got)
+	}
+}
+
+func TestStaleVars(t *testing.T) {
+	v := "value"
+
+	vars := Vars{
+		"OLD_API_KEY":  &v,
+		"API_KEY":      &v,
+		"DATABASE_URL": &v,
+		"UNUSED":       &v,
+	}
+
+	stale := staleVars(vars, []Variable{"API_KEY", "DATABASE_URL", "NOT_SET"})
+
+	if got, want := stale, []Variable{"OLD_API_KEY", "UNUSED"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("staleVars => %v; want %v", got, want)
+	}
+}
+
+func TestVars_String(t *testing.T) {
+	var (
+		production = "production"
+		secret     = "secret"
+	)
+
+	c := &Config{
+		ID: "1234",
+		Vars: Vars{
+			"RAILS_ENV": &production,
+			"API_KEY":   &secret,
+			"UNSET":     nil,
+		},
+	}
+
+	tests := []struct {
+		in  interface{}
+		out string
+	}{
+		{c.Vars, "{API_KEY=*** RAILS_ENV=*** UNSET=<unset>}"},
+		{c, "Config{ID:1234 AppID: Vars:{API_KEY=*** RAILS_ENV=*** UNSET=<unset>}}"},
+		{c.Unmasked(), "{API_KEY=secret RAILS_ENV=production UNSET=<unset>}"},
+	}
+
+	for i, tt := range tests {
+		if got, want := fmt.Sprintf("%v", tt.in), tt.out; got != want {
+			t.Errorf("#%d: String => %q; want %q", i, got, want)
+		}
+	}
+}
+
+func TestVarLastModified(t *testing.T) {
+	times := make([]time.Time, 4)
+	for i := range times {
+		times[i] = time.Date(2015, 1, i+1, 0, 0, 0, 0, time.UTC)
+	}
+
+	config := func(id string, day int, vars Vars) *Config {
+		return &Config{ID: id, CreatedAt: &times[day], Vars: vars}
+	}
+
+	// Newest first.
+	history := []*Config{
+		config("4", 3, Vars{"RAILS_ENV": strptr("production"), "API_KEY": strptr("new"), "ADDED": strptr("x")}),
+		config("3", 2, Vars{"RAILS_ENV": strptr("production"), "API_KEY": strptr("new")}),
+		config("2", 1, Vars{"RAILS_ENV": strptr("production"), "API_KEY": strptr("old")}),
+		config("1", 0, Vars{"RAILS_ENV": strptr("production"), "API_KEY": strptr("new")}),
+	}
+
+	expected := map[Variable]time.Time{
+		"RAILS_ENV": times[0],
+		"API_KEY":   times[2],
+		"ADDED":     times[3],
+	}
+
+	if got, want := varLastModified(history[0], history), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("varLastModified => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_MarshalJSON(t *testing.T) {
+	createdAt := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := &Config{
+		ID:        "1234",
+		AppID:     "4321",
+		CreatedAt: &createdAt,
+		Vars: Vars{
+			"RAILS_ENV":    strptr("production"),
+			"API_KEY":      strptr("secret"),
+			"DATABASE_URL": strptr("postgres://localhost"),
+			"EMPTY":        strptr(""),
+		},
+	}
+
+	expected := `{"id":"1234","app_id":"4321","created_at":"2015-01-01T00:00:00Z","vars":{"API_KEY":"secret","DATABASE_URL":"postgres://localhost","EMPTY":"","RAILS_ENV":"production"}}`
+
+	for i := 0; i < 10; i++ {
+		raw, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := string(raw), expected; got != want {
+			t.Fatalf("MarshalJSON => %s; want %s", got, want)
+		}
+	}
+
+	var got Config
+	if err := json.Unmarshal([]byte(expected), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&got, c) {
+		t.Fatalf("UnmarshalJSON => %v; want %v", got.Unmasked(), c.Unmasked())
+	}
+}
+
+func TestConfigsService_Validate(t *testing.T) {
+	s := &configsService{
+		validators: DefaultValidators,
+		maxVars:    func(*App) int { return 1 },
+	}
+
+	old := &Config{Vars: Vars{"FOO": strptr("foo")}}
+	c, errs := s.validate(&App{}, old, Vars{"1BAR": strptr("bar")})
+
+	if got, want := len(errs), 2; got != want {
+		t.Fatalf("len(errs) => %d; want %d (%v)", got, want, errs)
+	}
+	if _, ok := errs[1].(*TooManyVarsError); !ok {
+		t.Fatalf("errs[1] => %T; want *TooManyVarsError", errs[1])
+	}
+	if got, want := len(c.Vars), 2; got != want {
+		t.Fatalf("len(c.Vars) => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsService_Validate_Normalizers(t *testing.T) {
+	s := &configsService{
+		validators: DefaultValidators,
+		normalizers: map[Variable]func(string) string{
+			"DATABASE_URL": func(v string) string { return strings.TrimSuffix(v, "/") },
+			"DEBUG":        strings.ToLower,
+		},
+	}
+
+	// Stored before the normalizers existed.
+	old := &Config{Vars: Vars{"DATABASE_URL": strptr("postgres://localhost/")}}
+
+	c, errs := s.validate(&App{}, old, Vars{"DEBUG": strptr("TRUE"), "RAILS_ENV": strptr("Production")})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	expected := Vars{
+		"DATABASE_URL": strptr("postgres://localhost/"),
+		"DEBUG":        strptr("true"),
+		"RAILS_ENV":    strptr("Production"),
+	}
+
+	if got, want := c.Vars, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got.format(false), want.format(false))
+	}
+
+	c, _ = s.validate(&App{}, c, Vars{"DATABASE_URL": strptr("postgres://db/")})
+	if got, want := *c.Vars["DATABASE_URL"], "postgres://db"; got != want {
+		t.Fatalf("DATABASE_URL => %q; want %q", got, want)
+	}
+}
+
+func TestVars_ValueScan(t *testing.T) {
+	tests := []Vars{
+		{},
+		{"FOO": strptr("bar")},
+		{"EMPTY": strptr("")},
+		{"NULL": strptr("NULL")},
+		{"ARROWS": strptr("a=>b"), "COMMAS": strptr("a,b,,c")},
+		{"QUOTES": strptr(`say "hello"`), "SINGLE": strptr("it's")},
+		{"BACKSLASHES": strptr(`C:\path\to\"file"\`)},
+		{"NEWLINES": strptr("line 1\nline 2\r\n\ttabbed ")},
+		{"UNICODE": strptr("snowman ☃, ünïcödé, 日本語")},
+		{"HSTORE": strptr(`"a"=>"b", "c"=>NULL`)},
+		{"A": strptr(" "), "B": strptr(","), "C": strptr("=>"), "D": strptr(`\`)},
+	}
+
+	for i, vars := range tests {
+		v, err := vars.Value()
+		if err != nil {
+			t.Fatalf("#%d: Value => %v", i, err)
+		}
+
+		var got Vars
+		if err := got.Scan(v); err != nil {
+			t.Fatalf("#%d: Scan => %v", i, err)
+		}
+
+		if !reflect.DeepEqual(got, vars) {
+			t.Errorf("#%d: round trip => %#v; want %#v", i, got, vars)
+		}
+	}
+}
+
+func TestVars_Scan(t *testing.T) {
+	// This is how postgres formats hstore values.
+	src := []byte(`"FOO"=>"bar", "EMPTY"=>"", "QUOTE"=>"a \"b\"", "UNSET"=>NULL`)
+
+	var vars Vars
+	if err := vars.Scan(src); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Vars{
+		"FOO":   strptr("bar"),
+		"EMPTY": strptr(""),
+		"QUOTE": strptr(`a "b"`),
+		"UNSET": strptr(""),
+	}
+
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("Scan => %v; want %v", vars, expected)
+	}
+}
+
+func TestVarsUpdate_ValueScan(t *testing.T) {
+	update := VarsUpdate{"FOO": strptr("bar"), "UNSET": nil}
+
+	v, err := update.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got VarsUpdate
+	if err := got.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, update) {
+		t.Fatalf("round trip => %#v; want %#v", got, update)
+	}
+}
+
+func TestVars_Scan_LegacyJSON(t *testing.T) {
+	src := []byte(` {"RAILS_ENV": "production", "UNSET": null}`)
+
+	// JSON isn't mistaken for a corrupt hstore.
+	var vars Vars
+	if err := vars.Scan(src); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (Vars{"RAILS_ENV": strptr("production"), "UNSET": strptr("")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scan => %v; want %v", got, want)
+	}
+
+	var update VarsUpdate
+	if err := update.Scan(src); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := update, (VarsUpdate{"RAILS_ENV": strptr("production"), "UNSET": nil}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scan => %v; want %v", got, want)
+	}
+
+	// The converted vars scan as an hstore.
+	v, err := vars.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Vars
+	if err := got.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, vars) {
+		t.Fatalf("Scan => %v; want %v", got, vars)
+	}
+
+	if _, err := decodeLegacyJSONVars([]byte(`{"PORT": 80}`), false); err == nil {
+		t.Fatal("expected an error for non-string values")
+	}
+}
+
+func TestVars_Value_Stable(t *testing.T) {
+	a := make(Vars)
+	b := make(Vars)
+
+	names := []Variable{"RAILS_ENV", "DATABASE_URL", "PORT", "API_KEY", "DEBUG", "UNSET"}
+	for i, n := range names {
+		v := fmt.Sprintf("value %d", i)
+		a[n] = &v
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		v := fmt.Sprintf("value %d", i)
+		b[names[i]] = &v
+	}
+	a["UNSET"], b["UNSET"] = nil, nil
+
+	for i := 0; i < 10; i++ {
+		va, err := a.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vb, err := b.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(va, vb) {
+			t.Fatalf("Value => %s; want %s", vb, va)
+		}
+	}
+
+	v, _ := (Vars{"B": strptr(`"b"`), "A": strptr(`a\`)}).Value()
+	if got, want := string(v.([]byte)), `"A"=>"a\\","B"=>"\"b\""`; got != want {
+		t.Fatalf("Value => %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package empire
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyTransformer renames a variable when it's read for the environment of a
+// process. The stored config is left unchanged.
+type KeyTransformer func(Variable) Variable
+
+// StripPrefix returns a KeyTransformer that removes prefix from the names of
+// variables that start with it.
+func StripPrefix(prefix string) KeyTransformer {
+	return func(name Variable) Variable {
+		return Variable(strings.TrimPrefix(string(name), prefix))
+	}
+}
+
+// KeyCollisionError is returned when more than one variable is transformed to
+// the same name.
+type KeyCollisionError struct {
+	// The name that the variables were transformed to.
+	Name Variable
+
+	// The original names of the variables, sorted.
+	Keys []Variable
+}
+
+// Error implements the error interface.
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("variables %v all map to %s", e.Keys, e.Name)
+}
+
+// TransformKeys returns the vars within the config, with each name passed
+// through t. If t is nil, the vars are returned as is.
+func (c *Config) TransformKeys(t KeyTransformer) (Vars, error) {
+	if t == nil {
+		return c.Vars, nil
+	}
+
+	vars := make(Vars, len(c.Vars))
+	from := make(map[Variable]Variable, len(c.Vars))
+
+	for _, n := range c.Vars.Keys() {
+		name := t(n)
+		if k, ok := from[name]; ok {
+			return nil, &KeyCollisionError{Name: name, Keys: []Variable{k, n}}
+		}
+
+		from[name] = n
+		vars[name] = c.Vars[n]
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,36 @@
+package empire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_TransformKeys(t *testing.T) {
+	tests := []struct {
+		vars Vars
+		out  Vars
+		err  error
+	}{
+		{
+			Vars{"APP_DATABASE_URL": strptr("postgres://"), "PORT": strptr("80")},
+			Vars{"DATABASE_URL": strptr("postgres://"), "PORT": strptr("80")},
+			nil,
+		},
+		{
+			Vars{"APP_DATABASE_URL": strptr("a"), "DATABASE_URL": strptr("b")},
+			nil,
+			&KeyCollisionError{Name: "DATABASE_URL", Keys: []Variable{"APP_DATABASE_URL", "DATABASE_URL"}},
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := (&Config{Vars: tt.vars}).TransformKeys(StripPrefix("APP_"))
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Fatalf("err => %v; want %v", err, tt.err)
+		}
+
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Fatalf("TransformKeys => %v; want %v", out, tt.out)
+		}
+	}
+}
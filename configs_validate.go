@@ -0,0 +1,412 @@
+package empire
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidVarName is used to indicate that a variable name is not valid.
+	ErrInvalidVarName = errors.New("Variable names must start with a letter or underscore and contain only letters, numbers and underscores.")
+
+	// ErrInvalidUTF8 is used to indicate that a variable value is not valid
+	// UTF-8 text.
+	ErrInvalidUTF8 = errors.New("Variable values must be valid UTF-8.")
+
+	// ErrNULByte is used to indicate that a variable value contains a NUL
+	// byte, which can't be represented in a process environment.
+	ErrNULByte = errors.New("Variable values must not contain NUL bytes.")
+
+	// ErrHstoreUnsafe is used to indicate that a variable can't be stored in
+	// an hstore column and read back unchanged.
+	ErrHstoreUnsafe = errors.New("Variable can't be stored safely.")
+
+	// ErrSecurityDowngrade is used to indicate that a change would cause a
+	// secret variable to no longer be treated as a secret.
+	ErrSecurityDowngrade = errors.New("Variable would no longer be treated as a secret.")
+
+	// ErrEnvInjection is used to indicate that a variable value contains
+	// characters that could be used to inject additional variables when
+	// the config is serialized, like a newline followed by KEY=value.
+	ErrEnvInjection = errors.New("Variable values must not contain line breaks.")
+
+	// ErrHstoreTooLarge is used to indicate that a config would be larger
+	// than a column can hold.
+	ErrHstoreTooLarge = fmt.Errorf("A config can be at most %d bytes when it's stored.", MaxHstoreSize)
+
+	// ErrHstoreTooManyEntries is returned when a config would have more
+	// variables than an hstore can hold.
+	ErrHstoreTooManyEntries = fmt.Errorf("A config can have at most %d variables.", MaxHstoreEntries)
+)
+
+// The limits enforced by postgres on an hstore column. Exceeding them fails
+// with an opaque error from the database, so they're checked before a config
+// is stored. Keys and values aren't limited on their own, since hstore's limit
+// for each (HSTORE_MAX_KEY_LEN and HSTORE_MAX_VALUE_LEN) is the same as the
+// limit for the whole column.
+const (
+	// The largest value that a column can hold, which is MaxAllocSize, in
+	// bytes.
+	MaxHstoreSize = 0x3FFFFFFF
+
+	// The maximum number of pairs that hstore will parse, which is
+	// MaxAllocSize divided by the 40 byte size of each parsed pair.
+	MaxHstoreEntries = 0x3FFFFFFF / 40
+)
+
+// VarNamePattern is a regex pattern that variable names must conform to.
+var VarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validator validates a single variable before it's applied to a config. A nil
+// value means that the variable is being unset.
+type Validator interface {
+	Validate(name Variable, value *string) error
+}
+
+// ValidatorFunc is a function that implements the Validator interface.
+type ValidatorFunc func(Variable, *string) error
+
+// Validate implements the Validator interface.
+func (f ValidatorFunc) Validate(name Variable, value *string) error {
+	return f(name, value)
+}
+
+// ValidateVarName is a Validator that ensures that variable names match
+// VarNamePattern. Unsetting a variable is always allowed, so that invalid
+// variables can be removed.
+var ValidateVarName = ValidatorFunc(func(name Variable, value *string) error {
+	if value == nil {
+		return nil
+	}
+
+	if !VarNamePattern.MatchString(string(name)) {
+		return &VarError{Name: name, Err: ErrInvalidVarName}
+	}
+
+	return nil
+})
+
+// ValidateVarValue is a Validator that ensures that variable values are valid
+// UTF-8 text without any NUL bytes.
+var ValidateVarValue = ValidatorFunc(func(name Variable, value *string) error {
+	if value == nil {
+		return nil
+	}
+
+	if strings.IndexByte(*value, 0) >= 0 {
+		return &VarError{Name: name, Err: ErrNULByte}
+	}
+
+	if !utf8.ValidString(*value) {
+		return &VarError{Name: name, Err: ErrInvalidUTF8}
+	}
+
+	return nil
+})
+
+// hstoreUnsafe are sequences that aren't allowed in variable names, since they
+// have special meaning in the hstore format.
+var hstoreUnsafe = []string{"=>", `"`, `\`}
+
+// ValidateHstore is a Validator that ensures that a variable can be stored in
+// an hstore column and read back unchanged. Names containing hstore syntax are
+// rejected outright, and the variable is round tripped through the hstore
+// encoding to verify that it reads back the same. It's one of the
+// storageValidators, so it's always run before vars are stored.
+var ValidateHstore = ValidatorFunc(func(name Variable, value *string) error {
+	if value == nil {
+		return nil
+	}
+
+	for _, u := range hstoreUnsafe {
+		if strings.Contains(string(name), u) {
+			return &VarError{Name: name, Err: ErrHstoreUnsafe}
+		}
+	}
+
+	v, err := Vars{name: value}.Value()
+	if err != nil {
+		return &VarError{Name: name, Err: err}
+	}
+
+	var vars Vars
+	if err := vars.Scan(v); err != nil {
+		return &VarError{Name: name, Err: err}
+	}
+
+	if got, ok := vars[name]; !ok || len(vars) != 1 || !varEqual(got, value) {
+		return &VarError{Name: name, Err: ErrHstoreUnsafe}
+	}
+
+	return nil
+})
+
+// envDelimiters are characters that separate variables in common environment
+// formats, like .env files and /proc/<pid>/environ. This is deliberately broad,
+// so that a value is flagged if any downstream serializer might split on it.
+const envDelimiters = "\n\r\x00\v\f\u0085\u2028\u2029"
+
+// ValidateNoEnvInjection is a Validator that rejects values containing any of
+// the envDelimiters. It's not one of the DefaultValidators, since multiline
+// values, like PEM encoded certificates, are legitimate, so it has to be added
+// to the validators explicitly.
+var ValidateNoEnvInjection = ValidatorFunc(func(name Variable, value *string) error {
+	if value != nil && strings.ContainsAny(*value, envDelimiters) {
+		return &VarError{Name: name, Err: ErrEnvInjection}
+	}
+
+	return nil
+})
+
+// ValidateAllowedValues returns a Validator that ensures that the variables in
+// allowed are only set to one of their allowed values, like ENVIRONMENT being
+// one of dev, staging or prod. Variables without an entry can be set to
+// anything, and unsetting a variable is always allowed.
+func ValidateAllowedValues(allowed map[Variable][]string) Validator {
+	return ValidatorFunc(func(name Variable, value *string) error {
+		values, ok := allowed[name]
+		if !ok || value == nil {
+			return nil
+		}
+
+		for _, v := range values {
+			if *value == v {
+				return nil
+			}
+		}
+
+		return &VarError{Name: name, Err: &NotAllowedValueError{Value: *value, Allowed: values}}
+	})
+}
+
+// DetectInjection returns the sorted names of the variables whose values
+// contain characters that could be abused to inject additional variables by a
+// naive serializer. See ValidateNoEnvInjection.
+func (c *Config) DetectInjection() []Variable {
+	var names []Variable
+
+	for _, n := range c.Vars.Keys() {
+		if err := ValidateNoEnvInjection(n, c.Vars[n]); err != nil {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+// DefaultValidators are the validators that are used when applying new config
+// vars, unless ConfigsOptions.Validators is set, and that can be passed to
+// ImportAndValidate to check a file before it's applied.
+var DefaultValidators = []Validator{
+	ValidateVarName,
+	ValidateVarValue,
+}
+
+// TooManyVarsError is returned when applying vars would leave an app with more
+// variables than it's allowed.
+type TooManyVarsError struct {
+	// The number of variables the config would have.
+	Count int
+
+	// The maximum number of variables allowed.
+	Max int
+}
+
+func (e *TooManyVarsError) Error() string {
+	return fmt.Sprintf("Too many config vars: %d exceeds the maximum of %d.", e.Count, e.Max)
+}
+
+// NotAllowedValueError is returned when a variable is set to a value that isn't
+// one of its allowed values.
+type NotAllowedValueError struct {
+	// The value that the variable was set to.
+	Value string
+
+	// The values that the variable can be set to.
+	Allowed []string
+}
+
+func (e *NotAllowedValueError) Error() string {
+	return fmt.Sprintf("%q is not allowed, must be one of: %s.", e.Value, strings.Join(e.Allowed, ", "))
+}
+
+// checkMaxVars returns a TooManyVarsError if new has more than max variables.
+// A max of 0 means there's no limit. Changes that don't increase the number of
+// variables are always allowed, so an app that's over the limit can always
+// remove variables.
+func checkMaxVars(old, new Vars, max int) error {
+	if max <= 0 || len(new) <= max || len(new) <= len(old) {
+		return nil
+	}
+
+	return &TooManyVarsError{Count: len(new), Max: max}
+}
+
+// checkHstoreLimits returns an error if the config has more than maxEntries
+// variables, or if its estimated row size, with the values encrypted with enc,
+// is over maxSize bytes. enc can be nil if values aren't encrypted.
+func checkHstoreLimits(c *Config, enc Encryptor, maxSize, maxEntries int) []error {
+	var errs []error
+
+	if len(c.Vars) > maxEntries {
+		errs = append(errs, ErrHstoreTooManyEntries)
+	}
+
+	size, err := c.StoredSize(enc)
+	if err != nil {
+		return append(errs, err)
+	}
+
+	if c.estimatedRowSize(size) > maxSize {
+		errs = append(errs, ErrHstoreTooLarge)
+	}
+
+	return errs
+}
+
+// checkSecurityDowngrade returns an error for each variable that's a secret in
+// old, but wouldn't be in new, classifying variables without a secret flag with
+// detect. Removing a secret variable isn't a downgrade, but explicitly marking
+// it as SecretFlagPlain is.
+func checkSecurityDowngrade(old, new *Config, detect SecretDetector) []error {
+	var errs []error
+
+	for _, name := range new.Vars.Keys() {
+		if old.isSecretWith(name, detect) && !new.isSecretWith(name, detect) {
+			errs = append(errs, &VarError{Name: name, Err: ErrSecurityDowngrade})
+		}
+	}
+
+	return errs
+}
+
+// Warning is a problem with a config that's not severe enough to prevent it
+// from being used.
+type Warning struct {
+	// The variable the warning is about, if any.
+	Name Variable
+
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Name == "" {
+		return w.Message
+	}
+
+	return fmt.Sprintf("%s: %s", w.Name, w.Message)
+}
+
+// deprecationWarnings returns a Warning for each deprecated variable that's
+// set, sorted by variable name.
+func deprecationWarnings(vars Vars, deprecated map[Variable]string) []Warning {
+	var warnings []Warning
+
+	for _, name := range vars.Keys() {
+		if msg, ok := deprecated[name]; ok {
+			warnings = append(warnings, Warning{
+				Name:    name,
+				Message: fmt.Sprintf("deprecated: %s", msg),
+			})
+		}
+	}
+
+	return warnings
+}
+
+// The number of variables that are named in a row size warning.
+const rowSizeWarningVars = 3
+
+// rowSizeWarnings returns a Warning if the estimated row size of the config is
+// over threshold bytes, naming the largest variables, so that they can be
+// trimmed before the config gets big enough to cause problems.
+func rowSizeWarnings(c *Config, threshold int) []Warning {
+	size := c.EstimatedRowSize()
+	if size <= threshold {
+		return nil
+	}
+
+	sizes := c.ValueSizes()
+	names := c.Vars.Keys()
+	sort.Stable(varsBySize{names, sizes})
+
+	if len(names) > rowSizeWarningVars {
+		names = names[:rowSizeWarningVars]
+	}
+
+	largest := make([]string, 0, len(names))
+	for _, n := range names {
+		largest = append(largest, fmt.Sprintf("%s (%d bytes)", n, len(n)+sizes[n]))
+	}
+
+	return []Warning{{
+		Message: fmt.Sprintf("config is approximately %d bytes when stored, which is over the warning threshold of %d bytes. The largest variables are %s.", size, threshold, strings.Join(largest, ", ")),
+	}}
+}
+
+// varsBySize implements sort.Interface to sort variable names by the size of
+// their name and value, largest first.
+type varsBySize struct {
+	names []Variable
+	sizes map[Variable]int
+}
+
+func (s varsBySize) Len() int { return len(s.names) }
+func (s varsBySize) Less(i, j int) bool {
+	return len(s.names[i])+s.sizes[s.names[i]] > len(s.names[j])+s.sizes[s.names[j]]
+}
+func (s varsBySize) Swap(i, j int) { s.names[i], s.names[j] = s.names[j], s.names[i] }
+
+// VarError is an error associated with a specific variable.
+type VarError struct {
+	Name Variable
+	Err  error
+}
+
+func (e *VarError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Err)
+}
+
+// VarErrors is a collection of errors for multiple variables.
+type VarErrors []error
+
+func (e VarErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// storageValidators are always run before vars are stored, after any
+// configured validators, since a config that can't be read back unchanged is
+// corrupt no matter what the validators allow.
+var storageValidators = []Validator{
+	ValidateHstore,
+}
+
+// validateForStorage is like validateVars, but also runs the
+// storageValidators.
+func validateForStorage(vars Vars, validators []Validator) []error {
+	return validateVars(vars, append(append([]Validator{}, validators...), storageValidators...))
+}
+
+// validateVars runs every validator against every variable, returning all of
+// the errors that occurred, ordered by variable name.
+func validateVars(vars Vars, validators []Validator) []error {
+	var errs []error
+
+	for _, name := range vars.Keys() {
+		for _, v := range validators {
+			if err := v.Validate(name, vars[name]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
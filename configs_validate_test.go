@@ -0,0 +1,263 @@
+package empire
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckMaxVars(t *testing.T) {
+	v := "value"
+
+	vars := func(n int) Vars {
+		vars := make(Vars)
+		for i := 0; i < n; i++ {
+			vars[Variable(string(rune('A'+i)))] = &v
+		}
+		return vars
+	}
+
+	tests := []struct {
+		old, new int
+		max      int
+		err      error
+	}{
+		// No limit.
+		{0, 10, 0, nil},
+
+		// Under and at the limit.
+		{1, 2, 3, nil},
+		{2, 3, 3, nil},
+
+		// Over the limit.
+		{3, 4, 3, &TooManyVarsError{Count: 4, Max: 3}},
+
+		// Already over the limit, removing vars.
+		{5, 4, 3, nil},
+
+		// Already over the limit, changing a var.
+		{5, 5, 3, nil},
+	}
+
+	for i, tt := range tests {
+		err := checkMaxVars(vars(tt.old), vars(tt.new), tt.max)
+
+		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: checkMaxVars => %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestValidateVarValue(t *testing.T) {
+	tests := []struct {
+		value *string
+		err   error
+	}{
+		{nil, nil},
+		{strptr("production"), nil},
+		{strptr("héllo wörld ✓ 日本"), nil},
+		{strptr("line1\nline2"), nil},
+		{strptr("nul\x00byte"), &VarError{Name: "FOO", Err: ErrNULByte}},
+		{strptr("bad\xffutf8"), &VarError{Name: "FOO", Err: ErrInvalidUTF8}},
+	}
+
+	for i, tt := range tests {
+		err := ValidateVarValue.Validate("FOO", tt.value)
+
+		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: Validate => %v; want %v", i, got, want)
+		}
+	}
+}
+
+func strptr(s string) *string {
+	return &s
+}
+
+func TestDeprecationWarnings(t *testing.T) {
+	vars := Vars{
+		"RAILS_ENV":    strptr("production"),
+		"REDIS_URL":    strptr("redis://localhost"),
+		"MEMCACHE_URL": strptr("memcache://localhost"),
+	}
+
+	deprecated := map[Variable]string{
+		"REDIS_URL":    "use REDIS_ADDR",
+		"MEMCACHE_URL": "memcache is going away",
+		"NOT_SET":      "not set",
+	}
+
+	expected := []Warning{
+		{Name: "MEMCACHE_URL", Message: "deprecated: memcache is going away"},
+		{Name: "REDIS_URL", Message: "deprecated: use REDIS_ADDR"},
+	}
+
+	if got, want := deprecationWarnings(vars, deprecated), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("deprecationWarnings => %v; want %v", got, want)
+	}
+}
+
+func TestValidateHstore(t *testing.T) {
+	tests := []struct {
+		name  Variable
+		value *string
+		err   error
+	}{
+		{"FOO", nil, nil},
+		{"FOO", strptr("bar"), nil},
+		{"FOO", strptr(`a "quoted" \\ value, with => arrows`), nil},
+		{"FOO", strptr("multi\nline ✓"), nil},
+		{`FOO=>BAR`, strptr("bar"), &VarError{Name: `FOO=>BAR`, Err: ErrHstoreUnsafe}},
+		{`FOO"`, strptr("bar"), &VarError{Name: `FOO"`, Err: ErrHstoreUnsafe}},
+		{`FOO\`, strptr("bar"), &VarError{Name: `FOO\`, Err: ErrHstoreUnsafe}},
+	}
+
+	for i, tt := range tests {
+		err := ValidateHstore.Validate(tt.name, tt.value)
+
+		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: Validate => %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestCheckSecurityDowngrade(t *testing.T) {
+	detect := func(name Variable, value string) bool {
+		return strings.HasPrefix(value, "secret:")
+	}
+
+	old := &Config{Vars: Vars{
+		"A": strptr("secret:a"),
+		"B": strptr("secret:b"),
+		"C": strptr("plain"),
+		"D": strptr("secret:d"),
+	}}
+	new := &Config{Vars: Vars{
+		"A": strptr("plain"),
+		"B": strptr("secret:rotated"),
+		"C": strptr("secret:c"),
+	}}
+
+	expected := []error{&VarError{Name: "A", Err: ErrSecurityDowngrade}}
+	if got, want := checkSecurityDowngrade(old, new, detect), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkSecurityDowngrade => %v; want %v", got, want)
+	}
+}
+
+func TestConfig_DetectInjection(t *testing.T) {
+	c := &Config{
+		Vars: Vars{
+			"INJECTED":  strptr("value\nEVIL=1"),
+			"CARRIAGE":  strptr("value\rEVIL=1"),
+			"SEPARATOR": strptr("value\u2028EVIL=1"),
+			"RAILS_ENV": strptr("production"),
+			"EQUALS":    strptr("a=b"),
+			"UNSET":     nil,
+		},
+	}
+
+	if got, want := c.DetectInjection(), []Variable{"CARRIAGE", "INJECTED", "SEPARATOR"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DetectInjection => %v; want %v", got, want)
+	}
+}
+
+func TestValidateAllowedValues(t *testing.T) {
+	allowed := []string{"dev", "staging", "prod"}
+	v := ValidateAllowedValues(map[Variable][]string{"ENVIRONMENT": allowed})
+
+	tests := []struct {
+		name  Variable
+		value *string
+		err   error
+	}{
+		{"ENVIRONMENT", strptr("prod"), nil},
+		{"ENVIRONMENT", nil, nil},
+		{"RAILS_ENV", strptr("prduction"), nil},
+		{"ENVIRONMENT", strptr("prduction"), &VarError{Name: "ENVIRONMENT", Err: &NotAllowedValueError{Value: "prduction", Allowed: allowed}}},
+		{"ENVIRONMENT", strptr("Prod"), &VarError{Name: "ENVIRONMENT", Err: &NotAllowedValueError{Value: "Prod", Allowed: allowed}}},
+	}
+
+	for i, tt := range tests {
+		if got, want := v.Validate(tt.name, tt.value), tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: Validate => %v; want %v", i, got, want)
+		}
+	}
+
+	err := v.Validate("ENVIRONMENT", strptr("prduction"))
+	if got, want := err.Error(), `ENVIRONMENT: "prduction" is not allowed, must be one of: dev, staging, prod.`; got != want {
+		t.Fatalf("Error => %q; want %q", got, want)
+	}
+}
+
+func TestCheckHstoreLimits(t *testing.T) {
+	config := func(n int, value string) *Config {
+		vars := Vars{"KEY": &value}
+		for i := 1; i < n; i++ {
+			vars[Variable(string(rune('A'+i)))] = strptr("")
+		}
+		return &Config{Vars: vars}
+	}
+
+	// The limit is the size of a config with 3 variables, one of which is
+	// 5 bytes.
+	max := config(3, "value").EstimatedRowSize()
+
+	tests := []struct {
+		config *Config
+		errs   []error
+	}{
+		// At the limits.
+		{config(3, "value"), nil},
+		{config(3, "val"), nil},
+
+		// Over the limits.
+		{config(3, "values"), []error{ErrHstoreTooLarge}},
+		{config(4, ""), []error{ErrHstoreTooManyEntries, ErrHstoreTooLarge}},
+
+		// Expirations and secret flags are stored too.
+		{&Config{Vars: config(3, "value").Vars, SecretFlags: SecretFlags{"KEY": SecretFlagSecret}}, []error{ErrHstoreTooLarge}},
+	}
+
+	for i, tt := range tests {
+		if got, want := checkHstoreLimits(tt.config, nil, max, 3), tt.errs; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: checkHstoreLimits => %v; want %v", i, got, want)
+		}
+	}
+
+	// A config that's within the limits in plaintext can be over them once
+	// it's encrypted.
+	enc := encryptorFunc(func(plaintext []byte) ([]byte, error) {
+		return append(plaintext, make([]byte, 16)...), nil
+	})
+
+	if got, want := checkHstoreLimits(config(3, "val"), enc, max, 3), []error{ErrHstoreTooLarge}; !reflect.DeepEqual(got, want) {
+		t.Errorf("checkHstoreLimits => %v; want %v", got, want)
+	}
+}
+
+func TestRowSizeWarnings(t *testing.T) {
+	c := &Config{Vars: Vars{
+		"CERT":      strptr(strings.Repeat("x", 1000)),
+		"BUNDLE":    strptr(strings.Repeat("x", 500)),
+		"KEY":       strptr(strings.Repeat("x", 200)),
+		"RAILS_ENV": strptr("production"),
+	}}
+
+	size := c.EstimatedRowSize()
+	if size <= c.Size() {
+		t.Fatalf("EstimatedRowSize => %d; want more than the size of the vars (%d)", size, c.Size())
+	}
+
+	if warnings := rowSizeWarnings(c, size); len(warnings) != 0 {
+		t.Fatalf("rowSizeWarnings => %v; want none", warnings)
+	}
+
+	expected := []Warning{{
+		Message: fmt.Sprintf("config is approximately %d bytes when stored, which is over the warning threshold of 1024 bytes. The largest variables are CERT (1004 bytes), BUNDLE (506 bytes), KEY (203 bytes).", size),
+	}}
+
+	if got, want := rowSizeWarnings(c, 1024), expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rowSizeWarnings => %v; want %v", got, want)
+	}
+}
@@ -1,8 +1,10 @@
 package empire // import "github.com/remind101/empire"
 
 import (
+	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/fsouza/go-dockerclient"
@@ -70,11 +72,174 @@ type ELBOptions struct {
 	InternalZoneID string
 }
 
+// ConfigsOptions is a set of options to configure how app configs are managed.
+type ConfigsOptions struct {
+	// Platform provided variables that are added to the environment of every
+	// process. Variables set in an app's config take precedence.
+	Defaults Vars
+
+	// Used to resolve values that reference secrets stored outside of
+	// Empire, like vault://secret/app#KEY, when building the environment for
+	// a process.
+	SecretResolvers SecretResolvers
+
+	// If provided, values that reference a variable in another apps config,
+	// like @app:api/PUBLIC_URL, are replaced with its current value when
+	// the environment for a process is built, as long as the
+	// AppRefAuthorizer allows it. If the other app or variable doesn't
+	// exist, or the reference isn't allowed, the environment can't be
+	// built. By default, references are treated as literal values.
+	AppRefAuthorizer AppRefAuthorizer
+
+	// If true, values that reference an unknown attribute of the app, like
+	// ${app.nope}, fail to build the environment for a process. By default,
+	// unknown references are left as is.
+	StrictInterpolation bool
+
+	// If true, vars are interpolated and any references to external secrets
+	// are resolved with SecretResolvers before they're applied, so that a
+	// value that can't be resolved is rejected when it's set, rather than
+	// when a process is started.
+	ResolveCheck bool
+
+	// If true, config changes that are applied, like with ConfigsApply,
+	// have to be made with a reason, set on the context with WithReason,
+	// or they're rejected with ErrReasonRequired. Automated changes can use
+	// a sentinel reason, like "system:rotation".
+	RequireReason bool
+
+	// Validators to run against vars before they're applied. The zero value
+	// uses DefaultValidators.
+	Validators []Validator
+
+	// If true, a postgres NOTIFY will be sent whenever a new config is
+	// created, so that other Empire instances can learn about the change
+	// through ConfigsListenChanges.
+	NotifyChanges bool
+
+	// If true, whenever a config is read, its vars, expirations and secret
+	// flags are checked against the fingerprint that was stored when it was
+	// created, and ErrConfigCorrupted is returned if they don't match. This
+	// catches corruption and out of band edits, at the cost of hashing every
+	// config that's read.
+	VerifyIntegrity bool
+
+	// The maximum number of variables an app can have. The zero value means
+	// there's no limit.
+	MaxVars int
+
+	// If provided, this is called to determine the maximum number of
+	// variables for a specific app, overriding MaxVars.
+	MaxVarsFunc func(*App) int
+
+	// Variables that are deprecated platform wide, mapped to a message
+	// explaining what to use instead. Deprecated variables can still be
+	// used, but a warning will be produced whenever they're set, or the config
+	// that sets them is read.
+	DeprecatedVars map[Variable]string
+
+	// If provided, this is called with any warnings about an apps config. The
+	// default is to log them.
+	WarningHandler func(*App, []Warning)
+
+	// A warning is produced when a config that's about to be stored has an
+	// estimated row size, from Config.EstimatedRowSize, over this many
+	// bytes, so that it can be trimmed before it causes problems with the
+	// database. The zero value uses DefaultRowSizeWarning, and a negative
+	// value disables the warning.
+	RowSizeWarning int
+
+	// If provided, variable names are passed through this when building
+	// the environment for a process, without changing what's stored. This
+	// is useful when migrating away from a naming scheme, like StripPrefix
+	// for a legacy prefix.
+	KeyTransformer KeyTransformer
+
+	// If true, changes that cause a secret variable, as classified by
+	// DefaultSecretDetector, to no longer be treated as a secret are
+	// allowed. By default, they're rejected with ErrSecurityDowngrade.
+	AllowSecurityDowngrade bool
+
+	// Functions to rewrite the values of specific variables into a
+	// canonical form, like removing the trailing slash from DATABASE_URL,
+	// before they're stored. Values are only normalized when they're set,
+	// so existing values are left as is until they're set again.
+	Normalizers map[Variable]func(string) string
+
+	// If provided, this is called to determine the team that owns each
+	// variable in an apps config, for variables that weren't given an
+	// owner with ConfigsSetOwner. Variables without an owner can be
+	// changed by anyone.
+	VarOwners func(*App) map[Variable]string
+
+	// If provided, changes to the values of variables that are owned by a
+	// team, as set with ConfigsSetOwner or determined by VarOwners, are
+	// rejected with ErrUnauthorizedVar unless the VarAuthorizer allows the
+	// user making the change to make them. Reading configs isn't
+	// restricted.
+	VarAuthorizer VarAuthorizer
+
+	// If provided, vars are checked against each Renderer, like
+	// RenderDocker, before they're applied, so that a value that can't be
+	// exported, like a multiline value in a docker --env-file, is rejected
+	// with a RenderError when it's set, rather than when it's exported.
+	RenderCheck []Renderer
+
+	// Variables that can only be set to one of a fixed set of values, like
+	// ENVIRONMENT being one of dev, staging or prod. Setting one of them to
+	// anything else is rejected with a NotAllowedValueError, including by
+	// ConfigsValidate. Variables without an entry are unconstrained.
+	AllowedValues map[Variable][]string
+
+	// If provided, variables are classified when they're set, and the ones
+	// that look like secrets, like DefaultSecretClassifier's high entropy
+	// tokens, are flagged as secrets automatically. A variable is only
+	// classified once, and operators can override the classification with
+	// ConfigsMarkSecret, although marking a secret as plain also needs
+	// AllowSecurityDowngrade.
+	SecretClassifier SecretClassifier
+
+	// If non-zero, reads and writes of configs that take longer than this
+	// are logged, along with the app and how long they took.
+	SlowThreshold time.Duration
+
+	// The number of apps that ConfigsPruneAll prunes with each statement.
+	// The default is DefaultPruneBatchSize.
+	PruneBatchSize int
+
+	// If provided, config values are encrypted at rest with envelope
+	// encryption: each app gets its own random data key, which is stored
+	// wrapped by the first master key. The other master keys are only used
+	// to unwrap data keys that haven't been moved to the first one with
+	// ConfigsRewrapDataKeys, which makes rotating the master key cheap.
+	// Values that were stored before encryption was enabled are still read
+	// as plaintext, and are encrypted the next time the config changes.
+	//
+	// Queries that compare values in the database, like
+	// ConfigsAppsMissingVar, only see the ciphertext, so they can only tell
+	// whether a value is empty. Config groups aren't owned by a single app,
+	// so their vars aren't encrypted.
+	MasterKeys []MasterKey
+
+	// If provided, this is used for the data key of each app instead of
+	// the keys wrapped by MasterKeys, like to get them from an external
+	// key management service.
+	KeyProvider KeyProvider
+}
+
+// DefaultRowSizeWarning is the estimated row size, in bytes, over which a
+// warning is produced when a config is stored. Large values are compressed and
+// moved out of line by postgres, so this isn't a hard limit, but configs this
+// big are slow to read and write, and are usually a sign that something, like
+// a certificate bundle, should be stored elsewhere.
+const DefaultRowSizeWarning = 1 << 20
+
 // Options is provided to New to configure the Empire services.
 type Options struct {
-	Docker DockerOptions
-	ECS    ECSOptions
-	ELB    ELBOptions
+	Docker  DockerOptions
+	ECS     ECSOptions
+	ELB     ELBOptions
+	Configs ConfigsOptions
 
 	// AWS Configuration
 	AWSConfig *aws.Config
@@ -116,7 +281,23 @@ func New(options Options) (*Empire, error) {
 		return nil, err
 	}
 
-	store := &store{db: db}
+	logger := newLogger()
+
+	store := &store{
+		db:                  db,
+		url:                 options.DB,
+		notifyConfigChanges: options.Configs.NotifyChanges,
+		slowThreshold:       options.Configs.SlowThreshold,
+		logger:              logger,
+		verifyConfigs:       options.Configs.VerifyIntegrity,
+		pruneBatchSize:      options.Configs.PruneBatchSize,
+	}
+
+	if keys := options.Configs.KeyProvider; keys != nil {
+		store.keys = keys
+	} else if masters := options.Configs.MasterKeys; len(masters) > 0 {
+		store.keys = newEnvelopeKeys(db, masters)
+	}
 
 	extractor, err := newExtractor(options.Docker)
 	if err != nil {
@@ -161,9 +342,27 @@ func New(options Options) (*Empire, error) {
 		manager: manager,
 	}
 
+	env := &envBuilder{
+		defaults:      options.Configs.Defaults,
+		resolvers:     options.Configs.SecretResolvers,
+		transformKeys: options.Configs.KeyTransformer,
+
+		strictInterpolation: options.Configs.StrictInterpolation,
+	}
+
+	if authorizer := options.Configs.AppRefAuthorizer; authorizer != nil {
+		env.appRefs = &appRefResolver{
+			lookup:     storeAppConfigs(store),
+			authorizer: authorizer,
+
+			strictInterpolation: options.Configs.StrictInterpolation,
+		}
+	}
+
 	releaser := &releaser{
 		store:   store,
 		manager: manager,
+		env:     env,
 	}
 
 	restarter := &restarter{
@@ -176,9 +375,60 @@ func New(options Options) (*Empire, error) {
 		releaser: releaser,
 	}
 
+	validators := options.Configs.Validators
+	if validators == nil {
+		validators = DefaultValidators
+	}
+
+	if allowed := options.Configs.AllowedValues; len(allowed) > 0 {
+		validators = append(append([]Validator{}, validators...), ValidateAllowedValues(allowed))
+	}
+
+	maxVars := options.Configs.MaxVarsFunc
+	if maxVars == nil && options.Configs.MaxVars > 0 {
+		maxVars = func(*App) int { return options.Configs.MaxVars }
+	}
+
+	handleWarnings := options.Configs.WarningHandler
+	if handleWarnings == nil {
+		handleWarnings = func(app *App, warnings []Warning) {
+			for _, w := range warnings {
+				logger.Warn("config warning", "app", app.Name, "warning", w)
+			}
+		}
+	}
+
+	rowSizeWarning := options.Configs.RowSizeWarning
+	if rowSizeWarning == 0 {
+		rowSizeWarning = DefaultRowSizeWarning
+	}
+
 	configs := &configsService{
-		store:    store,
-		releases: releases,
+		store:          &sqlConfigsStore{store},
+		releases:       releases,
+		validators:     validators,
+		maxVars:        maxVars,
+		encryptor:      store.ConfigsEncryptor,
+		deprecatedVars: options.Configs.DeprecatedVars,
+		handleWarnings: handleWarnings,
+		resolvers:      options.Configs.SecretResolvers,
+
+		allowSecurityDowngrade: options.Configs.AllowSecurityDowngrade,
+
+		normalizers: options.Configs.Normalizers,
+
+		resolveCheck:        options.Configs.ResolveCheck,
+		requireReason:       options.Configs.RequireReason,
+		strictInterpolation: options.Configs.StrictInterpolation,
+
+		varOwners:  options.Configs.VarOwners,
+		authorizer: options.Configs.VarAuthorizer,
+		classifier: options.Configs.SecretClassifier,
+		renderers:  options.Configs.RenderCheck,
+
+		rowSizeWarning: rowSizeWarning,
+
+		env: env,
 	}
 
 	domains := &domainsService{
@@ -205,7 +455,7 @@ func New(options Options) (*Empire, error) {
 	}
 
 	return &Empire{
-		Logger:       newLogger(),
+		Logger:       logger,
 		store:        store,
 		accessTokens: accessTokens,
 		apps:         apps,
@@ -219,6 +469,7 @@ func New(options Options) (*Empire, error) {
 		runner: &runnerService{
 			store:   store,
 			manager: manager,
+			env:     env,
 		},
 		releases: releases,
 	}, nil
@@ -286,6 +537,455 @@ func (e *Empire) ConfigsApply(ctx context.Context, app *App, vars Vars) (*Config
 	return e.configs.ConfigsApply(ctx, app, vars)
 }
 
+// ConfigsValidate returns every reason that applying vars to the apps current
+// Config would fail validation, without changing anything. If no errors are
+// returned, ConfigsApply will pass validation with the same vars.
+func (e *Empire) ConfigsValidate(app *App, vars Vars) ([]error, error) {
+	return e.configs.ConfigsValidate(app, vars)
+}
+
+// ConfigsCompareAndSet sets a single variable on the apps current Config, only
+// if its current value matches the expected value. A nil expected value means
+// the variable is expected to be unset.
+func (e *Empire) ConfigsCompareAndSet(ctx context.Context, app *App, name Variable, expected, value *string) (*Config, error) {
+	return e.configs.ConfigsCompareAndSet(ctx, app, name, expected, value)
+}
+
+// ConfigsCurrentKeys returns the sorted names of the variables in the apps
+// current Config, without fetching their values. Expired variables are not
+// included.
+func (e *Empire) ConfigsCurrentKeys(app *App) ([]Variable, error) {
+	return e.store.ConfigsCurrentKeys(app)
+}
+
+// ConfigsCurrentVars returns only the named variables from the apps current
+// Config. Variables that aren't set are absent from the result. Services that
+// only need a few variables should prefer this to ConfigsCurrent.
+func (e *Empire) ConfigsCurrentVars(app *App, names []Variable) (Vars, error) {
+	return e.store.ConfigsCurrentVars(app, names)
+}
+
+// ConfigsCurrentMeta returns the names, sizes and secret flags of the variables
+// in the apps current Config, without fetching their values. API list views
+// should prefer this to ConfigsCurrent, which is only needed when the values
+// are, like when building the environment for a process.
+func (e *Empire) ConfigsCurrentMeta(app *App) (*ConfigMeta, error) {
+	return e.store.ConfigsCurrentMeta(app)
+}
+
+// ConfigsCurrentForApps returns the current Config for each of the apps, keyed
+// by app name. Apps without a Config are not included.
+func (e *Empire) ConfigsCurrentForApps(apps []*App) (map[string]*Config, error) {
+	return e.store.ConfigsCurrentForApps(apps)
+}
+
+// ConfigsEffectiveEnv returns the sorted KEY=value environment that a process
+// using the given Config would run with when it was released, after applying
+// defaults and extra overrides.
+func (e *Empire) ConfigsEffectiveEnv(id string, defaults, extra Vars) ([]string, error) {
+	return e.configs.ConfigsEffectiveEnv(id, defaults, extra)
+}
+
+// ConfigsProcessEnv returns the sorted KEY=value environment that a process of
+// the given type would run with using the Config with the given id, with
+// overrides taking precedence over the Config.
+func (e *Empire) ConfigsProcessEnv(id, processType string, overrides Vars) ([]string, error) {
+	return e.configs.ConfigsProcessEnv(id, processType, overrides)
+}
+
+// ConfigsVarLastModified returns the time that each variable in the apps
+// current Config was last changed. This walks the apps entire config history.
+func (e *Empire) ConfigsVarLastModified(app *App) (map[Variable]time.Time, error) {
+	return e.configs.ConfigsVarLastModified(app)
+}
+
+// ConfigsStaleVars returns the variables in the apps current config that
+// aren't in the provided list of variables that are known to be used.
+func (e *Empire) ConfigsStaleVars(app *App, used []Variable) ([]Variable, error) {
+	return e.configs.ConfigsStaleVars(app, used)
+}
+
+// ConfigsUnsetPrefix unsets every variable whose name starts with prefix from
+// the apps current Config, returning the new Config and the removed variables.
+func (e *Empire) ConfigsUnsetPrefix(ctx context.Context, app *App, prefix Variable) (*Config, []Variable, error) {
+	return e.configs.ConfigsUnsetPrefix(ctx, app, prefix)
+}
+
+// ConfigsRestoreSnapshot replaces the apps Config with the one in a snapshot
+// written by Config.WriteSnapshot, including its expirations and secret flags.
+// Unless force is true, the snapshot must have been taken from the same app.
+func (e *Empire) ConfigsRestoreSnapshot(ctx context.Context, app *App, r io.Reader, force bool) (*Config, error) {
+	return e.configs.ConfigsRestoreSnapshot(ctx, app, r, force)
+}
+
+// ConfigsStats returns the number of variables and total size of the current
+// Config for every app.
+func (e *Empire) ConfigsStats() ([]*ConfigStats, error) {
+	return e.store.ConfigsStats()
+}
+
+// ConfigsSwap atomically swaps the vars of two apps' current Configs, returning
+// the new Config for each app.
+func (e *Empire) ConfigsSwap(ctx context.Context, a, b *App) (*Config, *Config, error) {
+	return e.configs.ConfigsSwap(ctx, a, b)
+}
+
+// ConfigsSchedule schedules vars to be merged into the apps current Config,
+// and the app released, at the given time.
+func (e *Empire) ConfigsSchedule(ctx context.Context, app *App, vars Vars, at time.Time) (*ScheduledConfig, error) {
+	return e.configs.ConfigsSchedule(ctx, app, vars, at)
+}
+
+// ConfigsApplyScheduled applies the scheduled config changes that have come
+// due, returning the new Configs, and the error for each change that couldn't
+// be applied.
+func (e *Empire) ConfigsApplyScheduled(ctx context.Context) ([]*Config, map[string]error, error) {
+	return e.configs.ConfigsApplyScheduled(ctx)
+}
+
+// RunConfigsScheduler calls ConfigsApplyScheduled and ConfigsPruneAllExpired
+// every interval, until the context is cancelled. Errors are logged, and an
+// error applying scheduled changes doesn't stop expired vars being pruned.
+func (e *Empire) RunConfigsScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, failed, err := e.ConfigsApplyScheduled(ctx)
+			if err != nil {
+				e.Logger.Error("applying scheduled configs", "err", err)
+			}
+
+			for id, err := range failed {
+				e.Logger.Warn("scheduled config not applied", "id", id, "err", err)
+			}
+
+			_, failed, err = e.ConfigsPruneAllExpired(ctx)
+			if err != nil {
+				e.Logger.Error("pruning expired config vars", "err", err)
+			}
+
+			for app, err := range failed {
+				e.Logger.Warn("expired config vars not pruned", "app", app, "err", err)
+			}
+		}
+	}
+}
+
+// ConfigsCancelScheduled cancels a config change created with ConfigsSchedule
+// that hasn't been applied yet.
+func (e *Empire) ConfigsCancelScheduled(app *App, id string) error {
+	return e.configs.ConfigsCancelScheduled(app, id)
+}
+
+// ConfigsReconcile applies the changes needed to make the apps Config match
+// desired, returning the new Config and the changes that were made.
+func (e *Empire) ConfigsReconcile(ctx context.Context, app *App, desired Vars) (*Config, ConfigDiff, error) {
+	return e.configs.ConfigsReconcile(ctx, app, desired)
+}
+
+// ConfigsCompare returns the differences between the current Configs for a
+// and b, relative to b.
+func (e *Empire) ConfigsCompare(a, b *App) (ConfigDiff, error) {
+	return e.configs.ConfigsCompare(a, b)
+}
+
+// ConfigsScanAll runs check against the current Config for every app, returning
+// any errors it returns, keyed by app name. This is useful for finding apps
+// that would fail a new validation before it's enforced.
+func (e *Empire) ConfigsScanAll(check func(app string, c *Config) []error) (map[string][]error, error) {
+	return e.store.ConfigsScanAll(check)
+}
+
+// ConfigsSetIfAbsent sets only the variables that aren't already set in the
+// apps current Config, returning the new Config and the variables that were
+// added.
+func (e *Empire) ConfigsSetIfAbsent(ctx context.Context, app *App, vars Vars) (*Config, []Variable, error) {
+	return e.configs.ConfigsSetIfAbsent(ctx, app, vars)
+}
+
+// ConfigsSizeStats summarizes the sizes of the current Configs across all apps,
+// including how many apps have more than threshold variables.
+func (e *Empire) ConfigsSizeStats(threshold int) (*SizeStats, error) {
+	return e.store.ConfigsSizeStats(threshold)
+}
+
+// ConfigsPruneAll deletes all but the keep newest Configs for every app,
+// returning the number deleted for each app. An apps current Config is never
+// deleted.
+func (e *Empire) ConfigsPruneAll(keep int) (map[string]int, error) {
+	return e.store.ConfigsPruneAll(keep)
+}
+
+// ConfigsFindReusedSecrets finds secret values that are used by more than one
+// app, keyed by a hash of the value, along with the names of the apps that use
+// them.
+func (e *Empire) ConfigsFindReusedSecrets() (map[string][]string, error) {
+	return e.store.ConfigsFindReusedSecrets()
+}
+
+// ConfigsBackfillOrdering repairs Configs without a created_at, so that the
+// latest Config for every app follows insertion order, returning the number of
+// Configs that were repaired.
+func (e *Empire) ConfigsBackfillOrdering() (int, error) {
+	return e.store.ConfigsBackfillOrdering()
+}
+
+// ConfigsConvertLegacyJSON rewrites Configs whose vars were stored as a JSON
+// object by old installs in the hstore format, returning the number of Configs
+// that were converted. Configs stored as JSON can still be read, so this is
+// optional.
+func (e *Empire) ConfigsConvertLegacyJSON() (int, error) {
+	return e.store.ConfigsConvertLegacyJSON()
+}
+
+// ConfigsAppsMissingVar returns the names of the apps whose current Config
+// doesn't have a value for the variable, including apps without a Config.
+func (e *Empire) ConfigsAppsMissingVar(name Variable) ([]string, error) {
+	return e.store.ConfigsAppsMissingVar(name)
+}
+
+// ConfigsVarPrevalence returns the number of apps whose current Config contains
+// each variable, limited to the limit most common variables if limit is
+// greater than 0.
+func (e *Empire) ConfigsVarPrevalence(limit int) (map[Variable]int, error) {
+	return e.store.ConfigsVarPrevalence(limit)
+}
+
+// ConfigsPropose stores vars as a pending change to the apps Config, which has
+// to be approved with ConfigsApprove before it takes effect.
+func (e *Empire) ConfigsPropose(app *App, vars Vars, requester string) (*ConfigProposal, error) {
+	return e.configs.ConfigsPropose(app, vars, requester)
+}
+
+// ConfigsApprove applies a pending ConfigProposal, returning the new Config.
+func (e *Empire) ConfigsApprove(ctx context.Context, id, approver string) (*Config, error) {
+	return e.configs.ConfigsApprove(ctx, id, approver)
+}
+
+// ConfigsReject rejects a pending ConfigProposal.
+func (e *Empire) ConfigsReject(id, reason string) error {
+	return e.configs.ConfigsReject(id, reason)
+}
+
+// ConfigsPending returns the changes to the apps Config that are scheduled, or
+// waiting to be approved.
+func (e *Empire) ConfigsPending(app *App) ([]PendingChange, error) {
+	return e.configs.ConfigsPending(app)
+}
+
+// ConfigsRotateVar sets the variable to value on every one of the apps that
+// has it, returning the new Configs and the errors for any apps that couldn't
+// be changed.
+func (e *Empire) ConfigsRotateVar(ctx context.Context, apps []*App, name Variable, value string) ([]*Config, map[*App]error, error) {
+	return e.configs.ConfigsRotateVar(ctx, apps, name, value)
+}
+
+// ConfigsEffectiveEnvWithProvenance returns the environment that a process
+// using the Config would run with, along with where each value came from. If
+// redact is true, secret values are masked.
+func (e *Empire) ConfigsEffectiveEnvWithProvenance(id string, defaults, extra Vars, redact bool) (map[Variable]EnvEntry, error) {
+	return e.configs.ConfigsEffectiveEnvWithProvenance(id, defaults, extra, redact)
+}
+
+// ConfigsVerify returns ErrStaleConfig, along with the current Config, if the
+// fingerprint doesn't match the apps current Config.
+func (e *Empire) ConfigsVerify(app *App, fingerprint string) (*Config, error) {
+	return e.configs.ConfigsVerify(app, fingerprint)
+}
+
+// ConfigsLock locks the apps Config, so that any attempt to change it fails
+// until it's unlocked.
+func (e *Empire) ConfigsLock(app *App, reason string) error {
+	return e.configs.ConfigsLock(app, reason)
+}
+
+// ConfigsUnlock unlocks the apps Config.
+func (e *Empire) ConfigsUnlock(app *App) error {
+	return e.configs.ConfigsUnlock(app)
+}
+
+// ConfigsListenChanges returns a channel that receives a ConfigChange whenever
+// a new config is created by any Empire instance sharing the database. The
+// channel is closed when the context is cancelled.
+func (e *Empire) ConfigsListenChanges(ctx context.Context) (<-chan ConfigChange, error) {
+	return e.store.ConfigsListenChanges(ctx)
+}
+
+// ConfigsSetWithTTL sets the variable on the apps current Config, and expires
+// it after ttl. Expired variables are never added to the environment of a
+// process.
+func (e *Empire) ConfigsSetWithTTL(ctx context.Context, app *App, name Variable, value string, ttl time.Duration) (*Config, error) {
+	return e.configs.ConfigsSetWithTTL(ctx, app, name, value, ttl)
+}
+
+// ConfigsPruneAllExpired removes the variables that have expired from every
+// apps current Config, releasing each app that changed.
+func (e *Empire) ConfigsPruneAllExpired(ctx context.Context) (map[string][]Variable, map[string]error, error) {
+	return e.configs.ConfigsPruneAllExpired(ctx)
+}
+
+// ConfigsPruneExpired removes the variables that have expired from the apps
+// current Config, returning the new Config and the variables that were
+// removed.
+func (e *Empire) ConfigsPruneExpired(ctx context.Context, app *App) (*Config, []Variable, error) {
+	return e.configs.ConfigsPruneExpired(ctx, app)
+}
+
+// ConfigsRewriteHistoryVarName renames a variable in every Config the app has
+// ever had, returning the number of Configs that were changed. This is
+// destructive: history is mutated in place and can't be restored.
+func (e *Empire) ConfigsRewriteHistoryVarName(ctx context.Context, app *App, from, to Variable) (int, error) {
+	return e.configs.ConfigsRewriteHistoryVarName(ctx, app, from, to)
+}
+
+// ConfigsCoalesceHistory removes Configs from the apps history that are
+// identical to the Config before them, returning the number that were removed.
+func (e *Empire) ConfigsCoalesceHistory(app *App) (int, error) {
+	return e.configs.ConfigsCoalesceHistory(app)
+}
+
+// ConfigsRewrapDataKeys rewraps the data key of every app with the first of
+// the MasterKeys, returning the number of keys that were rewrapped. Config
+// values aren't re-encrypted, so this is cheap enough to run whenever the
+// master key is rotated. ErrNoMasterKeys is returned if MasterKeys aren't
+// being used.
+func (e *Empire) ConfigsRewrapDataKeys() (int, error) {
+	return e.store.ConfigsRewrapDataKeys()
+}
+
+// ConfigsFreeze records the apps current Config under an immutable name,
+// returning the id of the Config.
+func (e *Empire) ConfigsFreeze(app *App, name string) (string, error) {
+	return e.configs.ConfigsFreeze(app, name)
+}
+
+// ConfigsResolveFrozen returns the Config that was frozen under name for the
+// app.
+func (e *Empire) ConfigsResolveFrozen(app *App, name string) (*Config, error) {
+	return e.configs.ConfigsResolveFrozen(app, name)
+}
+
+// ConfigsOwners returns the team that owns each variable in the apps Config.
+func (e *Empire) ConfigsOwners(app *App) (map[Variable]string, error) {
+	return e.configs.ConfigsOwners(app)
+}
+
+// ConfigsSetOwner sets the team that owns the variable in the apps Config, or
+// removes the owner if team is empty.
+func (e *Empire) ConfigsSetOwner(ctx context.Context, app *App, name Variable, team string) error {
+	return e.configs.ConfigsSetOwner(ctx, app, name, team)
+}
+
+// ConfigGroupsCreate creates a ConfigGroup with the vars, which apps can be
+// attached to with ConfigsAttachGroup.
+func (e *Empire) ConfigGroupsCreate(name string, vars Vars) (*ConfigGroup, error) {
+	return e.configs.ConfigGroupsCreate(name, vars)
+}
+
+// ConfigGroupsSet merges vars into the vars of the ConfigGroup, sending a
+// ConfigChange for every app that uses it.
+func (e *Empire) ConfigGroupsSet(name string, vars Vars) (*ConfigGroup, error) {
+	return e.configs.ConfigGroupsSet(name, vars)
+}
+
+// ConfigGroupsSetParent makes the ConfigGroup inherit the vars of the parent
+// ConfigGroup, or stop inheriting if parent is empty. An InheritanceCycleError
+// is returned if the parent already inherits from the group.
+func (e *Empire) ConfigGroupsSetParent(name, parent string) error {
+	return e.configs.ConfigGroupsSetParent(name, parent)
+}
+
+// ConfigsAttachGroup attaches the app to the ConfigGroup with the name.
+func (e *Empire) ConfigsAttachGroup(app *App, name string) error {
+	return e.configs.ConfigsAttachGroup(app, name)
+}
+
+// ConfigsDetachGroup detaches the app from its ConfigGroup, if it has one.
+func (e *Empire) ConfigsDetachGroup(app *App) error {
+	return e.configs.ConfigsDetachGroup(app)
+}
+
+// AppsUsingGroup returns the sorted names of the apps that are attached to the
+// ConfigGroup with the id, or to a ConfigGroup that inherits from it.
+func (e *Empire) AppsUsingGroup(groupID string) ([]string, error) {
+	return e.configs.AppsUsingGroup(groupID)
+}
+
+// ConfigsEffective returns the vars of the apps current Config, with the vars
+// that it inherits from its ConfigGroup added for any variables that the Config
+// doesn't set.
+func (e *Empire) ConfigsEffective(app *App) (Vars, error) {
+	return e.configs.ConfigsEffective(app)
+}
+
+// ConfigsTagAll tags the current Config of every app with label, returning the
+// ids of the tagged Configs keyed by app name.
+func (e *Empire) ConfigsTagAll(label string) (map[string]string, error) {
+	return e.configs.ConfigsTagAll(label)
+}
+
+// ConfigsRollbackAllToTag restores every app that was tagged with label to its
+// tagged Config.
+func (e *Empire) ConfigsRollbackAllToTag(ctx context.Context, label string) (map[string]*Config, error) {
+	return e.configs.ConfigsRollbackAllToTag(ctx, label)
+}
+
+// ConfigsFindForRelease returns the Config that the release with the given id
+// was created with. ErrReleaseNotFound and ErrConfigNotFound distinguish a
+// missing release from a missing Config.
+func (e *Empire) ConfigsFindForRelease(releaseID string) (*Config, error) {
+	return e.configs.ConfigsFindForRelease(releaseID)
+}
+
+// ConfigsHistoryDiffs returns the change made by each Config in the apps
+// history, newest first.
+func (e *Empire) ConfigsHistoryDiffs(app *App, opts ConfigsHistoryDiffsOpts) ([]VersionedDiff, error) {
+	return e.configs.ConfigsHistoryDiffs(app, opts)
+}
+
+// ConfigsLineage returns the history of the apps Configs as a graph, with the
+// parent of each Config, the Config it restored, if any, and the names it was
+// frozen under.
+func (e *Empire) ConfigsLineage(app *App) (*LineageGraph, error) {
+	return e.configs.ConfigsLineage(app)
+}
+
+// ConfigsStreamCurrent writes the current Config for the app to w as newline
+// delimited JSON, one variable per line, without loading the whole Config.
+func (e *Empire) ConfigsStreamCurrent(app *App, w io.Writer, redact bool) error {
+	return e.configs.ConfigsStreamCurrent(app, w, redact)
+}
+
+// ConfigsHistoryFor returns the Configs in the apps history where the value
+// of the variable changed, newest first.
+func (e *Empire) ConfigsHistoryFor(app *App, name Variable, opts ConfigsHistoryForOpts) ([]*Config, error) {
+	return e.configs.ConfigsHistoryFor(app, name, opts)
+}
+
+// ConfigsApplyClassified is like ConfigsApply, but also returns the variables
+// that were automatically classified as secrets.
+func (e *Empire) ConfigsApplyClassified(ctx context.Context, app *App, vars Vars) (*Config, []SecretClassification, error) {
+	return e.configs.ConfigsApplyClassified(ctx, app, vars)
+}
+
+// ConfigsMarkSecret explicitly marks the variable in the apps Config as a
+// secret, or as not being a secret.
+func (e *Empire) ConfigsMarkSecret(ctx context.Context, app *App, name Variable, secret bool) (*Config, error) {
+	return e.configs.ConfigsMarkSecret(ctx, app, name, secret)
+}
+
+// ConfigsFollow returns a channel that receives a ConfigChangeEvent, with the
+// changes redacted, whenever the apps current Config changes. The channel is
+// closed when the context is cancelled.
+func (e *Empire) ConfigsFollow(ctx context.Context, app *App) (<-chan ConfigChangeEvent, error) {
+	return e.configs.ConfigsFollow(ctx, app)
+}
+
 // DomainsFirst returns the first domain matching the query.
 func (e *Empire) DomainsFirst(q DomainsQuery) (*Domain, error) {
 	return e.store.DomainsFirst(q)
@@ -383,6 +1083,9 @@ type key int
 
 const (
 	UserKey key = 0
+
+	// ReasonKey is used to store the reason for a config change.
+	ReasonKey key = 1
 )
 
 func newManager(r *runner.Runner, ecsOpts ECSOptions, elbOpts ELBOptions, config *aws.Config) (service.Manager, error) {
@@ -0,0 +1,63 @@
+package empiretest
+
+import (
+	"sort"
+
+	"github.com/jinzhu/gorm"
+	"github.com/remind101/empire"
+	"golang.org/x/net/context"
+)
+
+// SeedConfigs sets the current config of each app to exactly the given vars,
+// creating the apps if they don't already exist. Apps are seeded in order of
+// their name, so the resulting history is reproducible.
+func SeedConfigs(e *empire.Empire, fixtures map[string]empire.Vars) error {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		app, err := findOrCreateApp(e, name)
+		if err != nil {
+			return err
+		}
+
+		c, err := e.ConfigsCurrent(app)
+		if err != nil {
+			return err
+		}
+
+		vars := make(empire.Vars)
+		for k, v := range fixtures[name] {
+			vars[k] = v
+		}
+
+		// Unset anything that's not in the fixture.
+		for k := range c.Vars {
+			if _, ok := vars[k]; !ok {
+				vars[k] = nil
+			}
+		}
+
+		if _, err := e.ConfigsApply(context.Background(), app, vars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findOrCreateApp(e *empire.Empire, name string) (*empire.App, error) {
+	app, err := e.AppsFirst(empire.AppsQuery{Name: &name})
+	if err == nil {
+		return app, nil
+	}
+
+	if err != gorm.RecordNotFound {
+		return nil, err
+	}
+
+	return e.AppsCreate(&empire.App{Name: name})
+}
@@ -0,0 +1,251 @@
+package empiretest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/remind101/empire"
+)
+
+// UpdateGolden can be set with -update-golden to rewrite the golden files
+// from the current results, rather than comparing against them.
+var UpdateGolden = flag.Bool("update-golden", false, "Rewrite golden files with the current results")
+
+// The operations that can be used as a ScenarioStep.
+const (
+	// OpApply merges the vars into the current config, like ConfigsApply.
+	OpApply = "apply"
+
+	// OpUnset removes the named variables from the current config.
+	OpUnset = "unset"
+
+	// OpReplace makes the current config exactly the given vars, unsetting
+	// everything else.
+	OpReplace = "replace"
+)
+
+// Scenario is a sequence of config changes, read from a JSON file, like:
+//
+//	{
+//	  "description": "Unsetting a var that isn't set is a no-op",
+//	  "initial": {"RAILS_ENV": "production"},
+//	  "steps": [
+//	    {"op": "unset", "names": ["MISSING"]},
+//	    {"op": "apply", "vars": {"EMPTY": ""}}
+//	  ]
+//	}
+type Scenario struct {
+	Description string         `json:"description"`
+	Initial     empire.Vars    `json:"initial"`
+	Steps       []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is a single change within a Scenario.
+type ScenarioStep struct {
+	// One of OpApply, OpUnset or OpReplace.
+	Op string `json:"op"`
+
+	// The vars for OpApply and OpReplace. A null value unsets the
+	// variable.
+	Vars empire.Vars `json:"vars,omitempty"`
+
+	// The names of the variables for OpUnset.
+	Names []empire.Variable `json:"names,omitempty"`
+}
+
+// ScenarioResult is the outcome of running a Scenario, which is what's
+// compared against the golden file.
+type ScenarioResult struct {
+	// The vars in the current config after every step.
+	Vars empire.Vars `json:"vars"`
+
+	// The number of configs in the history, including the initial config.
+	Versions int `json:"versions"`
+
+	// The error returned by each step, or an empty string if it succeeded.
+	Errors []string `json:"errors"`
+}
+
+// MergeHarness is an in-memory history of an apps configs, which merges
+// changes with empire.NewConfig and runs Validators against them. It locks
+// down the merge semantics without needing a database, but it doesn't go
+// through the configs service, so locking, authorization, size limits and
+// everything else the service does around a change isn't covered.
+type MergeHarness struct {
+	// Validators to run against vars before they're applied. The zero value
+	// uses empire.DefaultValidators.
+	Validators []empire.Validator
+
+	// The configs that have been created, oldest first.
+	History []*empire.Config
+}
+
+// Head returns the current config, which is empty if nothing has been applied.
+func (m *MergeHarness) Head() *empire.Config {
+	if len(m.History) == 0 {
+		return &empire.Config{Vars: make(empire.Vars)}
+	}
+
+	return m.History[len(m.History)-1]
+}
+
+// Apply validates vars and merges them into the current config, adding a new
+// config to the history. If any var is invalid, nothing is changed and an
+// empire.VarErrors is returned.
+func (m *MergeHarness) Apply(vars empire.Vars) (*empire.Config, error) {
+	validators := m.Validators
+	if validators == nil {
+		validators = empire.DefaultValidators
+	}
+
+	var errs []error
+	for _, n := range vars.Keys() {
+		for _, v := range validators {
+			if err := v.Validate(n, vars[n]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, empire.VarErrors(errs)
+	}
+
+	c := empire.NewConfig(m.Head(), vars)
+	c.ID = fmt.Sprintf("%d", len(m.History)+1)
+	m.History = append(m.History, c)
+
+	return c, nil
+}
+
+// Unset removes the named variables from the current config.
+func (m *MergeHarness) Unset(names ...empire.Variable) (*empire.Config, error) {
+	vars := make(empire.Vars, len(names))
+	for _, n := range names {
+		vars[n] = nil
+	}
+
+	return m.Apply(vars)
+}
+
+// Replace makes the current config exactly vars, unsetting any variable that
+// isn't in vars.
+func (m *MergeHarness) Replace(vars empire.Vars) (*empire.Config, error) {
+	replaced := make(empire.Vars, len(vars))
+	for n := range m.Head().Vars {
+		replaced[n] = nil
+	}
+
+	for n, v := range vars {
+		replaced[n] = v
+	}
+
+	return m.Apply(replaced)
+}
+
+// Run applies the initial vars, then every step in the scenario, returning the
+// result. Steps that fail are recorded in the result, and the scenario
+// continues with the next step.
+func (m *MergeHarness) Run(s *Scenario) (*ScenarioResult, error) {
+	if _, err := m.Replace(s.Initial); err != nil {
+		return nil, fmt.Errorf("initial: %v", err)
+	}
+
+	errors := []string{}
+	for i, step := range s.Steps {
+		var err error
+		switch step.Op {
+		case OpApply:
+			_, err = m.Apply(step.Vars)
+		case OpUnset:
+			_, err = m.Unset(step.Names...)
+		case OpReplace:
+			_, err = m.Replace(step.Vars)
+		default:
+			return nil, fmt.Errorf("step %d: unknown op %q", i, step.Op)
+		}
+
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		errors = append(errors, msg)
+	}
+
+	return &ScenarioResult{
+		Vars:     m.Head().Vars,
+		Versions: len(m.History),
+		Errors:   errors,
+	}, nil
+}
+
+// RunGoldenScenarios runs every *.json scenario in dir against a new
+// MergeHarness, comparing each result with the golden file next to it, named
+// like scenario.golden. Scenarios can be added without writing any Go by
+// adding a JSON file, then running the tests with -update-golden to generate
+// its golden file, which should be checked for correctness before it's
+// committed.
+func RunGoldenScenarios(t testing.TB, dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) == 0 {
+		t.Fatalf("no scenarios found in %s", dir)
+	}
+
+	for _, path := range paths {
+		if err := runGoldenScenario(path, *UpdateGolden); err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// runGoldenScenario runs the scenario at path, comparing the result with its
+// golden file, or writing it if update is true.
+func runGoldenScenario(path string, update bool) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+
+	result, err := new(MergeHarness).Run(&s)
+	if err != nil {
+		return err
+	}
+
+	got, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	got = append(got, '\n')
+
+	golden := strings.TrimSuffix(path, ".json") + ".golden"
+
+	if update {
+		return ioutil.WriteFile(golden, got, 0644)
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return fmt.Errorf("%v (run with -update-golden to create it)", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("result doesn't match %s:\n%s\nwant:\n%s", filepath.Base(golden), got, want)
+	}
+
+	return nil
+}
@@ -0,0 +1,7 @@
+package empiretest
+
+import "testing"
+
+func TestGoldenScenarios(t *testing.T) {
+	RunGoldenScenarios(t, "testdata/configs")
+}
@@ -20,6 +20,13 @@ var (
 // NewEmpire returns a new Empire instance suitable for testing. It ensures that
 // the database is clean before returning.
 func NewEmpire(t testing.TB) *empire.Empire {
+	return NewEmpireWithOptions(t, nil)
+}
+
+// NewEmpireWithOptions is like NewEmpire, but calls configure, if provided,
+// with the options before the instance is created, like to enable optional
+// config behaviour.
+func NewEmpireWithOptions(t testing.TB, configure func(*empire.Options)) *empire.Empire {
 	opts := empire.Options{
 		DB:        DatabaseURL,
 		AWSConfig: nil,
@@ -35,6 +42,10 @@ func NewEmpire(t testing.TB) *empire.Empire {
 		},
 	}
 
+	if configure != nil {
+		configure(&opts)
+	}
+
 	e, err := empire.New(opts)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,2 @@
+DROP INDEX index_configs_on_app_id_and_created_at_and_seq;
+ALTER TABLE configs DROP COLUMN seq;
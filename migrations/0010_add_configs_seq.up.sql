@@ -0,0 +1,2 @@
+ALTER TABLE configs ADD COLUMN seq bigserial;
+CREATE INDEX index_configs_on_app_id_and_created_at_and_seq ON configs (app_id, created_at, seq);
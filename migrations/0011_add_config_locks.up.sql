@@ -0,0 +1,5 @@
+CREATE TABLE config_locks (
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE primary key,
+  reason text NOT NULL,
+  created_at timestamp without time zone default (now() at time zone 'utc')
+);
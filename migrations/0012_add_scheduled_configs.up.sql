@@ -0,0 +1,11 @@
+CREATE TABLE scheduled_configs (
+  id uuid NOT NULL DEFAULT uuid_generate_v4() primary key,
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE,
+  vars hstore NOT NULL,
+  effective_at timestamp without time zone NOT NULL,
+  created_at timestamp without time zone default (now() at time zone 'utc'),
+  applied_at timestamp without time zone,
+  config_id uuid references configs(id) ON DELETE SET NULL
+);
+
+CREATE INDEX index_scheduled_configs_on_effective_at ON scheduled_configs (effective_at) WHERE applied_at IS NULL;
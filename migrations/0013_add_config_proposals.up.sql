@@ -0,0 +1,14 @@
+CREATE TABLE config_proposals (
+  id uuid NOT NULL DEFAULT uuid_generate_v4() primary key,
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE,
+  vars hstore NOT NULL,
+  status text NOT NULL,
+  requester text NOT NULL,
+  approver text,
+  reason text,
+  config_id uuid references configs(id) ON DELETE SET NULL,
+  created_at timestamp without time zone default (now() at time zone 'utc'),
+  decided_at timestamp without time zone
+);
+
+CREATE INDEX index_config_proposals_on_app_id_and_status ON config_proposals (app_id, status);
@@ -0,0 +1 @@
+ALTER TABLE configs ADD COLUMN expires hstore;
@@ -0,0 +1 @@
+DROP TABLE frozen_configs;
@@ -0,0 +1,7 @@
+CREATE TABLE frozen_configs (
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  config_id uuid NOT NULL references configs(id),
+  created_at timestamp without time zone default (now() at time zone 'utc'),
+  primary key (app_id, name)
+);
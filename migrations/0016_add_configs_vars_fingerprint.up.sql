@@ -0,0 +1 @@
+ALTER TABLE configs ADD COLUMN vars_fingerprint text;
@@ -0,0 +1,6 @@
+CREATE TABLE config_var_owners (
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  team text NOT NULL,
+  primary key (app_id, name)
+);
@@ -0,0 +1,2 @@
+DROP TABLE config_group_apps;
+DROP TABLE config_groups;
@@ -0,0 +1,12 @@
+CREATE TABLE config_groups (
+  id uuid NOT NULL DEFAULT uuid_generate_v4() primary key,
+  name text NOT NULL UNIQUE,
+  vars hstore NOT NULL
+);
+
+CREATE TABLE config_group_apps (
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE primary key,
+  group_id uuid NOT NULL references config_groups(id) ON DELETE CASCADE
+);
+
+CREATE INDEX index_config_group_apps_on_group_id ON config_group_apps (group_id);
@@ -0,0 +1 @@
+ALTER TABLE config_groups DROP COLUMN parent_id;
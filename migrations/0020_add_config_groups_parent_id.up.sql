@@ -0,0 +1 @@
+ALTER TABLE config_groups ADD COLUMN parent_id uuid references config_groups(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+CREATE TABLE config_data_keys (
+  app_id uuid NOT NULL references apps(id) ON DELETE CASCADE primary key,
+  wrapped_key bytea NOT NULL,
+  master_key_id text NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE configs DROP COLUMN actor;
+ALTER TABLE configs DROP COLUMN restores_id;
+ALTER TABLE configs DROP COLUMN parent_id;
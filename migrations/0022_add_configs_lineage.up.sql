@@ -0,0 +1,6 @@
+ALTER TABLE configs ADD COLUMN parent_id uuid references configs(id) ON DELETE SET NULL;
+ALTER TABLE configs ADD COLUMN restores_id uuid references configs(id) ON DELETE SET NULL;
+ALTER TABLE configs ADD COLUMN actor text NOT NULL DEFAULT '';
+
+CREATE INDEX index_configs_on_parent_id ON configs (parent_id);
+CREATE INDEX index_configs_on_restores_id ON configs (restores_id);
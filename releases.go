@@ -89,13 +89,32 @@ func (s *store) ReleasesFirst(scope Scope) (*Release, error) {
 		return &release, err
 	}
 
-	return &release, nil
+	return &release, s.decryptRelease(&release)
 }
 
 // Releases returns all releases matching the scope.
 func (s *store) Releases(scope Scope) ([]*Release, error) {
 	var releases []*Release
-	return releases, s.Find(scope, &releases)
+	if err := s.Find(scope, &releases); err != nil {
+		return releases, err
+	}
+
+	for _, r := range releases {
+		if err := s.decryptRelease(r); err != nil {
+			return releases, err
+		}
+	}
+
+	return releases, nil
+}
+
+// decryptRelease decrypts the config that was preloaded with the release.
+func (s *store) decryptRelease(r *Release) error {
+	if r.Config == nil {
+		return nil
+	}
+
+	return s.decryptConfig(r.Config)
 }
 
 // ReleasesCreate persists a release.
@@ -104,6 +123,16 @@ func (s *store) ReleasesCreate(r *Release) (*Release, error) {
 		return r, err
 	}
 
+	// Gorm saves the config along with the release, so its vars need to
+	// be encrypted like they are when it's created.
+	if r.Config != nil {
+		restore, err := s.sealVars(r.App.ID, &r.Config.Vars)
+		defer restore()
+		if err != nil {
+			return r, err
+		}
+	}
+
 	return releasesCreate(s.db, r)
 }
 
@@ -130,12 +159,7 @@ type releasesService struct {
 
 // ReleasesCreate creates the release, then sets the current process formation on the release.
 func (s *releasesService) ReleasesCreate(ctx context.Context, r *Release) (*Release, error) {
-	// Create a new formation for this release.
-	if err := s.createFormation(r); err != nil {
-		return nil, err
-	}
-
-	r, err := s.store.ReleasesCreate(r)
+	r, err := s.create(r)
 	if err != nil {
 		return r, err
 	}
@@ -144,6 +168,17 @@ func (s *releasesService) ReleasesCreate(ctx context.Context, r *Release) (*Rele
 	return r, s.releaser.Release(ctx, r)
 }
 
+// create inserts the release, with a new formation, without scheduling it onto
+// the cluster.
+func (s *releasesService) create(r *Release) (*Release, error) {
+	// Create a new formation for this release.
+	if err := s.createFormation(r); err != nil {
+		return nil, err
+	}
+
+	return s.store.ReleasesCreate(r)
+}
+
 func (s *releasesService) createFormation(release *Release) error {
 	var existing Formation
 
@@ -163,22 +198,53 @@ func (s *releasesService) createFormation(release *Release) error {
 	return nil
 }
 
-// Rolls back to a specific release version.
+// Rolls back to a specific release version. The new release gets a new config
+// that restores the config of the release, so the rollback shows up in the
+// lineage of the apps configs.
 func (s *releasesService) ReleasesRollback(ctx context.Context, app *App, version int) (*Release, error) {
 	r, err := s.store.ReleasesFirst(ReleasesQuery{App: app, Version: &version})
 	if err != nil {
 		return nil, err
 	}
 
+	config, err := s.restore(ctx, app, r.Config)
+	if err != nil {
+		return nil, err
+	}
+
 	desc := fmt.Sprintf("Rollback to v%d", version)
 	return s.ReleasesCreate(ctx, &Release{
 		App:         app,
-		Config:      r.Config,
+		Config:      config,
 		Slug:        r.Slug,
 		Description: desc,
 	})
 }
 
+// restore creates a new config for the app with the vars, expirations and
+// secret flags of old, which restores it. If old is already the current
+// config, it's returned as is.
+func (s *releasesService) restore(ctx context.Context, app *App, old *Config) (*Config, error) {
+	current, err := s.store.ConfigsCurrent(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.ID == old.ID {
+		return old, nil
+	}
+
+	return s.store.ConfigsCreate(&Config{
+		AppID:       app.ID,
+		Vars:        old.Vars,
+		Expires:     old.Expires,
+		SecretFlags: old.SecretFlags,
+		ParentID:    &current.ID,
+		RestoresID:  &old.ID,
+		Actor:       actorFromContext(ctx),
+	})
+}
+
 // ReleasesLastVersion returns the last ReleaseVersion for the given App. This
 // function also ensures that the last release is locked until the transaction
 // is commited, so the release version can be incremented atomically.
@@ -229,12 +295,20 @@ func releasesCreate(db *gorm.DB, release *Release) (*Release, error) {
 type releaser struct {
 	store   *store
 	manager service.Manager
+
+	// Used to build the environment for processes.
+	env *envBuilder
 }
 
 // ScheduleRelease creates jobs for every process and instance count and
 // schedules them onto the cluster.
 func (r *releaser) Release(ctx context.Context, release *Release) error {
-	a := newServiceApp(release)
+	vars, err := r.env.Vars(release.App, release.Config)
+	if err != nil {
+		return err
+	}
+
+	a := newServiceApp(release, vars)
 	return r.manager.Submit(ctx, a)
 }
 
@@ -252,11 +326,11 @@ func (r *releaser) ReleaseApp(ctx context.Context, app *App) error {
 	return r.Release(ctx, release)
 }
 
-func newServiceApp(release *Release) *service.App {
+func newServiceApp(release *Release, vars Vars) *service.App {
 	var processes []*service.Process
 
 	for _, p := range release.Processes {
-		processes = append(processes, newServiceProcess(release, p))
+		processes = append(processes, newServiceProcess(release, p, vars))
 	}
 
 	return &service.App{
@@ -266,11 +340,11 @@ func newServiceApp(release *Release) *service.App {
 	}
 }
 
-func newServiceProcess(release *Release, p *Process) *service.Process {
+func newServiceProcess(release *Release, p *Process, vars Vars) *service.Process {
 	var procExp service.Exposure
 	ports := newServicePorts(int64(p.Port))
 
-	env := environment(release.Config.Vars)
+	env := environment(vars)
 	env["EMPIRE_APPNAME"] = release.App.Name
 	env["EMPIRE_PROCESS"] = string(p.Type)
 	env["EMPIRE_RELEASE"] = fmt.Sprintf("v%d", release.Version)
@@ -15,3 +15,29 @@ func TestReleasesQuery(t *testing.T) {
 
 	tests.Run(t)
 }
+
+func TestNewServiceApp_NullVar(t *testing.T) {
+	// Old rows can have NULL values within the hstore.
+	var vars Vars
+	if err := vars.Scan([]byte(`"RAILS_ENV"=>"production", "LEGACY"=>NULL`)); err != nil {
+		t.Fatal(err)
+	}
+
+	release := &Release{
+		App:       &App{ID: "1234", Name: "acme-inc"},
+		Version:   1,
+		Slug:      &Slug{},
+		Processes: []*Process{NewProcess("worker", "sidekiq")},
+	}
+
+	app := newServiceApp(release, vars)
+	env := app.Processes[0].Env
+
+	if got, want := env["LEGACY"], ""; got != want {
+		t.Fatalf("LEGACY => %q; want %q", got, want)
+	}
+
+	if got, want := env["RAILS_ENV"], "production"; got != want {
+		t.Fatalf("RAILS_ENV => %q; want %q", got, want)
+	}
+}
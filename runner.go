@@ -23,6 +23,9 @@ type ProcessRunOpts struct {
 type runnerService struct {
 	store   *store
 	manager service.Manager
+
+	// Used to build the environment for the process.
+	env *envBuilder
 }
 
 func (r *runnerService) Run(ctx context.Context, app *App, opts ProcessRunOpts) error {
@@ -31,8 +34,13 @@ func (r *runnerService) Run(ctx context.Context, app *App, opts ProcessRunOpts)
 		return err
 	}
 
-	a := newServiceApp(release)
-	p := newServiceProcess(release, NewProcess("run", Command(opts.Command)))
+	vars, err := r.env.Vars(release.App, release.Config)
+	if err != nil {
+		return err
+	}
+
+	a := newServiceApp(release, vars)
+	p := newServiceProcess(release, NewProcess("run", Command(opts.Command)), vars)
 
 	for k, v := range opts.Env {
 		p.Env[k] = v
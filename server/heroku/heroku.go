@@ -60,13 +60,22 @@ func New(e *empire.Empire, auth authorization.Authorizer) httpx.Handler {
 	r.Handle("/apps/{app}/ssl-endpoints/{cert}", &PatchSSLEndpoint{e}).Methods("PATCH")   // hk ssl-cert-add, hk ssl-cert-rollback
 	r.Handle("/apps/{app}/ssl-endpoints/{cert}", &DeleteSSLEndpoint{e}).Methods("DELETE") // hk ssl-destroy
 
-	errorHandler := func(err error, w http.ResponseWriter, r *http.Request) {
-		Error(w, err, http.StatusInternalServerError)
-	}
-
 	return middleware.HandleError(r, errorHandler)
 }
 
+// Authenticated wraps h in the Authentication middleware, responding with
+// errors, like a missing or invalid token, in the heroku error format. It can
+// be used for handlers that are mounted outside of the api, but should still
+// require an access token.
+func Authenticated(e *empire.Empire, h httpx.Handler) httpx.Handler {
+	return middleware.HandleError(Authenticate(e, h), errorHandler)
+}
+
+// errorHandler responds with err in the heroku error format.
+func errorHandler(err error, w http.ResponseWriter, r *http.Request) {
+	Error(w, err, http.StatusInternalServerError)
+}
+
 // Encode json encodes v into w.
 func Encode(w http.ResponseWriter, v interface{}) error {
 	if v == nil {
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/remind101/empire"
+	"golang.org/x/net/context"
+)
+
+// MetricsHandler is an http.Handler that serves gauges for the number of
+// variables and size of each apps config, in the Prometheus text format.
+type MetricsHandler struct {
+	// A function that returns the stats for every apps config.
+	ConfigStats func() ([]*empire.ConfigStats, error)
+
+	// If provided, only these apps will be reported, which bounds the
+	// number of label values.
+	Apps []string
+}
+
+// NewMetricsHandler returns a new MetricsHandler using the ConfigsStats method
+// from an Empire instance.
+func NewMetricsHandler(e *empire.Empire, apps []string) *MetricsHandler {
+	return &MetricsHandler{
+		ConfigStats: e.ConfigsStats,
+		Apps:        apps,
+	}
+}
+
+func (h *MetricsHandler) ServeHTTPContext(_ context.Context, w http.ResponseWriter, r *http.Request) error {
+	stats, err := h.ConfigStats()
+	if err != nil {
+		return err
+	}
+
+	stats = h.filter(stats)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP empire_config_vars The number of variables in the current config for an app.")
+	fmt.Fprintln(w, "# TYPE empire_config_vars gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "empire_config_vars{app=\"%s\"} %d\n", escapeLabel(s.App), s.Vars)
+	}
+
+	fmt.Fprintln(w, "# HELP empire_config_bytes The total size of the variables in the current config for an app.")
+	fmt.Fprintln(w, "# TYPE empire_config_bytes gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "empire_config_bytes{app=\"%s\"} %d\n", escapeLabel(s.App), s.Size)
+	}
+
+	return nil
+}
+
+// filter removes the stats for apps that aren't in the allowlist.
+func (h *MetricsHandler) filter(stats []*empire.ConfigStats) []*empire.ConfigStats {
+	if len(h.Apps) == 0 {
+		return stats
+	}
+
+	allowed := make(map[string]bool, len(h.Apps))
+	for _, a := range h.Apps {
+		allowed[a] = true
+	}
+
+	var filtered []*empire.ConfigStats
+	for _, s := range stats {
+		if allowed[s.App] {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
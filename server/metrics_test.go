@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remind101/empire"
+	"golang.org/x/net/context"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	h := &MetricsHandler{
+		ConfigStats: func() ([]*empire.ConfigStats, error) {
+			return []*empire.ConfigStats{
+				{App: "acme-inc", Vars: 2, Size: 24},
+				{App: "other", Vars: 1, Size: 8},
+			}, nil
+		},
+		Apps: []string{"acme-inc"},
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	resp := httptest.NewRecorder()
+	if err := h.ServeHTTPContext(context.Background(), resp, req); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP empire_config_vars The number of variables in the current config for an app.
+# TYPE empire_config_vars gauge
+empire_config_vars{app="acme-inc"} 2
+# HELP empire_config_bytes The total size of the variables in the current config for an app.
+# TYPE empire_config_bytes gauge
+empire_config_bytes{app="acme-inc"} 24
+`
+
+	if got := resp.Body.String(); got != expected {
+		t.Fatalf("Body => %q; want %q", got, expected)
+	}
+}
@@ -30,6 +30,15 @@ type Options struct {
 		Organization string
 		ApiURL       string
 	}
+
+	Metrics struct {
+		// If true, config metrics are served at /metrics, to requests
+		// with an access token.
+		Enabled bool
+
+		// If provided, only these apps will be included in the metrics.
+		Apps []string
+	}
 }
 
 func New(e *empire.Empire, options Options) http.Handler {
@@ -49,6 +58,12 @@ func New(e *empire.Empire, options Options) http.Handler {
 	// Mount health endpoint
 	r.Handle("/health", NewHealthHandler(e))
 
+	// Mount metrics endpoint. App names and config sizes aren't public, so
+	// it requires an access token, like the api.
+	if options.Metrics.Enabled {
+		r.Handle("/metrics", heroku.Authenticated(e, NewMetricsHandler(e, options.Metrics.Apps)))
+	}
+
 	return middleware.Common(r, middleware.CommonOpts{
 		Reporter: e.Reporter,
 		Logger:   e.Logger,
@@ -2,7 +2,9 @@ package empire
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/inconshreveable/log15"
 	"github.com/jinzhu/gorm"
 )
 
@@ -79,6 +81,30 @@ func Order(order string) Scope {
 // store provides methods for CRUD'ing things.
 type store struct {
 	db *gorm.DB
+
+	// The connection string for the database. This is used for things that
+	// need a dedicated connection, like listening for notifications.
+	url string
+
+	// If true, a notification will be sent on the ConfigChangesChannel
+	// whenever a new config is created.
+	notifyConfigChanges bool
+
+	// If non-zero, calls that read or write configs and take longer than
+	// this are logged to logger.
+	slowThreshold time.Duration
+	logger        log15.Logger
+
+	// If true, configs are verified against their stored fingerprint
+	// whenever they're read.
+	verifyConfigs bool
+
+	// The number of apps pruned by each statement in ConfigsPruneAll.
+	pruneBatchSize int
+
+	// If provided, config values are encrypted with the data key of their
+	// app before they're stored.
+	keys KeyProvider
 }
 
 // Scope applies the scope to the gorm.DB.
@@ -107,12 +133,30 @@ func (s *store) Reset() error {
 	}
 
 	exec(`TRUNCATE TABLE apps CASCADE`)
+	exec(`TRUNCATE TABLE config_groups CASCADE`)
 	exec(`TRUNCATE TABLE ports CASCADE`)
 	exec(`INSERT INTO ports (port) (SELECT generate_series(9000,10000))`)
 
 	return err
 }
 
+// logSlow logs the call to method if it's been longer than the slow threshold
+// since start. It's meant to be deferred at the beginning of the call.
+//
+// This isn't a decorator around the configsStore interface, since the other
+// services call the store directly, rather than through that interface, and
+// their calls need to be timed too. Timing the calls inline keeps them in the
+// one place that every caller goes through.
+func (s *store) logSlow(method, appID string, start time.Time) {
+	if s.slowThreshold == 0 {
+		return
+	}
+
+	if d := time.Since(start); d > s.slowThreshold {
+		s.logger.Warn("slow query", "method", method, "app", appID, "duration", d)
+	}
+}
+
 func (s *store) IsHealthy() bool {
 	return s.db.DB().Ping() == nil
 }
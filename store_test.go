@@ -8,6 +8,7 @@ import (
 
 	gosql "database/sql"
 
+	"github.com/inconshreveable/log15"
 	"github.com/jinzhu/gorm"
 )
 
@@ -82,3 +83,29 @@ func conditionSql(scope Scope) (sql string, vars []interface{}) {
 	vars = ds.SqlVars
 	return
 }
+
+func TestStore_LogSlow(t *testing.T) {
+	var records []*log15.Record
+
+	logger := log15.New()
+	logger.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+
+	s := &store{slowThreshold: time.Minute, logger: logger}
+
+	s.logSlow("ConfigsCurrent", "1234", time.Now())
+	if got, want := len(records), 0; got != want {
+		t.Fatalf("len(records) => %d; want %d", got, want)
+	}
+
+	s.logSlow("ConfigsCurrent", "1234", time.Now().Add(-2*time.Minute))
+	if got, want := len(records), 1; got != want {
+		t.Fatalf("len(records) => %d; want %d", got, want)
+	}
+
+	if got, want := records[0].Ctx[1], "ConfigsCurrent"; got != want {
+		t.Fatalf("method => %v; want %v", got, want)
+	}
+}
@@ -1,11 +1,23 @@
 package api_test
 
 import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/bgentry/heroku-go"
+	_ "github.com/lib/pq"
 	"github.com/remind101/empire"
+	"github.com/remind101/empire/empiretest"
+	"github.com/remind101/empire/pkg/image"
+	"github.com/remind101/pkg/timex"
+	"golang.org/x/net/context"
 )
 
 func TestConfigVarUpdate(t *testing.T) {
@@ -78,6 +90,211 @@ func TestConfigVarInfo(t *testing.T) {
 	}
 }
 
+func TestConfigsCurrentIdenticalTimestamps(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, production := "staging", "production"
+	for _, env := range []*string{&staging, &production} {
+		if _, err := e.ConfigsApply(context.Background(), app, empire.Vars{"RAILS_ENV": env}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every config was created at the same instant, so the insertion
+	// sequence must decide which one is current.
+	for i := 0; i < 10; i++ {
+		c, err := e.ConfigsCurrent(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := *c.Vars["RAILS_ENV"], production; got != want {
+			t.Fatalf("RAILS_ENV => %v; want %v", got, want)
+		}
+	}
+}
+
+func TestConfigsCompareAndSet(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader, follower := "i-1", "i-2"
+
+	// Unset, so expecting nil succeeds.
+	if _, err := e.ConfigsCompareAndSet(ctx, app, "LEADER", nil, &leader); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already set, so expecting nil fails.
+	if _, err := e.ConfigsCompareAndSet(ctx, app, "LEADER", nil, &follower); err != empire.ErrConfigConflict {
+		t.Fatalf("err => %v; want %v", err, empire.ErrConfigConflict)
+	}
+
+	c, err := e.ConfigsCompareAndSet(ctx, app, "LEADER", &leader, &follower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := *c.Vars["LEADER"], follower; got != want {
+		t.Fatalf("LEADER => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsLock(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigsLock(app, "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+
+	env := "production"
+	_, err = e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &env})
+	if _, ok := err.(*empire.ConfigLockedError); !ok {
+		t.Fatalf("err => %v; want a ConfigLockedError", err)
+	}
+
+	if err := e.ConfigsUnlock(app); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &env}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigsCurrentForApps(t *testing.T) {
+	e := empiretest.NewEmpireWithOptions(t, func(opts *empire.Options) {
+		opts.Configs.VerifyIntegrity = true
+	})
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", empiretest.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The released app gets the config of its latest release, even if a
+	// newer config exists.
+	released := mustDeployImage(t, e)
+
+	rc, err := e.ConfigsCurrent(released)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`insert into configs (app_id, vars, created_at) values ($1, hstore('V', 'unreleased'), $2)`, released.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The app without a release gets its latest config.
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var latest *empire.Config
+	for _, v := range []string{"1", "2"} {
+		v := v
+		latest, err = e.ConfigsApply(ctx, app, empire.Vars{"V": &v})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configs, err := e.ConfigsCurrentForApps([]*empire.App{released, app})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := configs[released.Name].ID, rc.ID; got != want {
+		t.Fatalf("%s ID => %s; want %s", released.Name, got, want)
+	}
+
+	if got, want := configs[app.Name].ID, latest.ID; got != want {
+		t.Fatalf("%s ID => %s; want %s", app.Name, got, want)
+	}
+
+	// Configs are checked against their fingerprint.
+	if _, err := db.Exec(`update configs set vars = hstore('V', 'tampered') where id = $1`, latest.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsCurrentForApps([]*empire.App{released, app}); err != empire.ErrConfigCorrupted {
+		t.Fatalf("err => %v; want %v", err, empire.ErrConfigCorrupted)
+	}
+}
+
+func BenchmarkConfigsCurrent(b *testing.B) {
+	e, apps := newBenchmarkApps(b, 50)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, app := range apps {
+			if _, err := e.ConfigsCurrent(app); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkConfigsCurrentForApps(b *testing.B) {
+	e, apps := newBenchmarkApps(b, 50)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := e.ConfigsCurrentForApps(apps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newBenchmarkApps creates n apps, each with a few configs.
+func newBenchmarkApps(b *testing.B, n int) (*empire.Empire, []*empire.App) {
+	e := empiretest.NewEmpire(b)
+
+	var apps []*empire.App
+	for i := 0; i < n; i++ {
+		app, err := e.AppsCreate(&empire.App{Name: fmt.Sprintf("acme-inc-%d", i)})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, env := range []string{"staging", "production"} {
+			env := env
+			if _, err := e.ConfigsApply(context.Background(), app, empire.Vars{"RAILS_ENV": &env}); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		apps = append(apps, app)
+	}
+
+	return e, apps
+}
+
 func mustConfigVarUpdate(t testing.TB, c *heroku.Client, appName string, options map[string]*string) map[string]string {
 	vars, err := c.ConfigVarUpdate(appName, options)
 	if err != nil {
@@ -95,3 +312,2197 @@ func mustConfigVarInfo(t testing.TB, c *heroku.Client, appName string) map[strin
 
 	return vars
 }
+
+func TestConfigsStats(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, port := "production", "80"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &env, "PORT": &port}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := e.ConfigsStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*empire.ConfigStats{{App: "acme-inc", Vars: 2, Size: 25}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("ConfigsStats => %v; want %v", stats, expected)
+	}
+}
+
+func TestConfigsSwap(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	blue, err := e.AppsCreate(&empire.App{Name: "acme-inc-blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	green, err := e.AppsCreate(&empire.App{Name: "acme-inc-green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, g := "blue", "green"
+	if _, err := e.ConfigsApply(ctx, blue, empire.Vars{"COLOR": &b, "BLUE": &b}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ConfigsApply(ctx, green, empire.Vars{"COLOR": &g}); err != nil {
+		t.Fatal(err)
+	}
+
+	cb, cg, err := e.ConfigsSwap(ctx, blue, green)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cb.Vars, (empire.Vars{"COLOR": &g}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("blue Vars => %v; want %v", got, want)
+	}
+
+	if got, want := cg.Vars, (empire.Vars{"COLOR": &b, "BLUE": &b}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("green Vars => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsSwap_ExpiresAndFlags(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	blue, err := e.AppsCreate(&empire.App{Name: "acme-inc-blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	green, err := e.AppsCreate(&empire.App{Name: "acme-inc-green"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := e.ConfigsSetWithTTL(ctx, blue, "TOKEN", "abc", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := c.Expires["TOKEN"]
+
+	if _, err := e.ConfigsMarkSecret(ctx, blue, "TOKEN", true); err != nil {
+		t.Fatal(err)
+	}
+
+	g := "green"
+	if _, err := e.ConfigsApply(ctx, green, empire.Vars{"COLOR": &g}); err != nil {
+		t.Fatal(err)
+	}
+
+	cb, cg, err := e.ConfigsSwap(ctx, blue, green)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cb.Vars, (empire.Vars{"COLOR": &g}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("blue Vars => %v; want %v", got, want)
+	}
+
+	if got, want := len(cb.Expires), 0; got != want {
+		t.Fatalf("blue len(Expires) => %d; want %d", got, want)
+	}
+
+	if got, want := len(cb.SecretFlags), 0; got != want {
+		t.Fatalf("blue len(SecretFlags) => %d; want %d", got, want)
+	}
+
+	if got, want := cg.Expires["TOKEN"], expires; !got.Equal(want) {
+		t.Fatalf("green Expires[TOKEN] => %v; want %v", got, want)
+	}
+
+	if got, want := cg.SecretFlags, (empire.SecretFlags{"TOKEN": empire.SecretFlagSecret}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("green SecretFlags => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsRestoreSnapshot(t *testing.T) {
+	// Snapshots are validated even if the configured validators allow
+	// anything.
+	e := empiretest.NewEmpireWithOptions(t, func(o *empire.Options) {
+		o.Configs.Validators = []empire.Validator{
+			empire.ValidatorFunc(func(name empire.Variable, value *string) error { return nil }),
+		}
+	})
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, old, nul := "abc", "old", "a\x00b"
+	expires := time.Now().Add(time.Hour).UTC()
+	snapshot := &empire.Config{
+		Vars:        empire.Vars{"TOKEN": &token, "OLD": &old},
+		Expires:     empire.Expirations{"TOKEN": expires, "OLD": time.Now().Add(-time.Hour)},
+		SecretFlags: empire.SecretFlags{"TOKEN": empire.SecretFlagSecret},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := snapshot.WriteSnapshot(buf, app); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := e.ConfigsRestoreSnapshot(ctx, app, buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Vars, (empire.Vars{"TOKEN": &token}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	if got, want := c.Expires["TOKEN"], expires; !got.Equal(want) {
+		t.Fatalf("Expires[TOKEN] => %v; want %v", got, want)
+	}
+
+	if got, want := c.SecretFlags, snapshot.SecretFlags; !reflect.DeepEqual(got, want) {
+		t.Fatalf("SecretFlags => %v; want %v", got, want)
+	}
+
+	buf.Reset()
+	bad := &empire.Config{Vars: empire.Vars{"NUL": &nul}}
+	if err := bad.WriteSnapshot(buf, app); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = e.ConfigsRestoreSnapshot(ctx, app, buf, false)
+
+	verr, ok := err.(*empire.ValidationError)
+	if !ok {
+		t.Fatalf("err => %v; want a ValidationError", err)
+	}
+
+	expected := empire.VarErrors{&empire.VarError{Name: "NUL", Err: empire.ErrNULByte}}
+	if got, want := verr.Err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Err => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsSchedule(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	off, on := "off", "on"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"FLAG": &off}); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled, err := e.ConfigsSchedule(ctx, app, empire.Vars{"FLAG": &on}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := *c.Vars["FLAG"], off; got != want {
+		t.Fatalf("FLAG => %s; want %s", got, want)
+	}
+
+	if err := e.ConfigsCancelScheduled(app, scheduled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigsCancelScheduled(app, scheduled.ID); err != empire.ErrNotScheduled {
+		t.Fatalf("err => %v; want %v", err, empire.ErrNotScheduled)
+	}
+}
+
+func TestConfigsApplyScheduled(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	off, on, x := "off", "on", "x"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"FLAG": &off}); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled, err := e.ConfigsSchedule(ctx, app, empire.Vars{"FLAG": &on}, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes applied before the scheduled change comes due should be
+	// kept.
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"OTHER": &x}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is due yet.
+	configs, failed, err := e.ConfigsApplyScheduled(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(configs)+len(failed), 0; got != want {
+		t.Fatalf("ConfigsApplyScheduled => %d changes; want %d", got, want)
+	}
+
+	history, err := e.ConfigsHistoryDiffs(app, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(history), 2; got != want {
+		t.Fatalf("len(history) => %d; want %d", got, want)
+	}
+
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	configs, failed, err = e.ConfigsApplyScheduled(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 0 {
+		t.Fatalf("ConfigsApplyScheduled failed => %v", failed)
+	}
+
+	if got, want := len(configs), 1; got != want {
+		t.Fatalf("len(configs) => %d; want %d", got, want)
+	}
+
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Vars, (empire.Vars{"FLAG": &on, "OTHER": &x}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	if got, want := c.ID, configs[0].ID; got != want {
+		t.Fatalf("current ID => %s; want %s", got, want)
+	}
+
+	// Applied changes can't be cancelled, or applied again.
+	if err := e.ConfigsCancelScheduled(app, scheduled.ID); err != empire.ErrNotScheduled {
+		t.Fatalf("err => %v; want %v", err, empire.ErrNotScheduled)
+	}
+
+	configs, _, err = e.ConfigsApplyScheduled(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(configs), 0; got != want {
+		t.Fatalf("len(configs) => %d; want %d", got, want)
+	}
+
+	pending, err := e.ConfigsPending(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(pending), 0; got != want {
+		t.Fatalf("len(pending) => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsReconcile(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, production := "staging", "production"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &staging, "DEBUG": &staging}); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := empire.Vars{"RAILS_ENV": &production}
+
+	c, diff, err := e.ConfigsReconcile(ctx, app, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(diff), 2; got != want {
+		t.Fatalf("len(diff) => %d; want %d", got, want)
+	}
+
+	if got, want := c.Vars, desired; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	unchanged, diff, err := e.ConfigsReconcile(ctx, app, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 0 || unchanged.ID != c.ID {
+		t.Fatalf("expected no changes, got %v", diff)
+	}
+}
+
+func TestConfigsCompare(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	staging, err := e.AppsCreate(&empire.App{Name: "acme-inc-staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	production, err := e.AppsCreate(&empire.App{Name: "acme-inc-production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := "a", "b"
+	if _, err := e.ConfigsApply(ctx, staging, empire.Vars{"API_KEY": &a, "DEBUG": &a}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ConfigsApply(ctx, production, empire.Vars{"API_KEY": &b}); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := e.ConfigsCompare(staging, production)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := diff.Summary(true), "~ API_KEY (changed)\n+ DEBUG=a"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+}
+
+func TestConfigsScanAll(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	ports := map[string]string{"acme-inc": "80", "acme-api": "http"}
+	for name, port := range ports {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		port := port
+		if _, err := e.ConfigsApply(ctx, app, empire.Vars{"PORT": &port}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	violations, err := e.ConfigsScanAll(func(app string, c *empire.Config) []error {
+		if _, err := strconv.Atoi(*c.Vars["PORT"]); err != nil {
+			return []error{err}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(violations), 1; got != want {
+		t.Fatalf("len(violations) => %d; want %d", got, want)
+	}
+
+	if _, ok := violations["acme-api"]; !ok {
+		t.Fatal("expected acme-api to have violations")
+	}
+}
+
+func TestConfigsSetIfAbsent(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited, production, port := "edited", "production", "80"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &edited}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, added, err := e.ConfigsSetIfAbsent(ctx, app, empire.Vars{"RAILS_ENV": &production, "PORT": &port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := added, []empire.Variable{"PORT"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("added => %v; want %v", got, want)
+	}
+
+	if got, want := *c.Vars["RAILS_ENV"], edited; got != want {
+		t.Fatalf("RAILS_ENV => %s; want %s", got, want)
+	}
+
+	unchanged, added, err := e.ConfigsSetIfAbsent(ctx, app, empire.Vars{"PORT": &port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(added) != 0 || unchanged.ID != c.ID {
+		t.Fatalf("expected no changes, got %v", added)
+	}
+}
+
+func TestConfigsSizeStats(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		app, err := e.AppsCreate(&empire.App{Name: fmt.Sprintf("acme-inc-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vars := make(empire.Vars)
+		for j := 0; j < i; j++ {
+			v := "x"
+			vars[empire.Variable(fmt.Sprintf("VAR_%d", j))] = &v
+		}
+
+		if _, err := e.ConfigsApply(ctx, app, vars); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := e.ConfigsSizeStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := stats.Apps, 3; got != want {
+		t.Fatalf("Apps => %d; want %d", got, want)
+	}
+
+	if got := stats.Vars; got.Min != 1 || got.Max != 3 || got.Median != 2 {
+		t.Fatalf("Vars => %v; want min 1, max 3 and median 2", got)
+	}
+
+	if got, want := stats.Over, 2; got != want {
+		t.Fatalf("Over => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsPropose(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	production := "production"
+	p, err := e.ConfigsPropose(app, empire.Vars{"RAILS_ENV": &production}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Vars["RAILS_ENV"]; ok {
+		t.Fatal("expected pending proposal not to change the config")
+	}
+
+	if _, err := e.ConfigsApprove(ctx, p.ID, "alice"); err != empire.ErrSelfApproval {
+		t.Fatalf("err => %v; want %v", err, empire.ErrSelfApproval)
+	}
+
+	c, err = e.ConfigsApprove(ctx, p.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := *c.Vars["RAILS_ENV"], production; got != want {
+		t.Fatalf("RAILS_ENV => %s; want %s", got, want)
+	}
+
+	if err := e.ConfigsReject(p.ID, "too late"); err != empire.ErrProposalNotPending {
+		t.Fatalf("err => %v; want %v", err, empire.ErrProposalNotPending)
+	}
+
+	if _, err := e.ConfigsApprove(ctx, p.ID, "carol"); err != empire.ErrProposalNotPending {
+		t.Fatalf("err => %v; want %v", err, empire.ErrProposalNotPending)
+	}
+}
+
+func TestConfigsPending(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := e.ConfigsPending(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending == nil || len(pending) != 0 {
+		t.Fatalf("ConfigsPending => %#v; want an empty slice", pending)
+	}
+
+	on, secret := "on", "s3cr3t"
+	if _, err := e.ConfigsSchedule(context.Background(), app, empire.Vars{"FLAG": &on}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsPropose(app, empire.Vars{"API_KEY": &secret}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err = e.ConfigsPending(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(pending), 2; got != want {
+		t.Fatalf("len(pending) => %d; want %d", got, want)
+	}
+
+	if got, want := pending[0].Summary, "+ FLAG=on"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+
+	if got, want := pending[1].Summary, "+ API_KEY=***"; got != want {
+		t.Fatalf("Summary => %q; want %q", got, want)
+	}
+}
+
+func TestConfigsRotateVar(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	var apps []*empire.App
+	for _, name := range []string{"acme-inc", "acme-api", "acme-locked", "acme-other"} {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, app)
+	}
+
+	leaked := "leaked"
+	for _, app := range apps[:3] {
+		if _, err := e.ConfigsApply(ctx, app, empire.Vars{"SHARED_TOKEN": &leaked}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.ConfigsLock(apps[2], "incident"); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, failed, err := e.ConfigsRotateVar(ctx, apps, "SHARED_TOKEN", "rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(configs), 2; got != want {
+		t.Fatalf("len(configs) => %d; want %d", got, want)
+	}
+
+	for _, c := range configs {
+		if got, want := *c.Vars["SHARED_TOKEN"], "rotated"; got != want {
+			t.Fatalf("SHARED_TOKEN => %s; want %s", got, want)
+		}
+	}
+
+	if _, ok := failed[apps[2]].(*empire.ConfigLockedError); !ok || len(failed) != 1 {
+		t.Fatalf("failed => %v; want a ConfigLockedError for the locked app", failed)
+	}
+}
+
+func TestConfigsCurrentMeta_MarkedSecret(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripe := "sk_live"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"STRIPE": &stripe}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsMarkSecret(ctx, app, "STRIPE", true); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := e.ConfigsCurrentMeta(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(meta.Vars) != 1 || !meta.Vars[0].Secret {
+		t.Fatalf("Vars => %v; want STRIPE to be secret", meta.Vars)
+	}
+}
+
+func TestConfigsPruneAllExpired(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app := mustDeployImage(t, e)
+
+	if _, err := e.ConfigsSetWithTTL(ctx, app, "TOKEN", "abc", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has expired yet.
+	pruned, failed, err := e.ConfigsPruneAllExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(pruned)+len(failed), 0; got != want {
+		t.Fatalf("ConfigsPruneAllExpired => %d apps; want %d", got, want)
+	}
+
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	pruned, failed, err = e.ConfigsPruneAllExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 0 {
+		t.Fatalf("ConfigsPruneAllExpired failed => %v", failed)
+	}
+
+	if got, want := pruned, map[string][]empire.Variable{app.Name: {"TOKEN"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pruned => %v; want %v", got, want)
+	}
+
+	// The app is released, so running processes drop the expired value.
+	last, err := e.ReleasesLast(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := last.Version, 3; got != want {
+		t.Fatalf("Version => %d; want %d", got, want)
+	}
+
+	if _, ok := last.Config.Vars["TOKEN"]; ok {
+		t.Fatal("expected the release not to include the expired variable")
+	}
+}
+
+func TestConfigsEffectiveEnv(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app := mustDeployImage(t, e)
+
+	if _, err := e.ConfigsSetWithTTL(ctx, app, "TOKEN", "${app.name}-token", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := e.ReleasesLast(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The variable has expired since the config was released, but the
+	// release ran with it.
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	port, debug := "8080", "1"
+	env, err := e.ConfigsEffectiveEnv(r.Config.ID, empire.Vars{"PORT": &port}, empire.Vars{"DEBUG": &debug})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"DEBUG=1",
+		"PORT=8080",
+		"TOKEN=acme-inc-token",
+	}
+
+	if got, want := env, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsEffectiveEnv => %v; want %v", got, want)
+	}
+
+	if _, err := e.ConfigsEffectiveEnv("00000000-0000-0000-0000-000000000000", nil, nil); err != empire.ErrConfigNotFound {
+		t.Fatalf("err => %v; want %v", err, empire.ErrConfigNotFound)
+	}
+}
+
+// mustDeployImage deploys DefaultImage, returning the app that it created.
+func mustDeployImage(t testing.TB, e *empire.Empire) *empire.App {
+	img, err := image.Decode(DefaultImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan empire.Event)
+	go func() {
+		for range out {
+		}
+	}()
+	defer close(out)
+
+	r, err := e.DeployImage(context.Background(), img, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r.App
+}
+
+func TestConfigsTagAll(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	api, err := e.AppsCreate(&empire.App{Name: "acme-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An app without a config shouldn't be given one.
+	empty, err := e.AppsCreate(&empire.App{Name: "acme-empty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, v2 := "v1", "v2"
+	c, err := e.ConfigsApply(ctx, api, empire.Vars{"VERSION": &v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged, err := e.ConfigsTagAll("pre-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tagged, map[string]string{"acme-api": c.ID}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsTagAll => %v; want %v", got, want)
+	}
+
+	history, err := e.ConfigsHistoryDiffs(empty, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(history), 0; got != want {
+		t.Fatalf("len(history) => %d; want %d", got, want)
+	}
+
+	// Tagging again is a no-op, so that a pass that failed part way
+	// through can be run again.
+	tagged, err = e.ConfigsTagAll("pre-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tagged, map[string]string{"acme-api": c.ID}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsTagAll => %v; want %v", got, want)
+	}
+
+	if _, err := e.ConfigsApply(ctx, api, empire.Vars{"VERSION": &v2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tag can't be moved to the new config.
+	if _, err := e.ConfigsTagAll("pre-migration"); err != empire.ErrFrozenExists {
+		t.Fatalf("err => %v; want %v", err, empire.ErrFrozenExists)
+	}
+
+	configs, err := e.ConfigsRollbackAllToTag(ctx, "pre-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(configs), 1; got != want {
+		t.Fatalf("len(configs) => %d; want %d", got, want)
+	}
+
+	if got, want := configs["acme-api"].Vars, (empire.Vars{"VERSION": &v1}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	// Rolling back doesn't move the tag either.
+	frozen, err := e.ConfigsResolveFrozen(api, "pre-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := frozen.ID, c.ID; got != want {
+		t.Fatalf("frozen ID => %s; want %s", got, want)
+	}
+}
+
+func TestConfigsSetOwner(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigsSetOwner(ctx, app, "PAYMENTS_KEY", "payments"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigsSetOwner(ctx, app, "SEARCH_URL", "search"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving a variable to another team replaces the owner.
+	if err := e.ConfigsSetOwner(ctx, app, "SEARCH_URL", "platform"); err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := e.ConfigsOwners(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[empire.Variable]string{
+		"PAYMENTS_KEY": "payments",
+		"SEARCH_URL":   "platform",
+	}
+
+	if got, want := owners, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsOwners => %v; want %v", got, want)
+	}
+
+	if err := e.ConfigsSetOwner(ctx, app, "PAYMENTS_KEY", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err = e.ConfigsOwners(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := owners, (map[empire.Variable]string{"SEARCH_URL": "platform"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsOwners => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsRewriteHistoryVarName(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, x := "a", "b", "x"
+	for _, vars := range []empire.Vars{
+		{"LEAKED_NAME": &a},
+		{"LEAKED_NAME": &b},
+		{"OTHER": &x},
+	} {
+		if _, err := e.ConfigsApply(ctx, app, vars); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := e.ConfigsRewriteHistoryVarName(ctx, app, "LEAKED_NAME", "SCRUBBED")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 3; got != want {
+		t.Fatalf("ConfigsRewriteHistoryVarName => %d; want %d", got, want)
+	}
+
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Vars, (empire.Vars{"SCRUBBED": &b, "OTHER": &x}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	// The stored fingerprint is updated to match the new vars.
+	if c.VarsFingerprint == nil || *c.VarsFingerprint != c.Fingerprint() {
+		t.Fatalf("VarsFingerprint => %v; want %s", c.VarsFingerprint, c.Fingerprint())
+	}
+
+	history, err := e.ConfigsHistoryFor(app, "LEAKED_NAME", empire.ConfigsHistoryForOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(history), 0; got != want {
+		t.Fatalf("len(history) => %d; want %d", got, want)
+	}
+
+	// OTHER and SCRUBBED are both set in the current config.
+	if _, err := e.ConfigsRewriteHistoryVarName(ctx, app, "SCRUBBED", "OTHER"); err != empire.ErrRewriteConflict {
+		t.Fatalf("err => %v; want %v", err, empire.ErrRewriteConflict)
+	}
+
+	if _, err := e.ConfigsFreeze(app, "stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsRewriteHistoryVarName(ctx, app, "OTHER", "RENAMED"); err != empire.ErrRewriteFrozen {
+		t.Fatalf("err => %v; want %v", err, empire.ErrRewriteFrozen)
+	}
+
+	if err := e.ConfigsLock(app, "migration"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsRewriteHistoryVarName(ctx, app, "SCRUBBED", "RENAMED"); err == nil {
+		t.Fatal("expected an error rewriting a locked config")
+	}
+}
+
+func TestConfigsBackfillOrdering(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", empiretest.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Seed the configs like an import would, in insertion order: some
+	// without a created_at, and some with identical created_ats.
+	t0 := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	seeds := []*time.Time{nil, &t0, nil, &t1, &t1}
+
+	var ids []string
+	for i, createdAt := range seeds {
+		var id string
+		if err := db.QueryRow(`insert into configs (app_id, vars, created_at) values ($1, hstore('N', $2), $3) returning id`, app.ID, strconv.Itoa(i), createdAt).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// A null created_at sorts first, so the current config is whichever
+	// config without one was inserted last.
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.ID, ids[2]; got != want {
+		t.Fatalf("current ID => %s; want %s", got, want)
+	}
+
+	n, err := e.ConfigsBackfillOrdering()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 2; got != want {
+		t.Fatalf("ConfigsBackfillOrdering => %d; want %d", got, want)
+	}
+
+	c, err = e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last config inserted is current, even though its created_at is
+	// identical to the one before it.
+	if got, want := c.ID, ids[4]; got != want {
+		t.Fatalf("current ID => %s; want %s", got, want)
+	}
+
+	history, err := e.ConfigsHistoryDiffs(app, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, d := range history {
+		order = append(order, d.ConfigID)
+	}
+
+	if got, want := order, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("history => %v; want %v", got, want)
+	}
+
+	// The first config has no config before it, so it takes the
+	// created_at of the config after it.
+	if got, want := *history[4].CreatedAt, t0; !got.Equal(want) {
+		t.Fatalf("CreatedAt => %v; want %v", got, want)
+	}
+
+	n, err = e.ConfigsBackfillOrdering()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 0; got != want {
+		t.Fatalf("ConfigsBackfillOrdering => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsPruneAll(t *testing.T) {
+	// Prune each app in its own statement, so that batching is exercised.
+	e := empiretest.NewEmpireWithOptions(t, func(opts *empire.Options) {
+		opts.Configs.PruneBatchSize = 1
+	})
+	ctx := context.Background()
+
+	if _, err := e.ConfigsPruneAll(0); err != empire.ErrInvalidRetention {
+		t.Fatalf("err => %v; want %v", err, empire.ErrInvalidRetention)
+	}
+
+	// Every config of a released app is referenced by a release.
+	released := mustDeployImage(t, e)
+
+	var apps []*empire.App
+	for _, name := range []string{"acme-api", "acme-small", "acme-empty"} {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, app)
+	}
+	api, small := apps[0], apps[1]
+
+	values := []string{"1", "2", "3", "4", "5"}
+	ids := make(map[string]string)
+	for _, v := range values {
+		v := v
+		vars := empire.Vars{"V": &v}
+
+		if _, err := e.ConfigsApply(ctx, released, vars); err != nil {
+			t.Fatal(err)
+		}
+
+		var c *empire.Config
+		switch v {
+		case "3":
+			// Configs created by approving a proposal are kept.
+			p, err := e.ConfigsPropose(api, vars, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err = e.ConfigsApprove(ctx, p.ID, "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+		default:
+			var err error
+			c, err = e.ConfigsApply(ctx, api, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[v] = c.ID
+
+		// Frozen configs are kept.
+		if v == "2" {
+			if _, err := e.ConfigsFreeze(api, "v2"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	one := "1"
+	if _, err := e.ConfigsApply(ctx, small, empire.Vars{"V": &one}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scheduled changes aren't configs, so they don't count against the
+	// configs that are kept.
+	six := "6"
+	if _, err := e.ConfigsSchedule(ctx, api, empire.Vars{"V": &six}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := e.ConfigsPruneAll(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial empty config, and V=1, are the only configs that can
+	// be deleted.
+	if got, want := deleted, map[string]int{"acme-api": 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsPruneAll => %v; want %v", got, want)
+	}
+
+	history, err := e.ConfigsHistoryDiffs(api, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	for _, d := range history {
+		kept = append(kept, d.ConfigID)
+	}
+
+	if got, want := kept, []string{ids["5"], ids["4"], ids["3"], ids["2"]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kept => %v; want %v", got, want)
+	}
+
+	// Keeping a single config still keeps the current config, along with
+	// the protected ones.
+	deleted, err = e.ConfigsPruneAll(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := deleted, map[string]int{"acme-api": 1, "acme-small": 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsPruneAll => %v; want %v", got, want)
+	}
+
+	c, err := e.ConfigsCurrent(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.ID, ids["5"]; got != want {
+		t.Fatalf("current ID => %s; want %s", got, want)
+	}
+
+	history, err = e.ConfigsHistoryDiffs(released, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(history), len(values)+1; got != want {
+		t.Fatalf("len(released history) => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsCoalesceHistory(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, two := "1", "2"
+	var ids []string
+	for _, v := range []*string{&one, &one, &two, &one, &one} {
+		c, err := e.ConfigsApply(ctx, app, empire.Vars{"V": v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, c.ID)
+	}
+
+	// The same vars, but the variable now expires.
+	c, err := e.ConfigsSetWithTTL(ctx, app, "V", "1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, c.ID)
+
+	n, err := e.ConfigsCoalesceHistory(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second config repeats the first, and the fifth repeats the
+	// fourth. Configs that repeat an earlier, but not the previous, state
+	// are kept, as are configs whose expiries differ.
+	if got, want := n, 2; got != want {
+		t.Fatalf("ConfigsCoalesceHistory => %d; want %d", got, want)
+	}
+
+	history, err := e.ConfigsHistoryDiffs(app, empire.ConfigsHistoryDiffsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	for _, d := range history {
+		kept = append(kept, d.ConfigID)
+	}
+
+	// The empty config that the app started with comes first.
+	if got, want := kept[:len(kept)-1], []string{ids[5], ids[3], ids[2], ids[0]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kept => %v; want %v", got, want)
+	}
+
+	n, err = e.ConfigsCoalesceHistory(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 0; got != want {
+		t.Fatalf("ConfigsCoalesceHistory => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsFreeze(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, v2 := "v1", "v2"
+	c, err := e.ConfigsApply(ctx, app, empire.Vars{"VERSION": &v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := e.ConfigsFreeze(app, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := id, c.ID; got != want {
+		t.Fatalf("ConfigsFreeze => %s; want %s", got, want)
+	}
+
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"VERSION": &v2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A frozen config can't be moved to the new config.
+	if _, err := e.ConfigsFreeze(app, "stable"); err != empire.ErrFrozenExists {
+		t.Fatalf("err => %v; want %v", err, empire.ErrFrozenExists)
+	}
+
+	frozen, err := e.ConfigsResolveFrozen(app, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := frozen.ID, c.ID; got != want {
+		t.Fatalf("frozen ID => %s; want %s", got, want)
+	}
+
+	if got, want := frozen.Vars, (empire.Vars{"VERSION": &v1}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+
+	// Names are scoped to the app.
+	other, err := e.AppsCreate(&empire.App{Name: "acme-other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsResolveFrozen(other, "stable"); err != empire.ErrFrozenNotFound {
+		t.Fatalf("err => %v; want %v", err, empire.ErrFrozenNotFound)
+	}
+
+	if _, err := e.ConfigsFreeze(other, "stable"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigsVarPrevalence(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	x := "x"
+	for name, vars := range map[string][]empire.Vars{
+		"acme-api": {{"SHARED": &x, "API_ONLY": &x}},
+		"acme-web": {{"SHARED": &x}},
+		// Only the current config is counted.
+		"acme-worker": {{"SHARED": &x, "REMOVED": &x}, {"REMOVED": nil}},
+		"acme-empty":  nil,
+	} {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range vars {
+			if _, err := e.ConfigsApply(ctx, app, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	prevalence, err := e.ConfigsVarPrevalence(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := prevalence, (map[empire.Variable]int{"SHARED": 3, "API_ONLY": 1}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsVarPrevalence => %v; want %v", got, want)
+	}
+
+	prevalence, err = e.ConfigsVarPrevalence(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := prevalence, (map[empire.Variable]int{"SHARED": 3}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsVarPrevalence => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsAppsMissingVar(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	x, empty := "x", ""
+	for name, vars := range map[string]empire.Vars{
+		"acme-set":     {"SENTRY_DSN": &x},
+		"acme-blank":   {"SENTRY_DSN": &empty},
+		"acme-missing": {"OTHER": &x},
+		"acme-empty":   nil,
+		"acme-expires": nil,
+	} {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vars != nil {
+			if _, err := e.ConfigsApply(ctx, app, vars); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if name == "acme-expires" {
+			if _, err := e.ConfigsSetWithTTL(ctx, app, "SENTRY_DSN", x, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	apps, err := e.ConfigsAppsMissingVar("SENTRY_DSN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := apps, []string{"acme-blank", "acme-empty", "acme-missing"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsAppsMissingVar => %v; want %v", got, want)
+	}
+
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	apps, err = e.ConfigsAppsMissingVar("SENTRY_DSN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := apps, []string{"acme-blank", "acme-empty", "acme-expires", "acme-missing"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsAppsMissingVar => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsCurrentVars(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apps without a config don't have any vars.
+	vars, err := e.ConfigsCurrentVars(app, []empire.Variable{"RAILS_ENV"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentVars => %v; want %v", got, want)
+	}
+
+	production, secret, token := "production", "s3cr3t", "abc"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &production, "SECRET": &secret}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsSetWithTTL(ctx, app, "TOKEN", token, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []empire.Variable{"RAILS_ENV", "TOKEN", "MISSING"}
+
+	vars, err = e.ConfigsCurrentVars(app, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{"RAILS_ENV": &production, "TOKEN": &token}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentVars => %v; want %v", got, want)
+	}
+
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	vars, err = e.ConfigsCurrentVars(app, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{"RAILS_ENV": &production}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentVars => %v; want %v", got, want)
+	}
+
+	vars, err = e.ConfigsCurrentVars(app, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentVars => %v; want %v", got, want)
+	}
+}
+
+// maxWriteRecorder records the size of the largest single write.
+type maxWriteRecorder struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestConfigsStreamCurrent(t *testing.T) {
+	const n = 5000
+
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := make(empire.Vars, n)
+	for i := 0; i < n; i++ {
+		v := fmt.Sprintf("value %d", i)
+		vars[empire.Variable(fmt.Sprintf("VAR_%04d", i))] = &v
+	}
+
+	secret := "s3cr3t"
+	vars["API_KEY"] = &secret
+
+	if _, err := e.ConfigsApply(ctx, app, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsSetWithTTL(ctx, app, "TOKEN", "abc", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	w := new(maxWriteRecorder)
+	if err := e.ConfigsStreamCurrent(app, w, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write should only contain a single variable, rather than the
+	// whole config.
+	if w.max > 64 {
+		t.Fatalf("largest write => %d bytes; want at most 64", w.max)
+	}
+
+	var names []empire.Variable
+	got := make(empire.Vars)
+	scanner := bufio.NewScanner(&w.Buffer)
+	for scanner.Scan() {
+		var entry struct {
+			Name  empire.Variable `json:"name"`
+			Value *string         `json:"value"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, entry.Name)
+		got[entry.Name] = entry.Value
+	}
+
+	// The expired TOKEN isn't included.
+	if got, want := len(names), n+1; got != want {
+		t.Fatalf("lines => %d; want %d", got, want)
+	}
+
+	if got, want := names[0], empire.Variable("API_KEY"); got != want {
+		t.Fatalf("first => %s; want %s", got, want)
+	}
+
+	if got, want := names[len(names)-1], empire.Variable(fmt.Sprintf("VAR_%04d", n-1)); got != want {
+		t.Fatalf("last => %s; want %s", got, want)
+	}
+
+	if got, want := *got["API_KEY"], "***"; got != want {
+		t.Fatalf("API_KEY => %s; want %s", got, want)
+	}
+
+	if got, want := *got["VAR_0042"], "value 42"; got != want {
+		t.Fatalf("VAR_0042 => %s; want %s", got, want)
+	}
+}
+
+func TestConfigsConvertLegacyJSON(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	production := "production"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &production}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Configs stored as an hstore are left alone.
+	n, err := e.ConfigsConvertLegacyJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 0; got != want {
+		t.Fatalf("ConfigsConvertLegacyJSON => %d; want %d", got, want)
+	}
+
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Vars, (empire.Vars{"RAILS_ENV": &production}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Vars => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsDeprecatedVars(t *testing.T) {
+	var warnings []empire.Warning
+	e := empiretest.NewEmpireWithOptions(t, func(opts *empire.Options) {
+		opts.Configs.DeprecatedVars = map[empire.Variable]string{"REDIS_URL": "use REDIS_ADDR"}
+		opts.Configs.WarningHandler = func(app *empire.App, w []empire.Warning) {
+			warnings = append(warnings, w...)
+		}
+	})
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redis := "redis://localhost"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"REDIS_URL": &redis}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []empire.Warning{{Name: "REDIS_URL", Message: "deprecated: use REDIS_ADDR"}}
+	if got, want := warnings, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings => %v; want %v", got, want)
+	}
+
+	// Reading the config warns too.
+	if _, err := e.ConfigsCurrent(app); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := warnings, append(expected, expected...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsCurrentKeys(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	now := timex.Now
+	defer func() { timex.Now = now }()
+	timex.Now = func() time.Time {
+		return start
+	}
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apps without a config don't have any keys.
+	keys, err := e.ConfigsCurrentKeys(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := keys, []empire.Variable{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentKeys => %v; want %v", got, want)
+	}
+
+	production, secret := "production", "s3cr3t"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &production, "SECRET": &secret}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsSetWithTTL(ctx, app, "TOKEN", "abc", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err = e.ConfigsCurrentKeys(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := keys, []empire.Variable{"RAILS_ENV", "SECRET", "TOKEN"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentKeys => %v; want %v", got, want)
+	}
+
+	// Expired variables are left out.
+	timex.Now = func() time.Time {
+		return start.Add(2 * time.Hour)
+	}
+
+	keys, err = e.ConfigsCurrentKeys(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := keys, []empire.Variable{"RAILS_ENV", "SECRET"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsCurrentKeys => %v; want %v", got, want)
+	}
+}
+
+func TestSeedConfigs(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	production, staging, redis := "production", "staging", "redis://localhost"
+
+	if err := empiretest.SeedConfigs(e, map[string]empire.Vars{
+		"acme-inc": {"RAILS_ENV": &production, "REDIS_URL": &redis},
+		"other":    {"RAILS_ENV": &staging},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seeding again makes the head exactly the fixture.
+	if err := empiretest.SeedConfigs(e, map[string]empire.Vars{
+		"acme-inc": {"RAILS_ENV": &staging},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		app  string
+		vars empire.Vars
+	}{
+		{"acme-inc", empire.Vars{"RAILS_ENV": &staging}},
+		{"other", empire.Vars{"RAILS_ENV": &staging}},
+	}
+
+	for _, tt := range tests {
+		app, err := e.AppsFirst(empire.AppsQuery{Name: &tt.app})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.app, err)
+		}
+
+		c, err := e.ConfigsCurrent(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := c.Vars, tt.vars; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Vars => %v; want %v", tt.app, got, want)
+		}
+	}
+}
+
+func TestConfigsApply_DefaultValidators(t *testing.T) {
+	// No Validators are configured, so DefaultValidators are used.
+	e := empiretest.NewEmpire(t)
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nul := "a\x00b"
+	_, err = e.ConfigsApply(ctx, app, empire.Vars{"NUL": &nul})
+
+	verr, ok := err.(*empire.ValidationError)
+	if !ok {
+		t.Fatalf("err => %v; want a ValidationError", err)
+	}
+
+	expected := empire.VarErrors{&empire.VarError{Name: "NUL", Err: empire.ErrNULByte}}
+	if got, want := verr.Err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Err => %v; want %v", got, want)
+	}
+
+	// Nothing was stored.
+	c, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(c.Vars), 0; got != want {
+		t.Fatalf("len(Vars) => %d; want %d", got, want)
+	}
+}
+
+func TestConfigsApply_StorageValidators(t *testing.T) {
+	// A validator that allows anything doesn't stop vars that can't be stored
+	// from being rejected.
+	e := empiretest.NewEmpireWithOptions(t, func(o *empire.Options) {
+		o.Configs.Validators = []empire.Validator{
+			empire.ValidatorFunc(func(name empire.Variable, value *string) error { return nil }),
+		}
+	})
+	ctx := context.Background()
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bar := "bar"
+	_, err = e.ConfigsApply(ctx, app, empire.Vars{`FOO"`: &bar})
+
+	verr, ok := err.(*empire.ValidationError)
+	if !ok {
+		t.Fatalf("err => %v; want a ValidationError", err)
+	}
+
+	expected := empire.VarErrors{&empire.VarError{Name: `FOO"`, Err: empire.ErrHstoreUnsafe}}
+	if got, want := verr.Err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Err => %v; want %v", got, want)
+	}
+}
+
+func TestConfigGroups(t *testing.T) {
+	e := empiretest.NewEmpireWithOptions(t, func(opts *empire.Options) {
+		opts.Configs.NotifyChanges = true
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var apps []*empire.App
+	for _, name := range []string{"acme-web", "acme-api", "acme-other"} {
+		app, err := e.AppsCreate(&empire.App{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, app)
+	}
+	web, api := apps[0], apps[1]
+
+	old := "old"
+	group, err := e.ConfigGroupsCreate("shared", empire.Vars{"SECRET": &old})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigGroupsCreate("shared", nil); err != empire.ErrConfigGroupExists {
+		t.Fatalf("err => %v; want %v", err, empire.ErrConfigGroupExists)
+	}
+
+	unused, err := e.ConfigGroupsCreate("unused", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []*empire.App{web, api} {
+		if err := e.ConfigsAttachGroup(app, "shared"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := e.AppsUsingGroup(group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names, []string{"acme-api", "acme-web"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AppsUsingGroup => %v; want %v", got, want)
+	}
+
+	names, err = e.AppsUsingGroup(unused.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names, []string{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AppsUsingGroup => %#v; want %#v", got, want)
+	}
+
+	// Variables in an apps config take precedence over the group.
+	own := "own"
+	if _, err := e.ConfigsApply(ctx, api, empire.Vars{"SECRET": &own}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := e.ConfigsListenChanges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := "rotated"
+	if _, err := e.ConfigGroupsSet("shared", empire.Vars{"SECRET": &rotated}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exactly the apps that use the group are notified.
+	notified := make(map[string]bool)
+	for len(notified) < 2 {
+		select {
+		case c := <-changes:
+			if got, want := c.ConfigID, ""; got != want {
+				t.Fatalf("ConfigID => %s; want %s", got, want)
+			}
+			notified[c.AppID] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for config changes")
+		}
+	}
+
+	if got, want := notified, map[string]bool{web.ID: true, api.ID: true}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("notified => %v; want %v", got, want)
+	}
+
+	vars, err := e.ConfigsEffective(web)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{"SECRET": &rotated}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("web ConfigsEffective => %v; want %v", got, want)
+	}
+
+	vars, err = e.ConfigsEffective(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{"SECRET": &own}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("api ConfigsEffective => %v; want %v", got, want)
+	}
+}
+
+func TestConfigGroupsInheritance(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	app, err := e.AppsCreate(&empire.App{Name: "acme-inc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, shared := "base", "shared"
+	a, err := e.ConfigGroupsCreate("a", empire.Vars{"A": &base, "B": &base})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigGroupsCreate("b", empire.Vars{"B": &shared}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigGroupsCreate("c", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// c inherits b, which inherits a.
+	if err := e.ConfigGroupsSetParent("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigGroupsSetParent("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ConfigsAttachGroup(app, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := e.AppsUsingGroup(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := names, []string{"acme-inc"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AppsUsingGroup => %v; want %v", got, want)
+	}
+
+	vars, err := e.ConfigsEffective(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := vars, (empire.Vars{"A": &base, "B": &shared}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ConfigsEffective => %v; want %v", got, want)
+	}
+
+	// Making a inherit c would close the loop.
+	err = e.ConfigGroupsSetParent("a", "c")
+
+	expected := &empire.InheritanceCycleError{Chain: []string{"a", "c", "b", "a"}}
+	if got, want := err, error(expected); !reflect.DeepEqual(got, want) {
+		t.Fatalf("err => %v; want %v", got, want)
+	}
+}
+
+func TestConfigsEncryption(t *testing.T) {
+	k1, err := empire.NewAESMasterKey("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := empire.NewAESMasterKey("k2", bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts empire.Options
+	e := empiretest.NewEmpireWithOptions(t, func(o *empire.Options) {
+		o.Configs.MasterKeys = []empire.MasterKey{k1}
+		o.Configs.VerifyIntegrity = true
+		opts = *o
+	})
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", empiretest.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	app := mustDeployImage(t, e)
+
+	secret := "hunter2"
+	if _, err := e.ConfigsApply(ctx, app, empire.Vars{"SECRET": &secret}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values are stored encrypted.
+	var stored string
+	if err := db.QueryRow(`select vars -> 'SECRET' from configs where app_id = $1 and exist(vars, 'SECRET')`, app.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored == secret {
+		t.Fatal("SECRET is stored in plaintext")
+	}
+
+	check := func(e *empire.Empire) {
+		c, err := e.ConfigsCurrent(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := c.Vars["SECRET"], &secret; got == nil || *got != *want {
+			t.Fatalf("SECRET => %v; want %v", got, *want)
+		}
+
+		r, err := e.ReleasesLast(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := r.Config.Vars["SECRET"], &secret; got == nil || *got != *want {
+			t.Fatalf("release SECRET => %v; want %v", got, *want)
+		}
+	}
+
+	check(e)
+
+	// Rotating the master key only rewraps the data key.
+	opts.Configs.MasterKeys = []empire.MasterKey{k2, k1}
+	rotated, err := empire.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := rotated.ConfigsRewrapDataKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 1; got != want {
+		t.Fatalf("ConfigsRewrapDataKeys => %d; want %d", got, want)
+	}
+
+	// The values weren't re-encrypted.
+	var count int
+	if err := db.QueryRow(`select count(*) from configs where app_id = $1 and vars -> 'SECRET' = $2`, app.ID, stored).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count == 0 {
+		t.Fatal("SECRET was re-encrypted")
+	}
+
+	// The old master key is no longer needed.
+	opts.Configs.MasterKeys = []empire.MasterKey{k2}
+	e2, err := empire.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(e2)
+}
+
+func TestConfigsLineage(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+	ctx := empire.WithUser(context.Background(), &empire.User{Name: "fake"})
+
+	// v1 is released with the apps first config.
+	app := mustDeployImage(t, e)
+
+	first, err := e.ConfigsCurrent(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.ConfigsFreeze(app, "initial"); err != nil {
+		t.Fatal(err)
+	}
+
+	// v2 sets a var.
+	v := "production"
+	second, err := e.ConfigsApply(ctx, app, empire.Vars{"RAILS_ENV": &v})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v3 is a rollback to v1.
+	r, err := e.ReleasesRollback(ctx, app, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollback := r.Config
+	if rollback.ID == first.ID {
+		t.Fatal("expected the rollback to create a new config")
+	}
+
+	g, err := e.ConfigsLineage(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, n := range g.Nodes {
+		ids = append(ids, n.ConfigID)
+	}
+
+	if got, want := ids, []string{first.ID, second.ID, rollback.ID}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Nodes => %v; want %v", got, want)
+	}
+
+	if got, want := g.Nodes[0].Tags, []string{"initial"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tags => %v; want %v", got, want)
+	}
+
+	if got, want := g.Nodes[1].Actor, "fake"; got != want {
+		t.Fatalf("Actor => %q; want %q", got, want)
+	}
+
+	expected := []empire.LineageEdge{
+		{From: second.ID, To: first.ID, Kind: empire.LineageParent},
+		{From: rollback.ID, To: second.ID, Kind: empire.LineageParent},
+		{From: rollback.ID, To: first.ID, Kind: empire.LineageRestores},
+	}
+
+	if got, want := g.Edges, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Edges => %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remind101/empire"
+	"github.com/remind101/empire/empiretest"
+	"github.com/remind101/empire/server"
+)
+
+func TestMetrics(t *testing.T) {
+	e := empiretest.NewEmpire(t)
+
+	opts := server.Options{}
+	opts.Metrics.Enabled = true
+	s := httptest.NewServer(server.New(e, opts))
+	defer s.Close()
+
+	token, err := e.AccessTokensCreate(&empire.AccessToken{
+		User: &empire.User{Name: "fake", GitHubToken: "token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		status   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"invalid", http.StatusUnauthorized},
+		{token.Token, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", s.URL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.password != "" {
+			req.SetBasicAuth("", tt.password)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Errorf("%q: StatusCode => %d; want %d", tt.password, got, want)
+		}
+	}
+}